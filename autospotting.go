@@ -1,20 +1,33 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/cristim/autospotting/core"
 	"github.com/cristim/ec2-instances-info"
+	instancesdata "github.com/cristim/ec2-instances-info/data"
 	"github.com/namsral/flag"
 )
 
 type cfgData struct {
 	*autospotting.Config
+
+	// on-demand price multipliers given on the command line and in a file
+	priceMultipliers     string
+	priceMultipliersFile string
+
+	// keep running on a schedule instead of exiting after a single run
+	daemon bool
 }
 
 var conf *cfgData
@@ -25,39 +38,132 @@ var Version = "number missing"
 func main() {
 	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
 		lambda.Start(Handler)
-	} else {
-		run()
+	} else if runCommand() {
+		return
+	} else if conf.daemon {
+		runDaemon()
+	} else if _, err := run(context.Background()); err != nil {
+		os.Exit(1)
 	}
 }
 
-func run() {
+func run(ctx context.Context) (*autospotting.RunResult, error) {
 
 	log.Println("Starting autospotting agent, build", Version)
+	logFlags()
+
+	result, err := autospotting.RunContext(ctx, conf.Config)
+	if err != nil {
+		log.Println("Execution completed with errors:", err.Error())
+		return result, err
+	}
+
+	log.Println("Execution completed, nothing left to do")
+	return result, nil
+}
+
+// runDaemon keeps running on the configured schedule until receiving SIGTERM
+// or SIGINT, which let the instance replacements in progress finish.
+func runDaemon() {
 
+	log.Println("Starting autospotting daemon, build", Version)
+	logFlags()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Println("Received", sig, "signal, shutting down after the current run")
+		cancel()
+	}()
+
+	if err := autospotting.RunDaemon(ctx, conf.Config); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Daemon stopped")
+}
+
+func logFlags() {
 	log.Printf("Parsed command line flags: "+
 		"regions='%s' "+
+		"accounts='%s' "+
+		"accounts_file='%s' "+
+		"accounts_from_organization=%t "+
+		"assume_role_name='%s' "+
+		"daemon=%t "+
+		"schedule='%s' "+
+		"schedule_jitter=%s "+
+		"status_address='%s' "+
+		"record_file='%s' "+
+		"replay_file='%s' "+
+		"max_runtime=%s "+
+		"max_concurrent_regions=%d "+
+		"max_concurrent_groups=%d "+
+		"api_requests_per_second=%.1f "+
+		"api_max_retries=%d "+
 		"min_on_demand_number=%d "+
 		"min_on_demand_percentage=%.1f "+
 		"allowed_instance_types=%v "+
 		"disallowed_instance_types=%v "+
+		"selection_strategy=%s "+
+		"interruption_data='%s' "+
+		"max_interruption_band='%s' "+
+		"interruption_penalty_percentage=%.1f "+
+		"instance_data='%s' "+
+		"instance_data_overrides='%s' "+
+		"allow_burstable_instance_types=%t "+
+		"burstable_surplus_credits_percentage=%.1f "+
 		"on_demand_price_multiplier=%.2f "+
+		"on_demand_price_multipliers=%v "+
 		"spot_price_buffer_percentage=%.3f "+
 		"bidding_policy=%s "+
+		"live_on_demand_prices=%t "+
+		"price_cache='%s' "+
+		"price_cache_ttl=%s "+
+		"reserved_instances_aware=%t "+
 		"tag_filters=%s "+
 		"spot_product_description=%v",
 		conf.Regions,
+		conf.Accounts,
+		conf.AccountsFile,
+		conf.AccountsFromOrganization,
+		conf.AssumeRoleName,
+		conf.daemon,
+		conf.Schedule,
+		conf.ScheduleJitter,
+		conf.StatusAddress,
+		conf.RecordFile,
+		conf.ReplayFile,
+		conf.MaxRuntime,
+		conf.MaxConcurrentRegions,
+		conf.MaxConcurrentGroups,
+		conf.APIRequestsPerSecond,
+		conf.APIMaxRetries,
 		conf.MinOnDemandNumber,
 		conf.MinOnDemandPercentage,
 		conf.AllowedInstanceTypes,
 		conf.DisallowedInstanceTypes,
+		conf.SelectionStrategy,
+		conf.InterruptionDataSource,
+		conf.MaxInterruptionBand,
+		conf.InterruptionPenaltyPercentage,
+		conf.InstanceDataSource,
+		conf.InstanceDataOverrides,
+		conf.AllowBurstableInstanceTypes,
+		conf.BurstableSurplusCreditsPercentage,
 		conf.OnDemandPriceMultiplier,
+		conf.OnDemandPriceMultipliers,
 		conf.SpotPriceBufferPercentage,
 		conf.BiddingPolicy,
+		conf.LiveOnDemandPrices,
+		conf.PriceCache,
+		conf.PriceCacheTTL,
+		conf.ReservedInstancesAware,
 		conf.FilterByTags,
 		conf.SpotProductDescription)
-
-	autospotting.Run(conf.Config)
-	log.Println("Execution completed, nothing left to do")
 }
 
 // this is the equivalent of a main for when running from Lambda, but on Lambda
@@ -72,7 +178,7 @@ func init() {
 	}
 
 	conf = &cfgData{
-		&autospotting.Config{
+		Config: &autospotting.Config{
 			LogFile:         os.Stdout,
 			LogFlag:         log.Ldate | log.Ltime | log.Lshortfile,
 			MainRegion:      region,
@@ -84,9 +190,19 @@ func init() {
 
 }
 
-// Handler implements the AWS Lambda handler
-func Handler(request events.APIGatewayProxyRequest) {
-	run()
+// Handler implements the AWS Lambda handler, the context carries the deadline
+// of the invocation. The result of the run is returned as JSON, and the
+// invocation only fails if the whole run failed, since the asynchronous
+// invocations are retried, processing again the groups which succeeded. The
+// failures of the regions and groups are reported in the result.
+func Handler(ctx context.Context, request events.APIGatewayProxyRequest) (*autospotting.RunResult, error) {
+	result, _ := run(ctx)
+
+	// the results of the asynchronous invocations are discarded
+	if data, err := json.Marshal(result); err == nil {
+		log.Println("Run result:", string(data))
+	}
+	return result, result.RunErr()
 }
 
 // Configuration handling
@@ -99,6 +215,33 @@ func (c *cfgData) initialize() {
 		log.Fatal(err.Error())
 	}
 	c.InstanceData = data
+
+	// the on-demand prices of the other operating systems are only available
+	// in the raw data
+	raw, err := instancesdata.Asset("data/instances.json")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	osPrices, err := autospotting.ParseOSPrices(raw)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	c.OSPrices = osPrices
+
+	multipliers, err := autospotting.ParsePriceMultipliers(c.priceMultipliers)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if c.priceMultipliersFile != "" {
+		fileMultipliers, err := autospotting.LoadPriceMultipliers(c.priceMultipliersFile)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		multipliers = append(multipliers, fileMultipliers...)
+	}
+	c.OnDemandPriceMultipliers = multipliers
 }
 
 func (c *cfgData) parseCommandLineFlags() {
@@ -108,6 +251,83 @@ func (c *cfgData) parseCommandLineFlags() {
 			"also supports globs), by default it runs on all regions.\n\t"+
 			"Example: ./autospotting -regions 'eu-*,us-east-1'\n")
 
+	flag.StringVar(&c.Accounts, "accounts", "",
+		"\n\tAccounts to be processed instead of the current one, by assuming the role given using\n"+
+			"\tassume_role_name in each of them (comma or whitespace separated list of account IDs).\n"+
+			"\tExample: ./autospotting -accounts '123456789012,210987654321' -assume_role_name AutoSpotting\n")
+
+	flag.StringVar(&c.AccountsFile, "accounts_file", "",
+		"\n\tFile containing more accounts to be processed, one or more account IDs per line.\n"+
+			"\tEverything following a # is ignored.\n")
+
+	flag.BoolVar(&c.AccountsFromOrganization, "accounts_from_organization", false,
+		"\n\tProcess all the active accounts of the AWS Organization, when running in its master account.\n")
+
+	flag.IntVar(&c.APIMaxRetries, "api_max_retries", autospotting.DefaultAPIMaxRetries,
+		"\n\tNumber of retries of the API calls failed because of throttling or transient errors,\n"+
+			"\tusing exponential backoff between the attempts.\n")
+
+	flag.Float64Var(&c.APIRequestsPerSecond, "api_requests_per_second", 0,
+		"\n\tMaximum rate of the EC2 and AutoScaling API calls, shared across all regions, in order to\n"+
+			"\tavoid RequestLimitExceeded errors in accounts with many groups. By default it's not limited.\n")
+
+	flag.StringVar(&c.AssumeRoleName, "assume_role_name", "",
+		"\n\tName of the IAM role assumed in each of the processed accounts. The role needs the same\n"+
+			"\tpermissions as the AutoSpotting Lambda function, and to trust the current account.\n")
+
+	flag.StringVar(&c.AssumeRoleExternalID, "assume_role_external_id", "",
+		"\n\tOptional external ID used when assuming the role in each of the processed accounts.\n")
+
+	flag.BoolVar(&c.daemon, "daemon", false,
+		"\n\tKeep running and process all the regions on the schedule given by the -schedule flag,\n"+
+			"\tinstead of exiting after a single run. SIGTERM and SIGINT stop the daemon after the\n"+
+			"\tinstance replacements in progress are completed.\n")
+
+	flag.IntVar(&c.MaxConcurrentGroups, "max_concurrent_groups", 0,
+		"\n\tMaximum number of AutoScaling groups processed in parallel, which is a global limit\n"+
+			"\tshared by all the regions and accounts rather than a limit for each region, so the\n"+
+			"\tregions having many groups may delay the processing of the other ones. By default\n"+
+			"\tall of them are processed in parallel.\n")
+
+	flag.IntVar(&c.MaxConcurrentRegions, "max_concurrent_regions", 0,
+		"\n\tMaximum number of regions processed in parallel. By default all of them are processed\n"+
+			"\tin parallel.\n")
+
+	flag.DurationVar(&c.MaxRuntime, "max_runtime", 0,
+		"\n\tMaximum duration of a run, after which no new instance replacements are started while the\n"+
+			"\tones in progress are completed. By default it is only limited by the Lambda function timeout.\n"+
+			"\tExample: ./autospotting -max_runtime 4m\n")
+
+	flag.StringVar(&c.Schedule, "schedule", autospotting.DefaultSchedule,
+		"\n\tWhen running as a daemon, either the interval between runs or a cron expression with the\n"+
+			"\tminute, hour, day of month, month and day of week fields, evaluated in the local time zone.\n"+
+			"\tExample: ./autospotting -daemon -schedule '*/10 8-18 * * 1-5'\n")
+
+	flag.DurationVar(&c.ScheduleJitter, "schedule_jitter", 0,
+		"\n\tWhen running as a daemon, maximum random delay added to each run, in order to spread the\n"+
+			"\tAPI calls of multiple daemons over time.\n")
+
+	flag.StringVar(&c.StatusAddress, "status_address", "",
+		"\n\tWhen running as a daemon, address of the HTTP API serving /healthz, /status, /metrics\n"+
+			"\tin the Prometheus format and POST /run?region=&asg= processing a single group, which\n"+
+			"\trequires status_token. Bind it to a private address, since the API isn't encrypted.\n"+
+			"\tExample: ./autospotting -daemon -status_address 127.0.0.1:8080\n")
+
+	flag.StringVar(&c.StatusToken, "status_token", "",
+		"\n\tToken required by POST /run in the Authorization header, as 'Bearer <token>'. Processing\n"+
+			"\tgroups through the API is disabled unless it is set. It can also be given using the\n"+
+			"\tSTATUS_TOKEN environment variable, keeping it out of the process list.\n")
+
+	flag.StringVar(&c.RecordFile, "record_file", "",
+		"\n\tFile where all the AWS API calls of the runs are appended, without the user data of the\n"+
+			"\tinstances, so they can be replayed later using -replay_file.\n"+
+			"\tExample: ./autospotting -record_file /tmp/autospotting-calls.json\n")
+
+	flag.StringVar(&c.ReplayFile, "replay_file", "",
+		"\n\tFile recorded using -record_file, whose API calls are replayed instead of calling AWS,\n"+
+			"\twith a simulated time following the one of the recorded run.\n"+
+			"\tExample: ./autospotting -replay_file /tmp/autospotting-calls.json\n")
+
 	flag.Int64Var(&c.MinOnDemandNumber, "min_on_demand_number", autospotting.DefaultMinOnDemandValue,
 		"\n\tOn-demand capacity (as absolute number) ensured to be running in each of your groups.\n\t"+
 			"Can be overridden on a per-group basis using the tag "+
@@ -124,18 +344,110 @@ func (c *cfgData) parseCommandLineFlags() {
 		"\n\tIf specified, the spot instances will be of these types.\n"+
 			"\tIf missing, the type is autodetected frome each ASG based on it's Launch Configuration.\n"+
 			"\tAccepts a list of comma or whitespace seperated instance types (supports globs).\n"+
-			"\tExample: ./autospotting -allowed_instance_types 'c5.*,c4.xlarge'\n")
+			"\tThe list may also contain the special value 'current', the policies 'same-family',\n"+
+			"\t'same-generation-or-newer' and 'current-generation-only', and the category filters\n"+
+			"\t'general-purpose', 'compute', 'memory', 'storage' and 'accelerated'.\n"+
+			"\tExample: ./autospotting -allowed_instance_types 'c5.*,c4.xlarge'\n"+
+			"\tExample: ./autospotting -allowed_instance_types 'compute,memory,current-generation-only'\n")
 
 	flag.StringVar(&c.DisallowedInstanceTypes, "disallowed_instance_types", "",
 		"\n\tIf specified, the spot instances will _never_ be of these types.\n"+
 			"\tAccepts a list of comma or whitespace seperated instance types (supports globs).\n"+
+			"\tIt is ignored when allowed_instance_types lists instance types, but still applies\n"+
+			"\twhen allowed_instance_types only contains policies and category filters.\n"+
 			"\tExample: ./autospotting -disallowed_instance_types 't2.*,c4.xlarge'\n")
 
+	flag.StringVar(&c.SelectionStrategy, "selection_strategy", autospotting.DefaultSelectionStrategy,
+		"\n\tStrategy choosing the spot instance type among the compatible ones. Valid choices:\n"+
+			"\t'cheapest', 'cheapest-per-vcpu', 'cheapest-per-gib', 'lowest-interruption-risk' and\n"+
+			"\t'priority', which prefers the instance types in the order given by the tag\n"+
+			"\t"+autospotting.PreferredInstanceTypesTag+" (supports globs), the other ones coming last.\n"+
+			"\tThe 'lowest-interruption-risk' strategy requires the data given using interruption_data.\n"+
+			"\tCan be overridden on a per-group basis using the tag "+
+			autospotting.SelectionStrategyTag+".\n")
+
+	flag.StringVar(&c.InterruptionDataSource, "interruption_data", "",
+		"\n\tLoad the spot interruption frequency of the instance types in each region from a file in\n"+
+			"\tthe Spot Instance Advisor JSON format. Accepts a local path, an S3 or an HTTP(S) URL, and\n"+
+			"\tthe downloaded data is cached like the instance data.\n"+
+			"\tExample: ./autospotting -interruption_data 'https://spot-bid-advisor.s3.amazonaws.com/spot-advisor-data.json'\n")
+
+	flag.StringVar(&c.MaxInterruptionBand, "max_interruption_band", "",
+		"\n\tHighest acceptable interruption frequency band of the spot instance types, given as its\n"+
+			"\tindex from 0 to 4 or its label: '<5%', '5-10%', '10-15%', '15-20%' or '>20%'. The\n"+
+			"\tinstance types missing from the interruption data are still used. By default there is no limit.\n"+
+			"\tCan be overridden on a per-group basis using the tag "+
+			autospotting.MaxInterruptionBandTag+".\n")
+
+	flag.Float64Var(&c.InterruptionPenaltyPercentage, "interruption_penalty_percentage", 0.0,
+		"\n\tPercentage added to the price of the spot instance types for each interruption frequency\n"+
+			"\tband above the lowest one when ranking them, so the less interrupted instance types are\n"+
+			"\tpreferred unless significantly more expensive.\n"+
+			"\tExample: ./autospotting -interruption_penalty_percentage 10\n")
+
+	flag.BoolVar(&c.AllowBurstableInstanceTypes, "allow_burstable_instance_types", false,
+		"\n\tAllow burstable instance types such as t2 or t3 to replace fixed-performance instances.\n"+
+			"\tBurstable instances are always considered as replacements for other burstable instances.\n"+
+			"\tThose replacing fixed-performance instances use standard CPU credits, so they don't incur\n"+
+			"\tsurplus credit charges. Can be overridden on a per-group basis using the tag "+
+			autospotting.AllowBurstableInstanceTypesTag+".\n")
+
+	flag.Float64Var(&c.BurstableSurplusCreditsPercentage, "burstable_surplus_credits_percentage", 0.0,
+		"\n\tEstimated average CPU utilization above the baseline (percentage of the vCPUs) of burstable\n"+
+			"\tinstances running in unlimited mode, used for adding the cost of the surplus CPU credits to\n"+
+			"\tthe price of burstable spot candidates. By default the surplus credits are not considered.\n")
+
+	flag.StringVar(&c.InstanceDataSource, "instance_data", "",
+		"\n\tLoad the instance type data from a file in the ec2instances.info instances.json format,\n"+
+			"\tinstead of the data bundled in the binary. Accepts a local path, an S3 or an HTTP(S) URL.\n"+
+			"\tThe bundled data is still used if it can't be loaded.\n"+
+			"\tExample: ./autospotting -instance_data 'https://example.com/instances.json'\n")
+
+	flag.StringVar(&c.InstanceDataCache, "instance_data_cache", filepath.Join(os.TempDir(), "autospotting"),
+		"\n\tLocal directory where the instance data downloaded from S3 or HTTP is cached. It is only\n"+
+			"\tdownloaded again when modified, and the cached copy is used if the download fails.\n")
+
+	flag.StringVar(&c.InstanceDataChecksum, "instance_data_checksum", "",
+		"\n\tSHA-256 checksum (hex encoded) the instance data given using instance_data must match.\n")
+
+	flag.StringVar(&c.InstanceDataOverrides, "instance_data_overrides", "",
+		"\n\tLocal file containing a list of instance types in the instances.json format, which are\n"+
+			"\tmerged into the instance data. Useful for adding new instance types or negotiated prices.\n"+
+			"\tExample: [{\"instance_type\": \"m5.large\", \"pricing\": {\"us-east-1\": {\"linux\": {\"ondemand\": \"0.08\"}}}}]\n")
+
+	flag.BoolVar(&c.LiveOnDemandPrices, "live_on_demand_prices", false,
+		"\n\tFetch the current on-demand prices from the AWS Pricing API instead of relying only on the\n"+
+			"\tprices bundled in the binary, which also makes newly released instance types available.\n"+
+			"\tThe bundled prices are still used whenever the Pricing API can't be reached. This lists\n"+
+			"\tthe prices of all the instance types of each region, so it should be combined with\n"+
+			"\tprice_cache, and it needs the pricing:GetProducts IAM permission.\n")
+
+	flag.StringVar(&c.PriceCache, "price_cache", "",
+		"\n\tLocal directory or S3 location where the prices fetched from the Pricing API are cached\n"+
+			"\tbetween runs. By default they are not cached and fetched again on each run.\n"+
+			"\tExample: ./autospotting -price_cache 's3://my-bucket/autospotting/prices'\n")
+
+	flag.DurationVar(&c.PriceCacheTTL, "price_cache_ttl", autospotting.DefaultPriceCacheTTL,
+		"\n\tHow long the cached prices are used before fetching them again from the Pricing API.\n"+
+			"\tExpired prices are still used if the Pricing API can't be reached.\n")
+
 	flag.Float64Var(&c.OnDemandPriceMultiplier, "on_demand_price_multiplier", 1.0,
 		"\n\tMultiplier for the on-demand price. This is useful for volume discounts or if you want to\n"+
 			"\tset your bid price to be higher than the on demand price to reduce the chances that your\n"+
 			"\tspot instances will be terminated.\n")
 
+	flag.StringVar(&c.priceMultipliers, "on_demand_price_multipliers", "",
+		"\n\tOn-demand price multipliers for specific regions and instance types, such as negotiated\n"+
+			"\tdiscounts, taking precedence over on_demand_price_multiplier. Accepts a comma or whitespace\n"+
+			"\tseparated list of region:instance_type=multiplier entries (both support globs, the region\n"+
+			"\tis optional), the first matching entry is used.\n"+
+			"\tExample: ./autospotting -on_demand_price_multipliers 'eu-*:m5.*=0.8,c5.*=0.9'\n")
+
+	flag.StringVar(&c.priceMultipliersFile, "on_demand_price_multipliers_file", "",
+		"\n\tJSON file containing additional on-demand price multipliers, evaluated after the ones given\n"+
+			"\tusing on_demand_price_multipliers.\n"+
+			"\tExample: [{\"region\": \"eu-*\", \"instance_type\": \"m5.*\", \"multiplier\": 0.8}]\n")
+
 	flag.Float64Var(&c.SpotPriceBufferPercentage, "spot_price_buffer_percentage", autospotting.DefaultSpotPriceBufferPercentage,
 		"\n\tPercentage Value of the bid above the current spot price. A spot bid would be placed at a value :\n"+
 			"\tcurrent_spot_price * [1 + (spot_price_buffer_percentage/100.0)]. The main benefit is that\n"+
@@ -144,9 +456,16 @@ func (c *cfgData) parseCommandLineFlags() {
 			"\tenforced using the tag: "+autospotting.SpotPriceBufferPercentageTag+". If the bid exceeds\n"+
 			"\tthe on-demand price, we place a bid at on-demand price itself.\n")
 
+	flag.BoolVar(&c.ReservedInstancesAware, "reserved_instances_aware", true,
+		"\n\tKeep running the on-demand instances expected to be covered by the active reserved instances\n"+
+			"\tof each region, in order to avoid wasting the reservations when replacing them with spot instances.\n")
+
 	flag.StringVar(&c.SpotProductDescription, "spot_product_description", autospotting.DefaultSpotProductDescription,
 		"\n\tThe Spot Product or operating system to use when looking up spot price history in the market.\n"+
-			"\tValid choices: Linux/UNIX | SUSE Linux | Windows | Linux/UNIX (Amazon VPC) | SUSE Linux (Amazon VPC) | Windows (Amazon VPC)\n")
+			"\tValid choices: Linux/UNIX | SUSE Linux | Windows | Red Hat Enterprise Linux | Linux/UNIX (Amazon VPC) |\n"+
+			"\tSUSE Linux (Amazon VPC) | Windows (Amazon VPC) | Red Hat Enterprise Linux (Amazon VPC)\n"+
+			"\tIt is only used for the groups where it can't be detected from the AMI, and can be\n"+
+			"\toverridden on a per-group basis using the tag "+autospotting.SpotProductDescriptionTag+".\n")
 
 	flag.StringVar(&c.BiddingPolicy, "bidding_policy", autospotting.DefaultBiddingPolicy,
 		"\n\tPolicy choice for spot bid. If set to 'normal', we bid at the on-demand price.\n"+
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cristim/autospotting/core"
+	"github.com/namsral/flag"
+)
+
+// commands are the subcommands given after the global flags, which mostly
+// inspect the groups without making any changes. They log to stderr, keeping
+// their output on stdout.
+var commands = map[string]func(args []string){
+	"explain":  explainCommand,
+	"revert":   revertCommand,
+	"status":   statusCommand,
+	"validate": validateCommand,
+}
+
+// runCommand runs the subcommand given on the command line, if any.
+func runCommand() bool {
+	if flag.NArg() == 0 {
+		return false
+	}
+
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		log.Fatalf("Unknown command %q", flag.Arg(0))
+	}
+
+	conf.LogFile = os.Stderr
+	log.SetOutput(os.Stderr)
+
+	command(flag.Args()[1:])
+	return true
+}
+
+func explainCommand(args []string) {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+
+	account := fs.String("account", "",
+		"\n\tAccount of the group, when processing multiple accounts.\n")
+	region := fs.String("region", "", "\n\tRegion of the group.\n")
+	asg := fs.String("asg", "", "\n\tName of the AutoScaling group.\n")
+	format := fs.String("format", "text", "\n\tOutput format, either text or json.\n")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: autospotting [flags] explain -region <region> -asg <name>\n\n"+
+			"Shows why each instance type would be chosen or rejected as spot replacement\n"+
+			"for the on-demand instances of the group, without making any changes.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *region == "" || *asg == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Unknown output format %q", *format)
+	}
+
+	explanation, err := autospotting.Explain(conf.Config, *account, *region, *asg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(explanation)
+	} else {
+		err = explanation.WriteTable(os.Stdout)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func statusCommand(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+
+	format := fs.String("format", "text", "\n\tOutput format, either text, csv or json.\n")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: autospotting [flags] status\n\n"+
+			"Summarizes the spot coverage of the groups matching the tag filters in all the\n"+
+			"enabled regions, without making any changes.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *format != "text" && *format != "csv" && *format != "json" {
+		log.Fatalf("Unknown output format %q", *format)
+	}
+
+	coverage, err := autospotting.ScanCoverage(conf.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch *format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(coverage)
+	case "csv":
+		err = coverage.WriteCSV(os.Stdout)
+	default:
+		err = coverage.WriteTable(os.Stdout)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func revertCommand(args []string) {
+	fs := flag.NewFlagSet("revert", flag.ExitOnError)
+
+	account := fs.String("account", "",
+		"\n\tAccount of the group, when processing multiple accounts.\n")
+	region := fs.String("region", "", "\n\tRegion of the group.\n")
+	asg := fs.String("asg", "", "\n\tName of the AutoScaling group.\n")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: autospotting [flags] revert -region <region> -asg <name>\n\n"+
+			"Replaces the spot instances of the group with on-demand instances launched\n"+
+			"from its launch configuration, one at a time.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *region == "" || *asg == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := autospotting.Revert(conf.Config, *account, *region, *asg); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("The group", *asg, "has no spot instances left")
+}
+
+func validateCommand(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+
+	format := fs.String("format", "text", "\n\tOutput format, either text or json.\n")
+
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, "Usage: autospotting [flags] validate\n\n"+
+			"Checks the flags and the autospotting_* tags of the groups matching the tag\n"+
+			"filters in all the enabled regions, reporting the invalid or conflicting\n"+
+			"settings and the values used instead. Exits with status 1 if any are found.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Unknown output format %q", *format)
+	}
+
+	problems, err := autospotting.Validate(conf.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(problems)
+	} else {
+		err = problems.WriteTable(os.Stdout)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
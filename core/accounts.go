@@ -0,0 +1,176 @@
+package autospotting
+
+import (
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+)
+
+// organizationsRegion is where the global AWS Organizations endpoint lives.
+const organizationsRegion = "us-east-1"
+
+// assumeRoleSessionName identifies the AutoSpotting sessions in the
+// CloudTrail logs of the target accounts.
+const assumeRoleSessionName = "AutoSpotting"
+
+var accountIDRegex = regexp.MustCompile(`^\d{12}$`)
+
+// account is an AWS account processed by assuming a role in it.
+type account struct {
+	id      string
+	session *session.Session
+}
+
+// parseAccountIDs reads a list of account IDs separated by commas, whitespace
+// or new lines, ignoring everything after a # on each line.
+func parseAccountIDs(list string) ([]string, error) {
+	var ids []string
+
+	for _, line := range strings.Split(list, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		for _, id := range strings.Fields(strings.Replace(line, ",", " ", -1)) {
+			if !accountIDRegex.MatchString(id) {
+				return nil, errors.New("invalid account ID " + id)
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// listOrganizationAccounts returns the active accounts of the organization.
+func listOrganizationAccounts(svc organizationsiface.OrganizationsAPI) ([]string, error) {
+	var ids []string
+
+	err := svc.ListAccountsPages(&organizations.ListAccountsInput{},
+		func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+			for _, a := range page.Accounts {
+				if a.Id != nil && aws.StringValue(a.Status) == organizations.AccountStatusActive {
+					ids = append(ids, *a.Id)
+				}
+			}
+			return true
+		})
+
+	return ids, err
+}
+
+// accountIDs collects the accounts to be processed from the command line, the
+// accounts file and AWS Organizations, without duplicates.
+func accountIDs(cfg *Config, orgs organizationsiface.OrganizationsAPI) ([]string, error) {
+
+	ids, err := parseAccountIDs(cfg.Accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.AccountsFile != "" {
+		raw, err := ioutil.ReadFile(cfg.AccountsFile)
+		if err != nil {
+			return nil, err
+		}
+
+		fileIDs, err := parseAccountIDs(string(raw))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, fileIDs...)
+	}
+
+	if cfg.AccountsFromOrganization {
+		orgIDs, err := listOrganizationAccounts(orgs)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, orgIDs...)
+	}
+
+	unique := make(map[string]bool)
+	var result []string
+	for _, id := range ids {
+		if !unique[id] {
+			unique[id] = true
+			result = append(result, id)
+		}
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// roleARN builds the ARN of the role assumed in an account.
+func roleARN(accountID, roleName string) string {
+	return "arn:aws:iam::" + accountID + ":role/" + strings.TrimPrefix(roleName, "/")
+}
+
+// assumeRole creates a session for an account using the credentials of the
+// role assumed in it.
+func assumeRole(base *session.Session, cfg *Config, accountID string) account {
+	creds := stscreds.NewCredentials(base, roleARN(accountID, cfg.AssumeRoleName),
+		func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = assumeRoleSessionName
+			if cfg.AssumeRoleExternalID != "" {
+				p.ExternalID = aws.String(cfg.AssumeRoleExternalID)
+			}
+		})
+
+	return account{
+		id:      accountID,
+		session: base.Copy(&aws.Config{Credentials: creds}),
+	}
+}
+
+// accounts determines the accounts to be processed, returning none if the
+// current account should be processed using the default credentials.
+func accounts(cfg *Config) ([]account, error) {
+
+	if cfg.Accounts == "" && cfg.AccountsFile == "" && !cfg.AccountsFromOrganization {
+		return nil, nil
+	}
+
+	if cfg.AssumeRoleName == "" {
+		return nil, errors.New("the role to be assumed in the accounts is missing")
+	}
+
+	base, err := cfg.sessions.get("", func() (*session.Session, error) {
+		return newSession(cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var orgs organizationsiface.OrganizationsAPI
+	if cfg.AccountsFromOrganization {
+		orgs = organizations.New(base, aws.NewConfig().WithRegion(organizationsRegion))
+	}
+
+	ids, err := accountIDs(cfg, orgs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, errors.New("no accounts found to be processed")
+	}
+
+	var result []account
+	for _, id := range ids {
+		// reusing the sessions also reuses the credentials until they expire
+		sess, _ := cfg.sessions.get(id, func() (*session.Session, error) {
+			return assumeRole(base, cfg, id).session, nil
+		})
+		result = append(result, account{id: id, session: sess})
+	}
+	return result, nil
+}
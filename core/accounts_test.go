@@ -0,0 +1,147 @@
+package autospotting
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/organizations"
+)
+
+func TestParseAccountIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty list",
+			list: "",
+			want: nil,
+		},
+		{name: "comma and whitespace separated",
+			list: "123456789012, 210987654321 111111111111",
+			want: []string{"123456789012", "210987654321", "111111111111"},
+		},
+		{name: "file with comments",
+			list: "# production\n123456789012 # main\n\n210987654321\n",
+			want: []string{"123456789012", "210987654321"},
+		},
+		{name: "invalid account ID",
+			list:    "12345",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAccountIDs(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAccountIDs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAccountIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccountIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "accounts")
+	ioutil.WriteFile(file, []byte("210987654321\n123456789012\n"), 0644)
+
+	orgs := mockOrganizations{
+		lao: &organizations.ListAccountsOutput{
+			Accounts: []*organizations.Account{
+				{Id: aws.String("333333333333"), Status: aws.String(organizations.AccountStatusActive)},
+				{Id: aws.String("444444444444"), Status: aws.String(organizations.AccountStatusSuspended)},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		cfg     *Config
+		orgs    mockOrganizations
+		want    []string
+		wantErr bool
+	}{
+		{name: "accounts from the command line",
+			cfg:  &Config{Accounts: "123456789012"},
+			want: []string{"123456789012"},
+		},
+		{name: "accounts from all sources without duplicates",
+			cfg: &Config{
+				Accounts:                 "123456789012",
+				AccountsFile:             file,
+				AccountsFromOrganization: true,
+			},
+			orgs: orgs,
+			want: []string{"123456789012", "210987654321", "333333333333"},
+		},
+		{name: "missing accounts file",
+			cfg:     &Config{AccountsFile: filepath.Join(dir, "missing")},
+			wantErr: true,
+		},
+		{name: "organizations error",
+			cfg:     &Config{AccountsFromOrganization: true},
+			orgs:    mockOrganizations{laerr: errors.New("AccessDenied")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := accountIDs(tt.cfg, tt.orgs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("accountIDs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("accountIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccounts(t *testing.T) {
+	none, err := accounts(&Config{})
+	if none != nil || err != nil {
+		t.Errorf("accounts() without accounts = %v, %v", none, err)
+	}
+
+	if _, err := accounts(&Config{Accounts: "123456789012"}); err == nil {
+		t.Errorf("accounts() without a role to assume didn't fail")
+	}
+
+	got, err := accounts(&Config{
+		Accounts:             "123456789012",
+		AssumeRoleName:       "AutoSpotting",
+		AssumeRoleExternalID: "secret",
+	})
+	if err != nil || len(got) != 1 || got[0].id != "123456789012" || got[0].session == nil {
+		t.Errorf("accounts() = %v, %v", got, err)
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	base := session.Must(session.NewSession())
+
+	a := assumeRole(base, &Config{AssumeRoleName: "AutoSpotting"}, "123456789012")
+
+	if a.session.Config.Credentials == base.Config.Credentials {
+		t.Errorf("assumeRole() didn't use the assumed role credentials")
+	}
+
+	if got := roleARN("123456789012", "/AutoSpotting"); got != "arn:aws:iam::123456789012:role/AutoSpotting" {
+		t.Errorf("roleARN() = %v", got)
+	}
+}
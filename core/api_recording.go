@@ -0,0 +1,344 @@
+package autospotting
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// replayMissingErrorCode is returned by the replayed API calls which weren't
+// recorded.
+const replayMissingErrorCode = "ReplayMissing"
+
+// the fields removed from the recorded requests and responses: the user data
+// of the instances, which may contain secrets, and the assumed credentials
+var redactedFields = map[string]bool{
+	"UserData":    true,
+	"Credentials": true,
+}
+
+// apiCall is an AWS API call saved as a line of the recording file.
+type apiCall struct {
+	Time      time.Time       `json:"time"`
+	Region    string          `json:"region"`
+	Service   string          `json:"service"`
+	Operation string          `json:"operation"`
+	Input     json.RawMessage `json:"input"`
+	Output    json.RawMessage `json:"output,omitempty"`
+	Error     *apiError       `json:"error,omitempty"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// key identifies the calls which may be replayed in place of each other.
+func (c *apiCall) key() string {
+	return c.Region + "/" + c.Service + "/" + c.Operation
+}
+
+// apiTraffic records or replays the API calls made through a session.
+type apiTraffic interface {
+	install(h *request.Handlers)
+}
+
+// setupAPITraffic prepares the recording or the replay of the API calls, the
+// replay taking precedence.
+func setupAPITraffic(cfg *Config) {
+
+	if cfg.ReplayFile != "" {
+		replayer, err := loadAPIReplayer(cfg.ReplayFile)
+		if err != nil {
+			// never fall back to calling AWS while replaying
+			cfg.logs.logger().Println("Couldn't load the recorded API calls, all the API "+
+				"calls will fail:", err.Error())
+			replayer = newAPIReplayer(nil)
+		}
+
+		if cfg.Clock == nil {
+			cfg.Clock = replayer.clock
+		}
+		cfg.apiTraffic = replayer
+		cfg.logs.logger().Println("Replaying the API calls recorded in", cfg.ReplayFile)
+		return
+	}
+
+	if cfg.RecordFile != "" {
+		f, err := os.OpenFile(cfg.RecordFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			cfg.logs.logger().Println("Couldn't record the API calls:", err.Error())
+			return
+		}
+		cfg.apiTraffic = newAPIRecorder(f, cfg.clock())
+		cfg.logs.logger().Println("Recording the API calls to", cfg.RecordFile)
+	}
+}
+
+// newSession creates a session whose API calls are recorded or replayed when
+// configured.
+func newSession(cfg *Config, configs ...*aws.Config) (*session.Session, error) {
+	sess, err := session.NewSession(configs...)
+	if err == nil && cfg.apiTraffic != nil {
+		cfg.apiTraffic.install(&sess.Handlers)
+	}
+	return sess, err
+}
+
+// redact serializes the input or output of an API call, without the fields
+// which shouldn't be recorded and without the unset ones.
+func redact(v interface{}) (json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers are kept as they are, instead of being converted to float64
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	removeRedactedFields(generic)
+	return json.Marshal(generic)
+}
+
+func removeRedactedFields(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if redactedFields[key] || value == nil {
+				delete(v, key)
+				continue
+			}
+			removeRedactedFields(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			removeRedactedFields(value)
+		}
+	}
+}
+
+// apiRecorder writes the API calls to a file, one JSON object per line.
+type apiRecorder struct {
+	sync.Mutex
+	w     io.Writer
+	clock Clock
+}
+
+func newAPIRecorder(w io.Writer, c Clock) *apiRecorder {
+	return &apiRecorder{w: w, clock: c}
+}
+
+func (rec *apiRecorder) install(h *request.Handlers) {
+	// the complete handlers run once per call, after all the retries
+	h.Complete.PushBackNamed(request.NamedHandler{
+		Name: "autospotting.APIRecorder",
+		Fn:   rec.record,
+	})
+}
+
+func (rec *apiRecorder) record(r *request.Request) {
+	call := apiCall{
+		Time:      rec.clock.Now(),
+		Region:    aws.StringValue(r.Config.Region),
+		Service:   r.ClientInfo.ServiceName,
+		Operation: r.Operation.Name,
+	}
+
+	var err error
+	if call.Input, err = redact(r.Params); err != nil {
+		logger.Println("Couldn't record the", call.Operation, "call:", err.Error())
+		return
+	}
+
+	if r.Error != nil {
+		call.Error = &apiError{Message: r.Error.Error()}
+		if awsErr, ok := r.Error.(awserr.Error); ok {
+			call.Error.Code, call.Error.Message = awsErr.Code(), awsErr.Message()
+		}
+	} else if call.Output, err = redact(r.Data); err != nil {
+		logger.Println("Couldn't record the", call.Operation, "call:", err.Error())
+		return
+	}
+
+	line, err := json.Marshal(call)
+	if err != nil {
+		logger.Println("Couldn't record the", call.Operation, "call:", err.Error())
+		return
+	}
+
+	rec.Lock()
+	defer rec.Unlock()
+
+	if _, err := rec.w.Write(append(line, '\n')); err != nil {
+		logger.Println("Couldn't record the", call.Operation, "call:", err.Error())
+	}
+}
+
+// apiReplayer answers the API calls with the recorded responses, without
+// sending anything to AWS.
+type apiReplayer struct {
+	sync.Mutex
+
+	// the recorded calls not yet replayed, by key
+	calls map[string][]*apiCall
+
+	clock *replayClock
+}
+
+func newAPIReplayer(calls []*apiCall) *apiReplayer {
+	rep := &apiReplayer{
+		calls: make(map[string][]*apiCall),
+		clock: &replayClock{},
+	}
+
+	for _, c := range calls {
+		rep.calls[c.key()] = append(rep.calls[c.key()], c)
+	}
+
+	// start at the time of the recorded run
+	if len(calls) > 0 {
+		rep.clock.advance(calls[0].Time)
+	}
+	return rep
+}
+
+func loadAPIReplayer(fileName string) (*apiReplayer, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	calls, err := readAPICalls(f)
+	if err != nil {
+		return nil, err
+	}
+	return newAPIReplayer(calls), nil
+}
+
+// readAPICalls reads the calls saved by an apiRecorder.
+func readAPICalls(r io.Reader) ([]*apiCall, error) {
+	var calls []*apiCall
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64*1024*1024)
+
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var c apiCall
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return nil, err
+		}
+		calls = append(calls, &c)
+	}
+	return calls, scanner.Err()
+}
+
+func (rep *apiReplayer) install(h *request.Handlers) {
+	h.Validate.PushFrontNamed(request.NamedHandler{
+		Name: "autospotting.APIReplayer",
+		Fn:   rep.prepare,
+	})
+}
+
+// prepare replaces the handlers of a request, including the ones added by
+// the service clients, so it's neither signed, which would need credentials,
+// nor sent, and its response is set directly instead of being parsed.
+func (rep *apiReplayer) prepare(r *request.Request) {
+	r.Handlers.Sign.Clear()
+	r.Handlers.Send.Clear()
+	r.Handlers.UnmarshalMeta.Clear()
+	r.Handlers.ValidateResponse.Clear()
+	r.Handlers.UnmarshalError.Clear()
+	r.Handlers.Unmarshal.Clear()
+
+	r.Handlers.Send.PushBack(rep.replay)
+}
+
+func (rep *apiReplayer) replay(r *request.Request) {
+	r.HTTPResponse = &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+	}
+
+	// the replayed errors are final, as they were after the recorded retries
+	r.Retryable = aws.Bool(false)
+
+	call, err := rep.next(r)
+	if err != nil {
+		r.Error = err
+		return
+	}
+
+	rep.clock.advance(call.Time)
+
+	if call.Error != nil {
+		r.Error = awserr.New(call.Error.Code, call.Error.Message, nil)
+		return
+	}
+
+	if r.DataFilled() && len(call.Output) > 0 {
+		if err := json.Unmarshal(call.Output, r.Data); err != nil {
+			r.Error = awserr.New(replayMissingErrorCode,
+				"couldn't replay the recorded response", err)
+		}
+	}
+}
+
+// next takes the recorded call matching the request, preferring one having
+// the same input. Otherwise the oldest call of the same operation is used,
+// since some inputs, such as the start time of the spot price history,
+// depend on the time.
+func (rep *apiReplayer) next(r *request.Request) (*apiCall, error) {
+	wanted := apiCall{
+		Region:    aws.StringValue(r.Config.Region),
+		Service:   r.ClientInfo.ServiceName,
+		Operation: r.Operation.Name,
+	}
+
+	input, err := redact(r.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	rep.Lock()
+	defer rep.Unlock()
+
+	calls := rep.calls[wanted.key()]
+	if len(calls) == 0 {
+		return nil, awserr.New(replayMissingErrorCode,
+			"no recorded response left for "+wanted.key(), nil)
+	}
+
+	n := 0
+	for i, c := range calls {
+		if bytes.Equal(c.Input, input) {
+			n = i
+			break
+		}
+	}
+
+	call := calls[n]
+	rep.calls[wanted.key()] = append(calls[:n:n], calls[n+1:]...)
+	return call, nil
+}
@@ -0,0 +1,133 @@
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestRedact(t *testing.T) {
+	got, err := redact(&autoscaling.DescribeLaunchConfigurationsOutput{
+		LaunchConfigurations: []*autoscaling.LaunchConfiguration{{
+			LaunchConfigurationName: aws.String("lc"),
+			UserData:                aws.String("c2VjcmV0"),
+			InstanceMonitoring:      &autoscaling.InstanceMonitoring{Enabled: aws.Bool(true)},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("redact() error = %v", err)
+	}
+
+	want := `{"LaunchConfigurations":[{"InstanceMonitoring":{"Enabled":true},` +
+		`"LaunchConfigurationName":"lc"}]}`
+	if string(got) != want {
+		t.Errorf("redact() = %s, want %s", got, want)
+	}
+}
+
+func TestAPIRecordingReplay(t *testing.T) {
+	start := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	calls := []*apiCall{
+		{Time: start, Region: "eu-west-1", Service: "autoscaling",
+			Operation: "DescribeLaunchConfigurations",
+			Input:     json.RawMessage(`{"LaunchConfigurationNames":["other"]}`),
+			Output:    json.RawMessage(`{"LaunchConfigurations":[{"LaunchConfigurationName":"other"}]}`),
+		},
+		{Time: start.Add(time.Minute), Region: "eu-west-1", Service: "autoscaling",
+			Operation: "DescribeLaunchConfigurations",
+			Input:     json.RawMessage(`{"LaunchConfigurationNames":["lc"]}`),
+			Output: json.RawMessage(`{"LaunchConfigurations":[{"LaunchConfigurationName":"lc",` +
+				`"ImageId":"ami-1","UserData":"c2VjcmV0"}]}`),
+		},
+		{Time: start.Add(2 * time.Minute), Region: "eu-west-1", Service: "ec2",
+			Operation: "DescribeInstances",
+			Input:     json.RawMessage(`{}`),
+			Error:     &apiError{Code: "UnauthorizedOperation", Message: "not allowed"},
+		},
+	}
+
+	var recording bytes.Buffer
+
+	replayer := newAPIReplayer(calls)
+	recorder := newAPIRecorder(&recording, replayer.clock)
+
+	if now := replayer.clock.Now(); !now.Equal(start) {
+		t.Errorf("the replay starts at %v, want %v", now, start)
+	}
+
+	cfg := &Config{apiTraffic: replayer}
+	sess, err := newSession(cfg, &aws.Config{Region: aws.String("eu-west-1")})
+	if err != nil {
+		t.Fatalf("newSession() error = %v", err)
+	}
+	recorder.install(&sess.Handlers)
+
+	asSvc := autoscaling.New(sess)
+	ec2Svc := ec2.New(sess)
+
+	describe := func(name string) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+		return asSvc.DescribeLaunchConfigurations(&autoscaling.DescribeLaunchConfigurationsInput{
+			LaunchConfigurationNames: []*string{aws.String(name)},
+		})
+	}
+
+	// the call having the same input is replayed first
+	out, err := describe("lc")
+	if err != nil || aws.StringValue(out.LaunchConfigurations[0].ImageId) != "ami-1" {
+		t.Errorf("DescribeLaunchConfigurations() = %v, %v", out, err)
+	}
+	if now := replayer.clock.Now(); !now.Equal(start.Add(time.Minute)) {
+		t.Errorf("the clock is at %v after the replayed call, want %v",
+			now, start.Add(time.Minute))
+	}
+
+	// otherwise the oldest one left
+	out, err = describe("unknown")
+	if err != nil || aws.StringValue(out.LaunchConfigurations[0].LaunchConfigurationName) != "other" {
+		t.Errorf("DescribeLaunchConfigurations() = %v, %v", out, err)
+	}
+
+	// the sleeps only move the clock forward
+	replayer.clock.Sleep(time.Hour)
+	if now := replayer.clock.Now(); !now.Equal(start.Add(time.Minute + time.Hour)) {
+		t.Errorf("the clock is at %v after sleeping, want %v",
+			now, start.Add(time.Minute+time.Hour))
+	}
+
+	_, err = ec2Svc.DescribeInstances(&ec2.DescribeInstancesInput{})
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "UnauthorizedOperation" {
+		t.Errorf("DescribeInstances() error = %v, want the recorded error", err)
+	}
+
+	_, err = describe("lc")
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != replayMissingErrorCode {
+		t.Errorf("DescribeLaunchConfigurations() error = %v, want %s",
+			err, replayMissingErrorCode)
+	}
+
+	recorded, err := readAPICalls(&recording)
+	if err != nil {
+		t.Fatalf("readAPICalls() error = %v", err)
+	}
+	if len(recorded) != 4 {
+		t.Fatalf("recorded %d calls, want 4", len(recorded))
+	}
+
+	if string(recorded[0].Input) != `{"LaunchConfigurationNames":["lc"]}` {
+		t.Errorf("recorded the input %s", recorded[0].Input)
+	}
+	if bytes.Contains(recorded[0].Output, []byte("UserData")) {
+		t.Errorf("recorded the user data in %s", recorded[0].Output)
+	}
+	if recorded[2].Error == nil || recorded[2].Error.Code != "UnauthorizedOperation" ||
+		recorded[2].Service != "ec2" || recorded[2].Region != "eu-west-1" {
+		t.Errorf("recorded the failed call as %+v", recorded[2])
+	}
+}
@@ -46,6 +46,37 @@ const (
 	// instance types are not allowed in the current group
 	DisallowedInstanceTypesTag = "autospotting_disallowed_instance_types"
 
+	// SpotProductDescriptionTag is the name of a tag that can define the
+	// operating system of the current group, as a spot product description
+	// such as "Windows (Amazon VPC)". When missing, it is detected from the AMI
+	// used by the group's launch configuration.
+	SpotProductDescriptionTag = "autospotting_spot_product_description"
+
+	// AllowBurstableInstanceTypesTag is the name of a tag that can allow
+	// burstable instance types to replace the fixed-performance instances of
+	// the current group
+	AllowBurstableInstanceTypesTag = "autospotting_allow_burstable_instance_types"
+
+	// RevertToOnDemandTag is the name of a tag which, when set to "true" on an
+	// enabled group, gradually replaces its spot instances with on-demand
+	// instances launched from its launch configuration
+	RevertToOnDemandTag = "autospotting_revert_to_on_demand"
+
+	// SelectionStrategyTag is the name of a tag that can override the
+	// strategy choosing the spot instance types of the current group, such as
+	// "cheapest-per-vcpu"
+	SelectionStrategyTag = "autospotting_selection_strategy"
+
+	// PreferredInstanceTypesTag is the name of a tag listing the preferred
+	// spot instance types of the current group in their order of preference,
+	// used by the priority selection strategy
+	PreferredInstanceTypesTag = "autospotting_preferred_instance_types"
+
+	// MaxInterruptionBandTag is the name of a tag that can override the
+	// highest interruption frequency band of the spot instance types
+	// acceptable for the current group, such as "10-15%"
+	MaxInterruptionBandTag = "autospotting_max_interruption_band"
+
 	// Default constant values should be defined below:
 
 	// DefaultSpotProductDescription stores the default operating system
@@ -63,6 +94,10 @@ const (
 	// DefaultBiddingPolicy stores the default bidding policy for
 	// the spot bid on a per-group level
 	DefaultBiddingPolicy = "normal"
+
+	// DefaultAPIMaxRetries stores the default number of retries of the
+	// throttled API calls
+	DefaultAPIMaxRetries = 5
 )
 
 type autoScalingGroup struct {
@@ -79,23 +114,28 @@ type autoScalingGroup struct {
 
 	// for caching
 	launchConfiguration *launchConfiguration
+
+	// the spot product description matching the group's operating system
+	// and the instance type information priced accordingly
+	spotProductDescription  string
+	instanceTypeInformation map[string]instanceTypeInformation
 }
 
 func (a *autoScalingGroup) loadPercentageOnDemand(tagValue *string) (int64, bool) {
 	percentage, err := strconv.ParseFloat(*tagValue, 64)
 	if err != nil {
-		logger.Printf("Error with ParseFloat: %s\n", err.Error())
+		a.region.logger().Printf("Error with ParseFloat: %s\n", err.Error())
 	} else if percentage == 0 {
-		logger.Printf("Loaded MinOnDemand value to %f from tag %s\n", percentage, OnDemandPercentageTag)
+		a.region.logger().Printf("Loaded MinOnDemand value to %f from tag %s\n", percentage, OnDemandPercentageTag)
 		return int64(percentage), true
 	} else if percentage > 0 && percentage <= 100 {
 		instanceNumber := float64(a.instances.count())
 		onDemand := int64(math.Floor((instanceNumber * percentage / 100.0) + .5))
-		logger.Printf("Loaded MinOnDemand value to %d from tag %s\n", onDemand, OnDemandPercentageTag)
+		a.region.logger().Printf("Loaded MinOnDemand value to %d from tag %s\n", onDemand, OnDemandPercentageTag)
 		return onDemand, true
 	}
 
-	logger.Printf("Ignoring value out of range %f\n", percentage)
+	a.region.logger().Printf("Ignoring value out of range %f\n", percentage)
 
 	return DefaultMinOnDemandValue, false
 }
@@ -104,26 +144,26 @@ func (a *autoScalingGroup) loadSpotPriceBufferPercentage(tagValue *string) (floa
 	spotPriceBufferPercentage, err := strconv.ParseFloat(*tagValue, 64)
 
 	if err != nil {
-		logger.Printf("Error with ParseFloat: %s\n", err.Error())
+		a.region.logger().Printf("Error with ParseFloat: %s\n", err.Error())
 		return DefaultSpotPriceBufferPercentage, false
 	} else if spotPriceBufferPercentage <= 0 {
-		logger.Printf("Ignoring out of range value : %f\n", spotPriceBufferPercentage)
+		a.region.logger().Printf("Ignoring out of range value : %f\n", spotPriceBufferPercentage)
 		return DefaultSpotPriceBufferPercentage, false
 	}
 
-	logger.Printf("Loaded SpotPriceBufferPercentage value to %f from tag %s\n", spotPriceBufferPercentage, SpotPriceBufferPercentageTag)
+	a.region.logger().Printf("Loaded SpotPriceBufferPercentage value to %f from tag %s\n", spotPriceBufferPercentage, SpotPriceBufferPercentageTag)
 	return spotPriceBufferPercentage, true
 }
 
 func (a *autoScalingGroup) loadNumberOnDemand(tagValue *string) (int64, bool) {
 	onDemand, err := strconv.Atoi(*tagValue)
 	if err != nil {
-		logger.Printf("Error with Atoi: %s\n", err.Error())
+		a.region.logger().Printf("Error with Atoi: %s\n", err.Error())
 	} else if onDemand >= 0 && int64(onDemand) <= *a.MaxSize {
-		logger.Printf("Loaded MinOnDemand value to %d from tag %s\n", onDemand, OnDemandNumberLong)
+		a.region.logger().Printf("Loaded MinOnDemand value to %d from tag %s\n", onDemand, OnDemandNumberLong)
 		return int64(onDemand), true
 	} else {
-		logger.Printf("Ignoring value out of range %d\n", onDemand)
+		a.region.logger().Printf("Ignoring value out of range %d\n", onDemand)
 	}
 	return DefaultMinOnDemandValue, false
 }
@@ -144,7 +184,7 @@ func (a *autoScalingGroup) loadConfOnDemand() bool {
 				}
 			}
 		}
-		debug.Println("Couldn't find tag", tagKey)
+		a.region.debugLogger().Println("Couldn't find tag", tagKey)
 	}
 	return false
 }
@@ -155,19 +195,19 @@ func (a *autoScalingGroup) loadBiddingPolicy(tagValue *string) (string, bool) {
 		return DefaultBiddingPolicy, false
 	}
 
-	logger.Printf("Loaded BiddingPolicy value with %s from tag %s\n", biddingPolicy, BiddingPolicyTag)
+	a.region.logger().Printf("Loaded BiddingPolicy value with %s from tag %s\n", biddingPolicy, BiddingPolicyTag)
 	return biddingPolicy, true
 }
 
 func (a *autoScalingGroup) loadConfSpot() bool {
 	tagValue := a.getTagValue(BiddingPolicyTag)
 	if tagValue == nil {
-		debug.Println("Couldn't find tag", BiddingPolicyTag)
+		a.region.debugLogger().Println("Couldn't find tag", BiddingPolicyTag)
 		return false
 	}
 	if newValue, done := a.loadBiddingPolicy(tagValue); done {
 		a.region.conf.BiddingPolicy = newValue
-		logger.Println("BiddingPolicy =", a.region.conf.BiddingPolicy)
+		a.region.logger().Println("BiddingPolicy =", a.region.conf.BiddingPolicy)
 		return done
 	}
 	return false
@@ -182,7 +222,7 @@ func (a *autoScalingGroup) loadConfSpotPrice() bool {
 
 	newValue, done := a.loadSpotPriceBufferPercentage(tagValue)
 	if !done {
-		debug.Println("Couldn't find tag", SpotPriceBufferPercentageTag)
+		a.region.debugLogger().Println("Couldn't find tag", SpotPriceBufferPercentageTag)
 		return false
 	}
 
@@ -200,13 +240,13 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 	resSpotPriceConf := a.loadConfSpotPrice()
 
 	if resOnDemandConf {
-		logger.Println("Found and applied configuration for OnDemand value")
+		a.region.logger().Println("Found and applied configuration for OnDemand value")
 	}
 	if resSpotConf {
-		logger.Println("Found and applied configuration for Spot Bid")
+		a.region.logger().Println("Found and applied configuration for Spot Bid")
 	}
 	if resSpotPriceConf {
-		logger.Println("Found and applied configuration for Spot Price")
+		a.region.logger().Println("Found and applied configuration for Spot Price")
 	}
 	if resOnDemandConf || resSpotConf || resSpotPriceConf {
 		return true
@@ -217,22 +257,22 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 func (a *autoScalingGroup) loadDefaultConfigNumber() (int64, bool) {
 	onDemand := a.region.conf.MinOnDemandNumber
 	if onDemand >= 0 && onDemand <= int64(a.instances.count()) {
-		logger.Printf("Loaded default value %d from conf number.", onDemand)
+		a.region.logger().Printf("Loaded default value %d from conf number.", onDemand)
 		return onDemand, true
 	}
-	logger.Println("Ignoring default value out of range:", onDemand)
+	a.region.logger().Println("Ignoring default value out of range:", onDemand)
 	return DefaultMinOnDemandValue, false
 }
 
 func (a *autoScalingGroup) loadDefaultConfigPercentage() (int64, bool) {
 	percentage := a.region.conf.MinOnDemandPercentage
 	if percentage < 0 || percentage > 100 {
-		logger.Printf("Ignoring default value out of range: %f", percentage)
+		a.region.logger().Printf("Ignoring default value out of range: %f", percentage)
 		return DefaultMinOnDemandValue, false
 	}
 	instanceNumber := a.instances.count()
 	onDemand := int64(math.Floor((float64(instanceNumber) * percentage / 100.0) + .5))
-	logger.Printf("Loaded default value %d from conf percentage.", onDemand)
+	a.region.logger().Printf("Loaded default value %d from conf percentage.", onDemand)
 	return onDemand, true
 }
 
@@ -250,29 +290,48 @@ func (a *autoScalingGroup) loadDefaultConfig() bool {
 	if !done && a.region.conf.MinOnDemandPercentage != 0 {
 		a.minOnDemand, done = a.loadDefaultConfigPercentage()
 	} else {
-		logger.Println("No default value for on-demand instances specified, skipping.")
+		a.region.logger().Println("No default value for on-demand instances specified, skipping.")
 	}
 	return done
 }
 
+// keepReservedInstances raises the number of on-demand instances kept in the
+// group to the number of instances covered by reserved instances.
+func (a *autoScalingGroup) keepReservedInstances() {
+	reserved := a.reservedInstanceCount()
+	if reserved == 0 {
+		return
+	}
+
+	a.region.logger().Println(a.name, "has", reserved,
+		"on-demand instances covered by reserved instances")
+
+	if reserved > a.minOnDemand {
+		a.region.logger().Println(a.name, "Keeping", reserved,
+			"on-demand instances instead of", a.minOnDemand,
+			"in order to use the reserved instances")
+		a.minOnDemand = reserved
+	}
+}
+
 func (a *autoScalingGroup) needReplaceOnDemandInstances() bool {
 	onDemandRunning, totalRunning := a.alreadyRunningInstanceCount(false, "")
 	if onDemandRunning > a.minOnDemand {
-		logger.Println("Currently more than enough OnDemand instances running")
+		a.region.logger().Println("Currently more than enough OnDemand instances running")
 		return true
 	}
 	if onDemandRunning == a.minOnDemand {
-		logger.Println("Currently OnDemand running equals to the required number, skipping run")
+		a.region.logger().Println("Currently OnDemand running equals to the required number, skipping run")
 		return false
 	}
-	logger.Println("Currently less OnDemand instances than required !")
+	a.region.logger().Println("Currently less OnDemand instances than required !")
 	if a.allInstanceRunning() && a.instances.count64() >= *a.DesiredCapacity {
-		logger.Println("All instances are running and desired capacity is satisfied")
+		a.region.logger().Println("All instances are running and desired capacity is satisfied")
 		if randomSpot := a.getAnySpotInstance(); randomSpot != nil {
 			if totalRunning == 1 {
-				logger.Println("Warning: blocking replacement of very last instance - consider raising ASG to >= 2")
+				a.region.logger().Println("Warning: blocking replacement of very last instance - consider raising ASG to >= 2")
 			} else {
-				logger.Println("Terminating a random spot instance",
+				a.region.logger().Println("Terminating a random spot instance",
 					*randomSpot.Instance.InstanceId)
 				randomSpot.terminate()
 			}
@@ -286,17 +345,20 @@ func (a *autoScalingGroup) allInstanceRunning() bool {
 	return totalRunning == a.instances.count64()
 }
 
-func (a *autoScalingGroup) process() {
-	logger.Println("Finding spot instance requests created for", a.name)
-	err := a.findSpotInstanceRequests()
-	if err != nil {
-		logger.Printf("Error: %s while searching for spot instances for %s\n", err, a.name)
-	}
-	a.scanInstances()
-	a.loadDefaultConfig()
-	a.loadConfigFromTags()
+func (a *autoScalingGroup) process() (status GroupStatus) {
+	action := "none"
+	var err error
+	defer func() { status = a.reportStatus(action, err) }()
+
+	err = a.loadState()
 
-	debug.Println("Found spot instance requests:", a.spotInstanceRequests)
+	if a.revertRequested() {
+		action, err = a.revertToOnDemand()
+		if err != nil {
+			a.region.logger().Println(a.name, "Could not revert to on-demand instances:", err)
+		}
+		return
+	}
 
 	if !a.needReplaceOnDemandInstances() {
 		return
@@ -305,34 +367,73 @@ func (a *autoScalingGroup) process() {
 	spotInstanceID, waitForNextRun := a.havingReadyToAttachSpotInstance()
 
 	if waitForNextRun {
-		logger.Println("Waiting for next run while processing", a.name)
+		a.region.logger().Println("Waiting for next run while processing", a.name)
+		action = "waiting for spot instance"
 		return
 	}
 
 	if spotInstanceID != nil {
-		logger.Println(a.region.name, "Attaching spot instance",
+		if !a.region.hasTimeFor(replacementDuration) {
+			a.region.logger().Println(a.region.name, a.name, "Not enough time left for attaching",
+				"spot instance", *spotInstanceID, "deferring it to the next run")
+			action = "deferred"
+			return
+		}
+
+		a.region.logger().Println(a.region.name, "Attaching spot instance",
 			*spotInstanceID, "to", a.name)
 
-		a.replaceOnDemandInstanceWithSpot(spotInstanceID)
+		action = "replacing on-demand instance"
+		err = a.replaceOnDemandInstanceWithSpot(spotInstanceID)
+		if err != nil {
+			a.region.logger().Println(a.name, "Could not replace on-demand instance:", err)
+		}
 	} else {
 		// find any given on-demand instance and try to replace it with a spot one
 		onDemandInstance := a.getInstance(nil, true, false)
 
 		if onDemandInstance == nil {
-			logger.Println(a.region.name, a.name,
+			a.region.logger().Println(a.region.name, a.name,
 				"No running on-demand instances were found, nothing to do here...")
 			return
 		}
 
+		if !a.region.hasTimeFor(spotRequestDuration) {
+			a.region.logger().Println(a.region.name, a.name, "Not enough time left for",
+				"launching a spot instance, deferring it to the next run")
+			action = "deferred"
+			return
+		}
+
 		azToLaunchSpotIn := onDemandInstance.Placement.AvailabilityZone
-		logger.Println(a.region.name, a.name,
+		a.region.logger().Println(a.region.name, a.name,
 			"Would launch a spot instance in ", *azToLaunchSpotIn)
 
-		err := a.launchCheapestSpotInstance(azToLaunchSpotIn)
+		action = "launching spot instance"
+		err = a.launchCheapestSpotInstance(azToLaunchSpotIn)
 		if err != nil {
-			logger.Printf("Could not launch cheapest spot instance: %s", err)
+			a.region.logger().Printf("Could not launch cheapest spot instance: %s", err)
 		}
 	}
+	return
+}
+
+// loadState loads the spot instance requests, instances and configuration of
+// the group.
+func (a *autoScalingGroup) loadState() error {
+	a.region.logger().Println("Finding spot instance requests created for", a.name)
+	err := a.findSpotInstanceRequests()
+	if err != nil {
+		a.region.logger().Printf("Error: %s while searching for spot instances for %s\n", err, a.name)
+	}
+	a.loadInstanceTypeInformation()
+	a.scanInstances()
+	a.loadDefaultConfig()
+	a.loadConfigFromTags()
+	a.keepReservedInstances()
+
+	a.region.debugLogger().Println("Found spot instance requests:", a.spotInstanceRequests)
+	return err
 }
 
 func (a *autoScalingGroup) findSpotInstanceRequests() error {
@@ -350,7 +451,7 @@ func (a *autoScalingGroup) findSpotInstanceRequests() error {
 	if err != nil {
 		return err
 	}
-	logger.Println("Spot instance requests were previously created for", a.name)
+	a.region.logger().Println("Spot instance requests were previously created for", a.name)
 
 	for _, req := range resp.SpotInstanceRequests {
 		a.spotInstanceRequests = append(a.spotInstanceRequests,
@@ -362,13 +463,13 @@ func (a *autoScalingGroup) findSpotInstanceRequests() error {
 
 func (a *autoScalingGroup) scanInstances() instances {
 
-	logger.Println("Adding instances to", a.name)
+	a.region.logger().Println("Adding instances to", a.name)
 	a.instances = makeInstances()
 
 	for _, inst := range a.Instances {
 		i := a.region.instances.get(*inst.InstanceId)
 
-		debug.Println(i)
+		a.region.debugLogger().Println(i)
 
 		if i == nil {
 			continue
@@ -376,6 +477,12 @@ func (a *autoScalingGroup) scanInstances() instances {
 
 		i.asg, i.region = a, a.region
 
+		if i.InstanceType != nil {
+			if typeInfo, ok := a.instanceTypeInformation[*i.InstanceType]; ok {
+				i.typeInfo = typeInfo
+			}
+		}
+
 		if i.isSpot() {
 			i.price = i.typeInfo.pricing.spot[*i.Placement.AvailabilityZone]
 		} else {
@@ -389,6 +496,78 @@ func (a *autoScalingGroup) scanInstances() instances {
 	return a.instances
 }
 
+// loadInstanceTypeInformation determines the operating system of the group
+// and loads the instance type information priced for it.
+func (a *autoScalingGroup) loadInstanceTypeInformation() {
+	a.spotProductDescription = a.loadSpotProductDescription()
+	a.region.logger().Println(a.name, "Using the spot product description",
+		a.spotProductDescription)
+	a.instanceTypeInformation = a.region.getInstanceTypeInformation(a.spotProductDescription)
+}
+
+// getInstanceTypeInformation returns the instance type information priced for
+// the group's operating system, or the region-wide one if not yet loaded.
+func (a *autoScalingGroup) getInstanceTypeInformation() map[string]instanceTypeInformation {
+	if a.instanceTypeInformation != nil {
+		return a.instanceTypeInformation
+	}
+	return a.region.instanceTypeInformation
+}
+
+func (a *autoScalingGroup) loadSpotProductDescription() string {
+
+	// ASG Tag config has a priority to override
+	if tagValue := a.getTagValue(SpotProductDescriptionTag); tagValue != nil {
+		if isValidSpotProductDescription(*tagValue) {
+			return *tagValue
+		}
+		a.region.logger().Println(a.name, "Ignoring invalid value", *tagValue, "of tag",
+			SpotProductDescriptionTag)
+	}
+
+	if product := a.detectSpotProductDescription(); product != "" {
+		return product
+	}
+
+	return a.region.conf.SpotProductDescription
+}
+
+// detectSpotProductDescription determines the spot product description based
+// on the AMI used by the group's launch configuration and on whether the
+// group is running in a VPC.
+func (a *autoScalingGroup) detectSpotProductDescription() string {
+	lc := a.getLaunchConfiguration()
+	if lc == nil || lc.ImageId == nil {
+		return ""
+	}
+
+	resp, err := a.region.services.ec2.DescribeImages(
+		&ec2.DescribeImagesInput{
+			ImageIds: []*string{lc.ImageId},
+		})
+
+	if err != nil {
+		a.region.logger().Println(a.name, "Failed to describe the image", *lc.ImageId,
+			err.Error())
+		return ""
+	}
+
+	if len(resp.Images) == 0 {
+		a.region.logger().Println(a.name, "Couldn't find the image", *lc.ImageId)
+		return ""
+	}
+
+	product := imageProduct(resp.Images[0])
+
+	if a.VPCZoneIdentifier != nil && *a.VPCZoneIdentifier != "" {
+		product += vpcProductSuffix
+	}
+
+	a.region.debugLogger().Println(a.name, "Detected the spot product description", product,
+		"from the image", *lc.ImageId)
+	return product
+}
+
 func (a *autoScalingGroup) propagatedInstanceTags() []*ec2.Tag {
 	var tags []*ec2.Tag
 
@@ -410,52 +589,61 @@ func (a *autoScalingGroup) propagatedInstanceTags() []*ec2.Tag {
 func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(
 	spotInstanceID *string) error {
 
-	minSize, maxSize := *a.MinSize, *a.MaxSize
-	desiredCapacity := *a.DesiredCapacity
-
-	// temporarily increase AutoScaling group in case it's of static size
-	if minSize == maxSize {
-		logger.Println(a.name, "Temporarily increasing MaxSize")
-		a.setAutoScalingMaxSize(maxSize + 1)
-		defer a.setAutoScalingMaxSize(maxSize)
-	}
-
 	// get the details of our spot instance so we can see its AZ
-	logger.Println(a.name, "Retrieving instance details for ", *spotInstanceID)
+	a.region.logger().Println(a.name, "Retrieving instance details for ", *spotInstanceID)
 	spotInst := a.region.instances.get(*spotInstanceID)
 	if spotInst == nil {
 		return errors.New("couldn't find spot instance to use")
 	}
 	az := spotInst.Placement.AvailabilityZone
 
-	logger.Println(a.name, *spotInstanceID, "is in the availability zone",
+	a.region.logger().Println(a.name, *spotInstanceID, "is in the availability zone",
 		*az, "looking for an on-demand instance there")
 
 	// find an on-demand instance from the same AZ as our spot instance
 	odInst := a.getOnDemandInstanceInAZ(az)
 
 	if odInst == nil {
-		logger.Println(a.name, "found no on-demand instances that could be",
+		a.region.logger().Println(a.name, "found no on-demand instances that could be",
 			"replaced with the new spot instance", *spotInst.InstanceId,
 			"terminating the spot instance.")
 		spotInst.terminate()
 		return errors.New("couldn't find ondemand instance to replace")
 	}
-	logger.Println(a.name, "found on-demand instance", *odInst.InstanceId,
+	a.region.logger().Println(a.name, "found on-demand instance", *odInst.InstanceId,
 		"replacing with new spot instance", *spotInst.InstanceId)
+
+	return a.swapInstances(spotInstanceID, odInst)
+}
+
+// swapInstances attaches a new instance to the group, then detaches and
+// terminates the old instance it replaces, keeping the group's capacity.
+func (a *autoScalingGroup) swapInstances(newInstanceID *string,
+	oldInstance *instance) error {
+
+	minSize, maxSize := *a.MinSize, *a.MaxSize
+	desiredCapacity := *a.DesiredCapacity
+
+	// temporarily increase AutoScaling group in case it's of static size
+	if minSize == maxSize {
+		a.region.logger().Println(a.name, "Temporarily increasing MaxSize")
+		a.setAutoScalingMaxSize(maxSize + 1)
+		defer a.setAutoScalingMaxSize(maxSize)
+	}
+
 	// revert attach/detach order when running on minimum capacity
 	if desiredCapacity == minSize {
-		attachErr := a.attachSpotInstance(spotInstanceID)
+		attachErr := a.attachInstance(newInstanceID)
 		if attachErr != nil {
-			logger.Println(a.name, "skipping detaching on-demand due to failure to",
-				"attach the new spot instance", *spotInst.InstanceId)
+			a.region.logger().Println(a.name, "skipping detaching", *oldInstance.InstanceId,
+				"due to failure to attach the new instance", *newInstanceID)
 			return nil
 		}
 	} else {
-		defer a.attachSpotInstance(spotInstanceID)
+		defer a.attachInstance(newInstanceID)
 	}
 
-	return a.detachAndTerminateOnDemandInstance(odInst.InstanceId)
+	return a.detachAndTerminateInstance(oldInstance.InstanceId)
 }
 
 // Returns the information about the first running instance found in
@@ -483,6 +671,13 @@ func (a *autoScalingGroup) getInstance(
 					(!onDemand && !i.isSpot())) {
 				continue
 			}
+
+			// on-demand instances covered by reserved instances are kept running,
+			// and the ones running on dedicated hosts can't be replaced
+			if !any && onDemand && (i.isReserved() || !i.hasSpotCompatibleTenancy()) {
+				continue
+			}
+
 			if (availabilityZone != nil) &&
 				(*availabilityZone != *i.Placement.AvailabilityZone) {
 				continue
@@ -514,24 +709,24 @@ func (a *autoScalingGroup) havingReadyToAttachSpotInstance() (*string, bool) {
 	// if there are on-demand instances but no spot instance requests yet,
 	// then we can launch a new spot instance
 	if len(a.spotInstanceRequests) == 0 {
-		logger.Println(a.name, "no spot bids were found")
+		a.region.logger().Println(a.name, "no spot bids were found")
 		if inst := a.getAnyOnDemandInstance(); inst != nil {
-			logger.Println(a.name, "on-demand instances were found, proceeding to "+
+			a.region.logger().Println(a.name, "on-demand instances were found, proceeding to "+
 				"launch a replacement spot instance")
 			return nil, false
 		}
 		// Looks like we have no instances in the group, so we can stop here
-		logger.Println(a.name, "no on-demand instances were found, nothing to do")
+		a.region.logger().Println(a.name, "no on-demand instances were found, nothing to do")
 		return nil, true
 	}
 
-	logger.Println("spot bids were found, continuing")
+	a.region.logger().Println("spot bids were found, continuing")
 
 	// Here we search for open spot requests created for the current ASG, and try
 	// to wait for their instances to start.
 	for _, req := range a.spotInstanceRequests {
 		if *req.State == "open" && *req.Tags[0].Value == a.name {
-			logger.Println(a.name, "Open bid found for current AutoScaling Group, "+
+			a.region.logger().Println(a.name, "Open bid found for current AutoScaling Group, "+
 				"waiting for the instance to start so it can be tagged...")
 
 			// Here we resume the wait for instances, initiated after requesting the
@@ -550,30 +745,30 @@ func (a *autoScalingGroup) havingReadyToAttachSpotInstance() (*string, bool) {
 		// We found a spot request with a running instance.
 		if *req.State == "active" &&
 			*req.Status.Code == "fulfilled" {
-			logger.Println(a.name, "Active bid was found, with instance already "+
+			a.region.logger().Println(a.name, "Active bid was found, with instance already "+
 				"started:", *req.InstanceId)
 
 			// If the instance is already in the group we don't need to do anything.
 			if a.instances.get(*req.InstanceId) != nil {
-				logger.Println(a.name, "Instance", *req.InstanceId,
+				a.region.logger().Println(a.name, "Instance", *req.InstanceId,
 					"is already attached to the ASG, skipping...")
 				continue
 
 				// In case the instance wasn't yet attached, we prepare to attach it.
 			} else {
-				logger.Println(a.name, "Instance", *req.InstanceId,
+				a.region.logger().Println(a.name, "Instance", *req.InstanceId,
 					"is not yet attached to the ASG, checking if it's running")
 
 				if i := a.instances.get(*req.InstanceId); i != nil &&
 					i.State != nil &&
 					*i.State.Name == "running" {
-					logger.Println(a.name, "Active bid was found, with running "+
+					a.region.logger().Println(a.name, "Active bid was found, with running "+
 						"instances not yet attached to the ASG",
 						*req.InstanceId)
 					activeSpotInstanceRequest = req
 					break
 				} else {
-					logger.Println(a.name, "Active bid was found, with no running "+
+					a.region.logger().Println(a.name, "Active bid was found, with no running "+
 						"instances, waiting for an instance to start ...")
 					req.waitForAndTagSpotInstance()
 					activeSpotInstanceRequest = req
@@ -586,28 +781,28 @@ func (a *autoScalingGroup) havingReadyToAttachSpotInstance() (*string, bool) {
 	// process of starting or already ready to be attached to the group, we can
 	// launch a new spot instance.
 	if activeSpotInstanceRequest == nil {
-		logger.Println(a.name, "No active unfulfilled bid was found")
+		a.region.logger().Println(a.name, "No active unfulfilled bid was found")
 		return nil, false
 	}
 
 	spotInstanceID := activeSpotInstanceRequest.InstanceId
 
 	if spotInstanceID == nil {
-		logger.Println(a.name,
+		a.region.logger().Println(a.name,
 			"No instance was launched from the active spot instance request",
 			*activeSpotInstanceRequest.SpotInstanceRequestId)
 		return nil, false
 	}
 
-	logger.Println("Considering ", *spotInstanceID, "for attaching to", a.name)
+	a.region.logger().Println("Considering ", *spotInstanceID, "for attaching to", a.name)
 
 	instData := a.region.instances.get(*spotInstanceID)
 	gracePeriod := *a.HealthCheckGracePeriod
 
-	debug.Println(instData)
+	a.region.debugLogger().Println(instData)
 
 	if instData == nil || instData.LaunchTime == nil {
-		logger.Println("Apparently", *spotInstanceID, "is no longer running, ",
+		a.region.logger().Println("Apparently", *spotInstanceID, "is no longer running, ",
 			"cancelling the spot instance request which created it...")
 
 		a.region.services.ec2.CancelSpotInstanceRequests(
@@ -617,20 +812,20 @@ func (a *autoScalingGroup) havingReadyToAttachSpotInstance() (*string, bool) {
 		return nil, true
 	}
 
-	instanceUpTime := time.Now().Unix() - instData.LaunchTime.Unix()
+	instanceUpTime := a.region.conf.clock().Now().Unix() - instData.LaunchTime.Unix()
 
-	logger.Println("Instance uptime:", time.Duration(instanceUpTime)*time.Second)
+	a.region.logger().Println("Instance uptime:", time.Duration(instanceUpTime)*time.Second)
 
 	// Check if the spot instance is out of the grace period, so in that case we
 	// can replace an on-demand instance with it
 	if *instData.State.Name == "running" &&
 		instanceUpTime < gracePeriod {
-		logger.Println("The new spot instance", *spotInstanceID,
+		a.region.logger().Println("The new spot instance", *spotInstanceID,
 			"is still in the grace period,",
 			"waiting for it to be ready before we can attach it to the group...")
 		return nil, true
 	} else if *instData.State.Name == "pending" {
-		logger.Println("The new spot instance", *spotInstanceID,
+		a.region.logger().Println("The new spot instance", *spotInstanceID,
 			"is still pending,",
 			"waiting for it to be running before we can attach it to the group...")
 		return nil, true
@@ -655,14 +850,20 @@ func (a *autoScalingGroup) getAllowedInstanceTypes(baseInstance *instance) []str
 		allowed = allowedInstanceTypesTag
 	}
 
-	if allowed == "current" {
-		return []string{baseInstance.typeInfo.instanceType}
-	}
-
 	// Simple trick to avoid returning list with empty elements
-	return strings.FieldsFunc(allowed, func(c rune) bool {
+	allowedList := strings.FieldsFunc(allowed, func(c rune) bool {
 		return c == ','
 	})
+
+	// The special "current" value can be combined with globs and instance type
+	// selectors, and stands for the type of the base instance.
+	for i, a := range allowedList {
+		if a == "current" {
+			allowedList[i] = baseInstance.typeInfo.instanceType
+		}
+	}
+
+	return allowedList
 }
 
 func (a *autoScalingGroup) getDisallowedInstanceTypes(baseInstance *instance) []string {
@@ -688,17 +889,66 @@ func (a *autoScalingGroup) getDisallowedInstanceTypes(baseInstance *instance) []
 	})
 }
 
+func (a *autoScalingGroup) allowsBurstableInstanceTypes() bool {
+
+	// ASG Tag config has a priority to override
+	if tagValue := a.getTagValue(AllowBurstableInstanceTypesTag); tagValue != nil {
+		if allowed, err := strconv.ParseBool(*tagValue); err == nil {
+			return allowed
+		}
+		a.region.logger().Println(a.name, "Ignoring invalid value", *tagValue, "of tag",
+			AllowBurstableInstanceTypesTag)
+	}
+
+	return a.region != nil && a.region.conf != nil &&
+		a.region.conf.AllowBurstableInstanceTypes
+}
+
+// carryOverCPUCredits configures a new burstable spot instance with the same
+// CPU credit option used by the on-demand instances of the group. When it
+// replaces a fixed-performance instance, it uses standard CPU credits, so it
+// can't accrue the surplus credit charges of the unlimited mode, which isn't
+// accounted for when comparing their prices.
+func (a *autoScalingGroup) carryOverCPUCredits(spotInstance *instance) error {
+	if !spotInstance.typeInfo.burstable {
+		return nil
+	}
+
+	odInstance := a.getAnyOnDemandInstance()
+	if odInstance == nil {
+		return nil
+	}
+
+	if !odInstance.typeInfo.burstable {
+		return spotInstance.setCPUCredits("standard")
+	}
+
+	if odInstance.cpuCredits == "" {
+		if err := odInstance.loadCPUCredits(); err != nil {
+			a.region.logger().Println(a.name, "Couldn't determine the CPU credits of",
+				*odInstance.InstanceId, err.Error())
+			return err
+		}
+	}
+
+	if odInstance.cpuCredits == "" {
+		return nil
+	}
+
+	return spotInstance.setCPUCredits(odInstance.cpuCredits)
+}
+
 func (a *autoScalingGroup) getPricetoBid(
 	baseOnDemandPrice float64, currentSpotPrice float64) float64 {
 
-	logger.Println("BiddingPolicy: ", a.region.conf.BiddingPolicy)
+	a.region.logger().Println("BiddingPolicy: ", a.region.conf.BiddingPolicy)
 
 	if a.region.conf.BiddingPolicy == DefaultBiddingPolicy {
-		logger.Println("Launching spot instance with a bid =", baseOnDemandPrice)
+		a.region.logger().Println("Launching spot instance with a bid =", baseOnDemandPrice)
 		return baseOnDemandPrice
 	}
 
-	logger.Println("Launching spot instance with a bid =", math.Min(baseOnDemandPrice, currentSpotPrice*(1.0+a.region.conf.SpotPriceBufferPercentage/100.0)))
+	a.region.logger().Println("Launching spot instance with a bid =", math.Min(baseOnDemandPrice, currentSpotPrice*(1.0+a.region.conf.SpotPriceBufferPercentage/100.0)))
 	return math.Min(baseOnDemandPrice, currentSpotPrice*(1.0+a.region.conf.SpotPriceBufferPercentage/100.0))
 }
 
@@ -725,44 +975,64 @@ func (a *autoScalingGroup) launchCheapestSpotInstance(
 	baseOnDemandPrice := baseInstance.price
 	currentSpotPrice := newInstanceType.pricing.spot[*azToLaunchIn]
 
-	logger.Println("Bidding for spot instance for ", a.name)
-	return a.bidForSpotInstance(spotLS, a.getPricetoBid(baseOnDemandPrice, currentSpotPrice))
+	bid := a.getPricetoBid(baseOnDemandPrice, currentSpotPrice)
+
+	if limit, ok := lc.spotPriceLimit(); ok && bid > limit {
+		if currentSpotPrice > limit {
+			return fmt.Errorf("the current spot price %v exceeds the spot price "+
+				"%v set in the launch configuration", currentSpotPrice, limit)
+		}
+		a.region.logger().Println("Limiting the bid to the spot price set in the launch "+
+			"configuration:", limit)
+		bid = limit
+	}
+
+	a.region.logger().Println("Bidding for spot instance for ", a.name)
+	return a.bidForSpotInstance(spotLS, bid)
 }
 
 func (a *autoScalingGroup) getBaseAndNewInstanceTypeToStart(azToLaunchIn *string) (*instance, *instanceTypeInformation, error) {
 	if azToLaunchIn == nil {
-		logger.Println("Can't launch instances in any AZ, nothing to do here...")
+		a.region.logger().Println("Can't launch instances in any AZ, nothing to do here...")
 		return nil, nil, errors.New("invalid availability zone provided")
 	}
 
-	logger.Println("Trying to launch spot instance in", *azToLaunchIn,
+	a.region.logger().Println("Trying to launch spot instance in", *azToLaunchIn,
 		"first finding an on-demand instance to use as a template")
 
 	baseInstance := a.getOnDemandInstanceInAZ(azToLaunchIn)
 
 	if baseInstance == nil {
-		logger.Println("Found no on-demand instances, nothing to do here...")
+		a.region.logger().Println("Found no on-demand instances, nothing to do here...")
 		return nil, nil, errors.New("no on-demand instances found")
 	}
-	logger.Println("Found on-demand instance", *baseInstance.InstanceId)
+	a.region.logger().Println("Found on-demand instance", *baseInstance.InstanceId)
+
+	if baseInstance.typeInfo.burstable {
+		if err := baseInstance.loadCPUCredits(); err != nil {
+			a.region.logger().Println("Couldn't determine the CPU credits of",
+				*baseInstance.InstanceId, err.Error())
+		}
+	}
 
 	allowedInstances := a.getAllowedInstanceTypes(baseInstance)
 	disallowedInstances := a.getDisallowedInstanceTypes(baseInstance)
 
-	newInstanceTypeStr, err := baseInstance.getCheapestCompatibleSpotInstanceType(allowedInstances, disallowedInstances)
+	newInstanceTypeStr, err := baseInstance.getCompatibleSpotInstanceType(allowedInstances,
+		disallowedInstances, a.getSelectionStrategy())
 	if err != nil {
-		logger.Println("No cheaper compatible instance type was found, "+
+		a.region.logger().Println("No cheaper compatible instance type was found, "+
 			"nothing to do here...", err)
 		return nil, nil, errors.New("no cheaper spot instance found")
 	}
 
-	newInstanceType := a.region.instanceTypeInformation[newInstanceTypeStr]
+	newInstanceType := a.getInstanceTypeInformation()[newInstanceTypeStr]
 
 	currentSpotPrice := newInstanceType.pricing.spot[*azToLaunchIn]
-	logger.Println("Finished searching for best spot instance in ", *azToLaunchIn)
-	logger.Println("Replacing an on-demand", *baseInstance.InstanceType,
+	a.region.logger().Println("Finished searching for best spot instance in ", *azToLaunchIn)
+	a.region.logger().Println("Replacing an on-demand", *baseInstance.InstanceType,
 		"instance having the ondemand price", baseInstance.price)
-	logger.Println("Launching best compatible instance:", newInstanceType,
+	a.region.logger().Println("Launching best compatible instance:", newInstanceType,
 		"with the current spot price:", currentSpotPrice)
 
 	return baseInstance, &newInstanceType, nil
@@ -789,7 +1059,7 @@ func (a *autoScalingGroup) bidForSpotInstance(
 	})
 
 	if err != nil {
-		logger.Println("Failed to create spot instance request for",
+		a.region.logger().Println("Failed to create spot instance request for",
 			a.name, err.Error(), ls)
 		return err
 	}
@@ -802,7 +1072,7 @@ func (a *autoScalingGroup) bidForSpotInstance(
 
 	srID := sr.SpotInstanceRequestId
 
-	logger.Println(a.name, "Created spot instance request", *srID)
+	a.region.logger().Println(a.name, "Created spot instance request", *srID)
 
 	// tag the spot instance request to associate it with the current ASG, so we
 	// know where to attach the instance later. In case the waiter failed, it may
@@ -811,7 +1081,7 @@ func (a *autoScalingGroup) bidForSpotInstance(
 	err = sr.tag(a.name)
 
 	if err != nil {
-		logger.Println(a.name, "Can't tag spot instance request", err.Error())
+		a.region.logger().Println(a.name, "Can't tag spot instance request", err.Error())
 		return err
 	}
 	// Waiting for the instance to start so that we can then later tag it with
@@ -836,7 +1106,7 @@ func (a *autoScalingGroup) setAutoScalingMaxSize(maxSize int64) error {
 	if err != nil {
 		// Print the error, cast err to awserr.Error to get the Code and
 		// Message from an error.
-		logger.Println(err.Error())
+		a.region.logger().Println(err.Error())
 		return err
 	}
 	return nil
@@ -861,47 +1131,48 @@ func (a *autoScalingGroup) getLaunchConfiguration() *launchConfiguration {
 	resp, err := svc.DescribeLaunchConfigurations(params)
 
 	if err != nil {
-		logger.Println(err.Error())
+		a.region.logger().Println(err.Error())
 		return nil
 	}
 
 	a.launchConfiguration = &launchConfiguration{
 		LaunchConfiguration: resp.LaunchConfigurations[0],
+		logs:                a.region.logs(),
 	}
 	return a.launchConfiguration
 }
 
-func (a *autoScalingGroup) attachSpotInstance(spotInstanceID *string) error {
+func (a *autoScalingGroup) attachInstance(instanceID *string) error {
 
 	svc := a.region.services.autoScaling
 
 	params := autoscaling.AttachInstancesInput{
 		AutoScalingGroupName: aws.String(a.name),
 		InstanceIds: []*string{
-			spotInstanceID,
+			instanceID,
 		},
 	}
 
 	resp, err := svc.AttachInstances(&params)
 
 	if err != nil {
-		logger.Println(err.Error())
+		a.region.logger().Println(err.Error())
 		// Pretty-print the response data.
-		logger.Println(resp)
+		a.region.logger().Println(resp)
 		return err
 	}
 	return nil
 }
 
-// Terminates an on-demand instance from the group,
+// Terminates an instance from the group,
 // but only after it was detached from the autoscaling group
-func (a *autoScalingGroup) detachAndTerminateOnDemandInstance(
+func (a *autoScalingGroup) detachAndTerminateInstance(
 	instanceID *string) error {
-	logger.Println(a.region.name,
+	a.region.logger().Println(a.region.name,
 		a.name,
 		"Detaching and terminating instance:",
 		*instanceID)
-	// detach the on-demand instance
+	// detach the instance
 	detachParams := autoscaling.DetachInstancesInput{
 		AutoScalingGroupName: aws.String(a.name),
 		InstanceIds: []*string{
@@ -913,12 +1184,12 @@ func (a *autoScalingGroup) detachAndTerminateOnDemandInstance(
 	asSvc := a.region.services.autoScaling
 
 	if _, err := asSvc.DetachInstances(&detachParams); err != nil {
-		logger.Println(err.Error())
+		a.region.logger().Println(err.Error())
 		return err
 	}
 
 	// Wait till detachment initialize is complete before terminate instance
-	time.Sleep(20 * time.Second * a.region.conf.SleepMultiplier)
+	a.region.conf.clock().Sleep(20 * time.Second * a.region.conf.SleepMultiplier)
 
 	return a.instances.get(*instanceID).terminate()
 }
@@ -933,7 +1204,7 @@ func (a *autoScalingGroup) alreadyRunningInstanceCount(
 	if !spot {
 		instanceCategory = "on-demand"
 	}
-	logger.Println(a.name, "Counting already running on demand instances ")
+	a.region.logger().Println(a.name, "Counting already running on demand instances ")
 	for inst := range a.instances.instances() {
 		if *inst.Instance.State.Name == "running" {
 			// Count running Spot instances
@@ -949,7 +1220,7 @@ func (a *autoScalingGroup) alreadyRunningInstanceCount(
 			total++
 		}
 	}
-	logger.Println(a.name, "Found", count, instanceCategory, "instances running on a total of", total)
+	a.region.logger().Println(a.name, "Found", count, instanceCategory, "instances running on a total of", total)
 	return count, total
 }
 
@@ -1391,7 +1391,7 @@ func TestNeedReplaceOnDemandInstances(t *testing.T) {
 	}
 }
 
-func TestDetachAndTerminateOnDemandInstance(t *testing.T) {
+func TestDetachAndTerminateInstance(t *testing.T) {
 	tests := []struct {
 		name         string
 		instancesASG instances
@@ -1508,13 +1508,13 @@ func TestDetachAndTerminateOnDemandInstance(t *testing.T) {
 				region:    tt.regionASG,
 				instances: tt.instancesASG,
 			}
-			err := a.detachAndTerminateOnDemandInstance(tt.instanceID)
+			err := a.detachAndTerminateInstance(tt.instanceID)
 			CheckErrors(t, err, tt.expected)
 		})
 	}
 }
 
-func TestAttachSpotInstance(t *testing.T) {
+func TestAttachInstance(t *testing.T) {
 	tests := []struct {
 		name       string
 		regionASG  *region
@@ -1548,7 +1548,7 @@ func TestAttachSpotInstance(t *testing.T) {
 				name:   "testASG",
 				region: tt.regionASG,
 			}
-			err := a.attachSpotInstance(tt.instanceID)
+			err := a.attachInstance(tt.instanceID)
 			CheckErrors(t, err, tt.expected)
 		})
 	}
@@ -3164,6 +3164,32 @@ func TestGetAllowedInstanceTypes(t *testing.T) {
 			},
 			asgtags: []*autoscaling.TagDescription{},
 		},
+		{name: "Current combined with instance type selectors",
+			expected: []string{"c2.xlarge", "c4.*", "same-generation-or-newer", "compute"},
+			instanceInfo: &instance{
+				typeInfo: instanceTypeInformation{
+					instanceType: "c2.xlarge",
+				},
+				region: &region{},
+			},
+			asg: &autoScalingGroup{
+				name: "TestASG",
+				region: &region{
+					conf: &Config{
+						AllowedInstanceTypes: "c4.xlarge",
+					},
+				},
+				Group: &autoscaling.Group{
+					DesiredCapacity: aws.Int64(4),
+				},
+			},
+			asgtags: []*autoscaling.TagDescription{
+				{
+					Key:   aws.String("autospotting_allowed_instance_types"),
+					Value: aws.String("current,c4.* same-generation-or-newer,compute"),
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -3419,3 +3445,189 @@ func TestGetPricetoBid(t *testing.T) {
 		}
 	}
 }
+
+func TestCarryOverCPUCredits(t *testing.T) {
+	tests := []struct {
+		name         string
+		spotInstance *instance
+		odInstance   *instance
+		ec2          mockEC2
+		want         string
+		wantErr      error
+	}{
+		{name: "fixed-performance spot instance",
+			spotInstance: &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("spot")},
+				typeInfo: instanceTypeInformation{instanceType: "m5.large"},
+			},
+			odInstance: &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("od"),
+					State:      &ec2.InstanceState{Name: aws.String("running")},
+				},
+				typeInfo:   instanceTypeInformation{instanceType: "t2.large", burstable: true},
+				cpuCredits: "unlimited",
+			},
+			want: "",
+		},
+		{name: "burstable spot instance",
+			spotInstance: &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("spot")},
+				typeInfo: instanceTypeInformation{instanceType: "t3.large", burstable: true},
+			},
+			odInstance: &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("od"),
+					State:      &ec2.InstanceState{Name: aws.String("running")},
+				},
+				typeInfo: instanceTypeInformation{instanceType: "t2.large", burstable: true},
+			},
+			ec2: mockEC2{
+				dicso: &ec2.DescribeInstanceCreditSpecificationsOutput{
+					InstanceCreditSpecifications: []*ec2.InstanceCreditSpecification{
+						{
+							InstanceId: aws.String("od"),
+							CpuCredits: aws.String("unlimited"),
+						},
+					},
+				},
+				micso: &ec2.ModifyInstanceCreditSpecificationOutput{},
+			},
+			want: "unlimited",
+		},
+		{name: "burstable spot instance replacing a fixed-performance one",
+			spotInstance: &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("spot")},
+				typeInfo: instanceTypeInformation{instanceType: "t3.large", burstable: true},
+			},
+			odInstance: &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("od"),
+					State:      &ec2.InstanceState{Name: aws.String("running")},
+				},
+				typeInfo: instanceTypeInformation{instanceType: "m5.large"},
+			},
+			ec2: mockEC2{
+				micso: &ec2.ModifyInstanceCreditSpecificationOutput{},
+			},
+			want: "standard",
+		},
+		{name: "failure to modify the credit specification",
+			spotInstance: &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("spot")},
+				typeInfo: instanceTypeInformation{instanceType: "t3.large", burstable: true},
+			},
+			odInstance: &instance{
+				Instance: &ec2.Instance{
+					InstanceId: aws.String("od"),
+					State:      &ec2.InstanceState{Name: aws.String("running")},
+				},
+				typeInfo:   instanceTypeInformation{instanceType: "t2.large", burstable: true},
+				cpuCredits: "standard",
+			},
+			ec2: mockEC2{
+				micserr: errors.New("modify error"),
+			},
+			want:    "",
+			wantErr: errors.New("modify error"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "test-region", services: connections{ec2: tt.ec2}}
+			tt.spotInstance.region, tt.odInstance.region = r, r
+			a := &autoScalingGroup{
+				name:   "test-asg",
+				region: r,
+				instances: makeInstancesWithCatalog(map[string]*instance{
+					"od": tt.odInstance,
+				}),
+			}
+			err := a.carryOverCPUCredits(tt.spotInstance)
+			CheckErrors(t, err, tt.wantErr)
+			if tt.spotInstance.cpuCredits != tt.want {
+				t.Errorf("carryOverCPUCredits() set %v, want %v",
+					tt.spotInstance.cpuCredits, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSpotProductDescription(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []*autoscaling.TagDescription
+		vpc  *string
+		lc   *autoscaling.LaunchConfiguration
+		ec2  mockEC2
+		want string
+	}{
+		{name: "product description from tag",
+			tags: []*autoscaling.TagDescription{
+				{
+					Key:   aws.String(SpotProductDescriptionTag),
+					Value: aws.String("Windows (Amazon VPC)"),
+				},
+			},
+			want: "Windows (Amazon VPC)",
+		},
+		{name: "invalid tag and no launch configuration",
+			tags: []*autoscaling.TagDescription{
+				{
+					Key:   aws.String(SpotProductDescriptionTag),
+					Value: aws.String("Plan 9"),
+				},
+			},
+			want: DefaultSpotProductDescription,
+		},
+		{name: "detected from a Windows AMI in a VPC",
+			vpc: aws.String("subnet-1,subnet-2"),
+			lc:  &autoscaling.LaunchConfiguration{ImageId: aws.String("ami-1")},
+			ec2: mockEC2{
+				dimo: &ec2.DescribeImagesOutput{
+					Images: []*ec2.Image{{Platform: aws.String("windows")}},
+				},
+			},
+			want: "Windows (Amazon VPC)",
+		},
+		{name: "detected from a SUSE AMI in EC2 Classic",
+			lc: &autoscaling.LaunchConfiguration{ImageId: aws.String("ami-1")},
+			ec2: mockEC2{
+				dimo: &ec2.DescribeImagesOutput{
+					Images: []*ec2.Image{{Name: aws.String("suse-sles-12")}},
+				},
+			},
+			want: "SUSE Linux",
+		},
+		{name: "failure to describe the AMI",
+			lc: &autoscaling.LaunchConfiguration{ImageId: aws.String("ami-1")},
+			ec2: mockEC2{
+				dimerr: errors.New("describe error"),
+			},
+			want: DefaultSpotProductDescription,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name: "test-asg",
+				Group: &autoscaling.Group{
+					Tags:              tt.tags,
+					VPCZoneIdentifier: tt.vpc,
+				},
+				region: &region{
+					conf: &Config{
+						SpotProductDescription: DefaultSpotProductDescription,
+					},
+					services: connections{ec2: tt.ec2},
+				},
+			}
+			if tt.lc != nil {
+				a.launchConfiguration = &launchConfiguration{LaunchConfiguration: tt.lc}
+			}
+			if got := a.loadSpotProductDescription(); got != tt.want {
+				t.Errorf("loadSpotProductDescription() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
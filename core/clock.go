@@ -0,0 +1,81 @@
+package autospotting
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Clock gives the current time and waits, which makes it possible to run with
+// a simulated time, such as when replaying recorded API calls.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// realClock is the system clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// clock returns the configured clock, defaulting to the system clock.
+func (c *Config) clock() Clock {
+	if c == nil {
+		return realClock{}
+	}
+	return orRealClock(c.Clock)
+}
+
+// orRealClock returns the given clock, defaulting to the system clock.
+func orRealClock(c Clock) Clock {
+	if c == nil {
+		return realClock{}
+	}
+	return c
+}
+
+// waiterClock makes the AWS SDK waiters wait using the given clock between
+// their attempts.
+func waiterClock(c Clock) request.WaiterOption {
+	return func(w *request.Waiter) {
+		if _, ok := c.(realClock); ok {
+			return
+		}
+		w.SleepWithContext = func(ctx aws.Context, d time.Duration) error {
+			c.Sleep(d)
+			return ctx.Err()
+		}
+	}
+}
+
+// replayClock is a simulated clock following the times of the replayed API
+// calls, moved forward without actually waiting.
+type replayClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *replayClock) Sleep(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// advance moves the clock to the given time, unless already past it.
+func (c *replayClock) advance(t time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+}
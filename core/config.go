@@ -2,6 +2,7 @@ package autospotting
 
 import (
 	"io"
+	"log"
 	"time"
 
 	"github.com/cristim/ec2-instances-info"
@@ -14,10 +15,42 @@ type Config struct {
 	// Static data fetched from ec2instances.info
 	InstanceData *ec2instancesinfo.InstanceData
 
+	// Optional external instance data in the ec2instances.info format, read
+	// from a local file, S3 or HTTP URL, replacing the bundled data. Remote
+	// data is cached in a local directory and can be verified against a
+	// SHA-256 checksum.
+	InstanceDataSource   string
+	InstanceDataChecksum string
+	InstanceDataCache    string
+
+	// Local file adding or patching individual instance types
+	InstanceDataOverrides string
+
+	// On-demand prices of the other operating systems, also taken from the
+	// ec2instances.info data
+	OSPrices OSPrices
+
+	// Fetch the current on-demand prices from the AWS Pricing API, falling
+	// back to the bundled ones when they are unavailable
+	LiveOnDemandPrices bool
+
+	// Local directory or S3 URL such as s3://bucket/prefix where the prices
+	// fetched from the Pricing API are cached, and for how long
+	PriceCache    string
+	PriceCacheTTL time.Duration
+
 	// Logging
 	LogFile io.Writer
 	LogFlag int
 
+	// Logger used instead of the one writing to LogFile. It is never
+	// modified, the prefixes such as the account IDs being added by other
+	// loggers writing to it.
+	Logger *log.Logger
+
+	// the loggers of an Engine, the ones of the process being used if nil
+	logs *loggers
+
 	// The region where the Lambda function is deployed
 	MainRegion string
 
@@ -31,11 +64,111 @@ type Config struct {
 	SpotProductDescription    string
 	BiddingPolicy             string
 
+	// Strategy choosing the spot instance type among the compatible ones
+	SelectionStrategy string
+
+	// Spot interruption frequency data, loaded from a local file, an S3 or
+	// an HTTP URL in the Spot Instance Advisor format
+	InterruptionDataSource string
+	InterruptionData       *InterruptionData
+
+	// Highest acceptable interruption frequency band, given as its index or
+	// label, and the percentage added to the price of the spot candidates for
+	// each band when ranking them
+	MaxInterruptionBand           string
+	InterruptionPenaltyPercentage float64
+
+	// Per region and instance type on-demand price multipliers, taking
+	// precedence over OnDemandPriceMultiplier
+	OnDemandPriceMultipliers PriceMultipliers
+
+	// Keep running the on-demand instances covered by reserved instances
+	ReservedInstancesAware bool
+
+	// Allow burstable instance types to replace fixed-performance instances
+	AllowBurstableInstanceTypes bool
+
+	// Estimated CPU utilization above the baseline, used for pricing the
+	// surplus CPU credits of burstable instances running in unlimited mode
+	BurstableSurplusCreditsPercentage float64
+
+	// Limits of the regions processed in parallel, and of the groups
+	// processed in parallel across all the regions and accounts, a region with
+	// many groups possibly delaying the groups of the others. Zero means no
+	// limit.
+	MaxConcurrentRegions int
+	MaxConcurrentGroups  int
+
+	// worker pool shared by the groups of all the regions
+	groupPool workerPool
+
+	// Maximum rate of the API calls across all regions, zero meaning no
+	// limit, and the number of retries of the throttled API calls
+	APIRequestsPerSecond float64
+	APIMaxRetries        int
+
+	// rate limiter shared by all the API clients
+	apiLimiter *rateLimiter
+
+	// When running as a daemon, the interval or cron expression determining
+	// when to run, and the maximum random delay added to each run
+	Schedule       string
+	ScheduleJitter time.Duration
+
+	// state reused across the runs of the daemon mode and of the same Lambda
+	// function container
+	sessions         *sessionCache
+	priceProvider    *onDemandPriceProvider
+	instanceDataHash string
+
+	// Address of the HTTP status and control API served in daemon mode, such
+	// as "127.0.0.1:8080". The API is disabled when empty.
+	StatusAddress string
+
+	// Bearer token required for processing groups through the API, which is
+	// only allowed when set
+	StatusToken string
+
+	// status of the runs served by the API, and the lock preventing the runs
+	// triggered through the API from overlapping with the scheduled ones
+	status  *statusRecorder
+	runLock workerPool
+
+	// File where all the AWS API calls are recorded, without the user data
+	// of the instances, or from which they are replayed instead of calling
+	// AWS, with a simulated time following the one of the recorded calls
+	RecordFile string
+	ReplayFile string
+
+	// records or replays the API calls
+	apiTraffic apiTraffic
+
+	// Clock used for the current time and for waiting, the system clock
+	// unless replaying
+	Clock Clock
+
+	// Maximum duration of a run, after which no new instance replacements
+	// are started. Zero means no limit other than the Lambda function timeout.
+	MaxRuntime time.Duration
+
 	// This is only here for tests, where we want to be able to somehow mock
 	// time.Sleep without actually sleeping. While testing it defaults to 0 (which won't sleep at all), in
 	// real-world usage it's expected to be set to 1
 	SleepMultiplier time.Duration
 
+	// Current spot prices kept between runs, so only the changes need to be
+	// fetched
+	spotPriceCache *spotPriceCache
+
+	// Accounts processed by assuming a role in each of them, given as a
+	// list, a file or taken from AWS Organizations. The current account is
+	// processed when none are given.
+	Accounts                 string
+	AccountsFile             string
+	AccountsFromOrganization bool
+	AssumeRoleName           string
+	AssumeRoleExternalID     string
+
 	// Filter on ASG tags
 	// for example: spot-enabled=true,environment=dev,team=interactive
 	FilterByTags string
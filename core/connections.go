@@ -4,6 +4,9 @@
 package autospotting
 
 import (
+	"log"
+	"sync"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -17,6 +20,65 @@ type connections struct {
 	autoScaling autoscalingiface.AutoScalingAPI
 	ec2         ec2iface.EC2API
 	region      string
+
+	// the account assumed for these connections, empty for the current one
+	account string
+
+	// the loggers of the region, the ones of the process being used if nil
+	logs *loggers
+
+	// optional rate limiter shared with the connections to the other regions,
+	// and the number of retries of the throttled API calls
+	limiter    *rateLimiter
+	maxRetries int
+}
+
+// sessionCache keeps the sessions, including the credentials of the assumed
+// roles, so they can be reused across runs.
+type sessionCache struct {
+	sync.Mutex
+	sessions map[string]*session.Session
+}
+
+func newSessionCache() *sessionCache {
+	return &sessionCache{sessions: make(map[string]*session.Session)}
+}
+
+// get returns the session cached under the given key, creating it if needed.
+func (c *sessionCache) get(key string,
+	create func() (*session.Session, error)) (*session.Session, error) {
+
+	if c == nil {
+		return create()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if sess, ok := c.sessions[key]; ok {
+		return sess, nil
+	}
+
+	sess, err := create()
+	if err != nil {
+		return nil, err
+	}
+	c.sessions[key] = sess
+	return sess, nil
+}
+
+func (c *connections) logger() *log.Logger {
+	if c == nil {
+		return logger
+	}
+	return c.logs.logger()
+}
+
+func (c *connections) debugLogger() *log.Logger {
+	if c == nil {
+		return debug
+	}
+	return c.logs.debugLogger()
 }
 
 func (c *connections) setSession(region string) {
@@ -26,12 +88,30 @@ func (c *connections) setSession(region string) {
 
 func (c *connections) connect(region string) {
 
-	logger.Println("Creating Service connections in", region)
+	// keep the clients already connected to the region, such as when a group
+	// is scanned repeatedly, or the in-memory fakes used by the tests
+	if c.region == region && c.ec2 != nil && c.autoScaling != nil {
+		return
+	}
+
+	c.logger().Println("Creating Service connections in", region)
 
 	if c.session == nil {
 		c.setSession(region)
 	}
 
+	// the throttled API calls are retried with exponential backoff
+	cfg := aws.NewConfig()
+	if c.maxRetries > 0 {
+		cfg = cfg.WithMaxRetries(c.maxRetries)
+	}
+	c.session = c.session.Copy(cfg)
+	c.session.Handlers.Retry.PushBackNamed(throttlingLogger)
+
+	if c.limiter != nil {
+		c.session.Handlers.Sign.PushFrontNamed(c.limiter.handler())
+	}
+
 	asConn := make(chan *autoscaling.AutoScaling)
 	ec2Conn := make(chan *ec2.EC2)
 
@@ -40,5 +120,5 @@ func (c *connections) connect(region string) {
 
 	c.autoScaling, c.ec2, c.region = <-asConn, <-ec2Conn, region
 
-	logger.Println("Created service connections in", region)
+	c.logger().Println("Created service connections in", region)
 }
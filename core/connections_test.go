@@ -1,6 +1,7 @@
 package autospotting
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -27,15 +28,64 @@ func Test_connections_connect(t *testing.T) {
 			region: "foo",
 			match:  true,
 		},
+		{
+			name: "keep the clients already connected",
+			fields: fields{
+				autoScaling: &mockASG{},
+				ec2:         &mockEC2{},
+				region:      "foo",
+			},
+			region: "foo",
+			match:  true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := &connections{}
+			c := &connections{
+				session:     tt.fields.session,
+				autoScaling: tt.fields.autoScaling,
+				ec2:         tt.fields.ec2,
+				region:      tt.fields.region,
+			}
 			c.connect(tt.region)
 			if (c.region == tt.region) != tt.match {
 				t.Errorf("connections.connect() c.region = %v, expected %v",
 					c.region, tt.region)
 			}
+			if tt.fields.ec2 != nil && c.ec2 != tt.fields.ec2 {
+				t.Errorf("connections.connect() replaced the EC2 client")
+			}
 		})
 	}
 }
+
+func TestSessionCache(t *testing.T) {
+	c := newSessionCache()
+	created := 0
+
+	create := func() (*session.Session, error) {
+		created++
+		return session.NewSession()
+	}
+
+	first, err := c.get("123456789012/us-east-1", create)
+	if err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+
+	second, _ := c.get("123456789012/us-east-1", create)
+	if first != second || created != 1 {
+		t.Errorf("get() didn't reuse the cached session")
+	}
+
+	if other, _ := c.get("123456789012/eu-west-1", create); other == first || created != 2 {
+		t.Errorf("get() reused the session of another key")
+	}
+
+	failing := func() (*session.Session, error) {
+		return nil, errors.New("no credentials")
+	}
+	if _, err := c.get("210987654321/us-east-1", failing); err == nil {
+		t.Errorf("get() should fail when the session can't be created")
+	}
+}
@@ -0,0 +1,187 @@
+package autospotting
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// GroupCoverage summarizes the spot coverage of an enabled AutoScaling group.
+type GroupCoverage struct {
+	Account          string `json:"account,omitempty"`
+	Region           string `json:"region"`
+	Name             string `json:"name"`
+	DesiredCapacity  int64  `json:"desired_capacity"`
+	OnDemand         int64  `json:"on_demand"`
+	Spot             int64  `json:"spot"`
+	MinOnDemand      int64  `json:"min_on_demand"`
+	OpenSpotRequests int    `json:"open_spot_requests"`
+
+	// estimated hourly cost of the running instances, and how much it would
+	// cost if they were all on-demand instances
+	HourlyCost         float64 `json:"hourly_cost"`
+	HourlyOnDemandCost float64 `json:"hourly_on_demand_cost"`
+	HourlySavings      float64 `json:"hourly_savings"`
+}
+
+// Coverage is the spot coverage of all the enabled groups.
+type Coverage []GroupCoverage
+
+// ScanCoverage summarizes the spot coverage of the groups matching the tag
+// filters in all the enabled regions, without making any changes.
+func ScanCoverage(cfg *Config) (Coverage, error) {
+
+	setupLogging(cfg)
+	prepareRun(cfg)
+
+	var lock sync.Mutex
+	coverage := Coverage{}
+
+	err := forEachRegion(context.Background(), cfg, nil, func(r *region) {
+		groups := r.coverage()
+
+		lock.Lock()
+		coverage = append(coverage, groups...)
+		lock.Unlock()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(coverage, func(i, j int) bool {
+		a, b := coverage[i], coverage[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Name < b.Name
+	})
+
+	return coverage, nil
+}
+
+// coverage summarizes the spot coverage of the enabled groups of the region.
+func (r *region) coverage() []GroupCoverage {
+	if !r.scan() {
+		return nil
+	}
+
+	var groups []GroupCoverage
+	for n := range r.enabledASGs {
+		a := &r.enabledASGs[n]
+		a.loadState()
+		groups = append(groups, a.coverage())
+	}
+	return groups
+}
+
+func (a *autoScalingGroup) coverage() GroupCoverage {
+	c := GroupCoverage{
+		Account:         a.region.services.account,
+		Region:          a.region.name,
+		Name:            a.name,
+		DesiredCapacity: aws.Int64Value(a.DesiredCapacity),
+		MinOnDemand:     a.minOnDemand,
+	}
+
+	for inst := range a.instances.instances() {
+		if aws.StringValue(inst.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+
+		if inst.isSpot() {
+			c.Spot++
+		} else {
+			c.OnDemand++
+		}
+		c.HourlyCost += inst.price
+		c.HourlyOnDemandCost += inst.typeInfo.pricing.onDemand
+	}
+	c.HourlySavings = c.HourlyOnDemandCost - c.HourlyCost
+
+	for _, req := range a.spotInstanceRequests {
+		if aws.StringValue(req.State) == ec2.SpotInstanceStateOpen {
+			c.OpenSpotRequests++
+		}
+	}
+	return c
+}
+
+var coverageHeader = []string{"ACCOUNT", "REGION", "ASG", "DESIRED", "ON-DEMAND",
+	"SPOT", "MIN ON-DEMAND", "OPEN REQUESTS", "HOURLY COST", "ON-DEMAND COST",
+	"SAVINGS"}
+
+func (c GroupCoverage) fields() []string {
+	return []string{c.Account, c.Region, c.Name,
+		strconv.FormatInt(c.DesiredCapacity, 10),
+		strconv.FormatInt(c.OnDemand, 10),
+		strconv.FormatInt(c.Spot, 10),
+		strconv.FormatInt(c.MinOnDemand, 10),
+		strconv.Itoa(c.OpenSpotRequests),
+		fmt.Sprintf("%.4f", c.HourlyCost),
+		fmt.Sprintf("%.4f", c.HourlyOnDemandCost),
+		fmt.Sprintf("%.4f", c.HourlySavings),
+	}
+}
+
+// WriteTable writes the coverage as an aligned text table, followed by the
+// totals.
+func (c Coverage) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	var total GroupCoverage
+	total.Name = "TOTAL"
+
+	writeRow := func(fields []string) {
+		for n, f := range fields {
+			if f == "" {
+				f = "-"
+			}
+			if n > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, f)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	writeRow(coverageHeader)
+	for _, g := range c {
+		writeRow(g.fields())
+
+		total.DesiredCapacity += g.DesiredCapacity
+		total.OnDemand += g.OnDemand
+		total.Spot += g.Spot
+		total.MinOnDemand += g.MinOnDemand
+		total.OpenSpotRequests += g.OpenSpotRequests
+		total.HourlyCost += g.HourlyCost
+		total.HourlyOnDemandCost += g.HourlyOnDemandCost
+		total.HourlySavings += g.HourlySavings
+	}
+	writeRow(total.fields())
+
+	return tw.Flush()
+}
+
+// WriteCSV writes the coverage in the CSV format, with a header.
+func (c Coverage) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write(coverageHeader)
+	for _, g := range c {
+		cw.Write(g.fields())
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,99 @@
+package autospotting
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestGroupCoverage(t *testing.T) {
+	running := &ec2.InstanceState{Name: aws.String("running")}
+	m5 := instanceTypeInformation{
+		instanceType: "m5.large",
+		pricing:      prices{onDemand: 0.1},
+	}
+
+	a := &autoScalingGroup{
+		Group:       &autoscaling.Group{DesiredCapacity: aws.Int64(3)},
+		name:        "asg",
+		region:      &region{name: "eu-west-1"},
+		minOnDemand: 1,
+		instances: makeInstancesWithCatalog(map[string]*instance{
+			"i-1": {
+				Instance: &ec2.Instance{State: running},
+				typeInfo: m5,
+				price:    0.1,
+			},
+			"i-2": {
+				Instance: &ec2.Instance{State: running, InstanceLifecycle: aws.String("spot")},
+				typeInfo: m5,
+				price:    0.03,
+			},
+			"i-3": {
+				Instance: &ec2.Instance{State: &ec2.InstanceState{Name: aws.String("terminated")}},
+				typeInfo: m5,
+				price:    0.1,
+			},
+		}),
+		spotInstanceRequests: []*spotInstanceRequest{
+			{SpotInstanceRequest: &ec2.SpotInstanceRequest{State: aws.String("open")}},
+			{SpotInstanceRequest: &ec2.SpotInstanceRequest{State: aws.String("active")}},
+		},
+	}
+
+	got := a.coverage()
+
+	if got.Region != "eu-west-1" || got.Name != "asg" || got.DesiredCapacity != 3 ||
+		got.OnDemand != 1 || got.Spot != 1 || got.MinOnDemand != 1 ||
+		got.OpenSpotRequests != 1 {
+		t.Errorf("coverage() = %+v", got)
+	}
+
+	if math.Abs(got.HourlyCost-0.13) > 0.000001 ||
+		math.Abs(got.HourlyOnDemandCost-0.2) > 0.000001 ||
+		math.Abs(got.HourlySavings-0.07) > 0.000001 {
+		t.Errorf("coverage() costs = %v / %v / %v, want 0.13 / 0.2 / 0.07",
+			got.HourlyCost, got.HourlyOnDemandCost, got.HourlySavings)
+	}
+}
+
+func TestCoverageOutput(t *testing.T) {
+	c := Coverage{
+		{Region: "eu-west-1", Name: "a", DesiredCapacity: 2, OnDemand: 1, Spot: 1,
+			HourlyCost: 0.13, HourlyOnDemandCost: 0.2, HourlySavings: 0.07},
+		{Account: "123456789012", Region: "us-east-1", Name: "b", DesiredCapacity: 1,
+			Spot: 1, HourlyCost: 0.03, HourlyOnDemandCost: 0.1, HourlySavings: 0.07},
+	}
+
+	var table bytes.Buffer
+	if err := c.WriteTable(&table); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("WriteTable() = %s", table.String())
+	}
+	if !strings.HasPrefix(lines[1], "-             eu-west-1  a") ||
+		!strings.Contains(lines[3], "TOTAL") || !strings.HasSuffix(lines[3], "0.1400") {
+		t.Errorf("WriteTable() = %s", table.String())
+	}
+
+	var csv bytes.Buffer
+	if err := c.WriteCSV(&csv); err != nil {
+		t.Fatalf("WriteCSV() error = %v", err)
+	}
+
+	want := "ACCOUNT,REGION,ASG,DESIRED,ON-DEMAND,SPOT,MIN ON-DEMAND,OPEN REQUESTS," +
+		"HOURLY COST,ON-DEMAND COST,SAVINGS\n" +
+		",eu-west-1,a,2,1,1,0,0,0.1300,0.2000,0.0700\n" +
+		"123456789012,us-east-1,b,1,0,1,0,0,0.0300,0.1000,0.0700\n"
+	if csv.String() != want {
+		t.Errorf("WriteCSV() = %s, want %s", csv.String(), want)
+	}
+}
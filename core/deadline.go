@@ -0,0 +1,54 @@
+package autospotting
+
+import (
+	"context"
+	"time"
+)
+
+// The time expected to be needed by the steps which shouldn't be interrupted
+// by the Lambda function timeout. New steps are only started when enough time
+// is left until the deadline, otherwise they are deferred to the next run.
+const (
+	// requesting and tagging a new spot instance request
+	spotRequestDuration = 30 * time.Second
+
+	// tagging a new spot instance, done after waiting for it to start
+	spotTaggingDuration = 30 * time.Second
+
+	// attaching the spot instance, then detaching and terminating the
+	// on-demand instance while the group's MaxSize may be temporarily raised
+	replacementDuration = 60 * time.Second
+)
+
+// context returns the context of the current run, which may carry the
+// deadline of the Lambda function invocation.
+func (r *region) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// hasTimeFor tells if the current run was neither cancelled nor will reach its
+// deadline within the given duration.
+func (r *region) hasTimeFor(d time.Duration) bool {
+	ctx := r.context()
+
+	if ctx.Err() != nil {
+		return false
+	}
+
+	deadline, ok := ctx.Deadline()
+	return !ok || deadline.Sub(r.conf.clock().Now()) > d
+}
+
+// waitContext returns a context for waiting on long running operations, which
+// expires early enough to leave the given time for the subsequent steps.
+func (r *region) waitContext(reserved time.Duration) (context.Context, context.CancelFunc) {
+	ctx := r.context()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline.Add(-reserved))
+	}
+	return context.WithCancel(ctx)
+}
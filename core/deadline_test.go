@@ -0,0 +1,91 @@
+package autospotting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestHasTimeFor(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	soon, cancelSoon := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelSoon()
+
+	later, cancelLater := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelLater()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want bool
+	}{
+		{name: "no context", ctx: nil, want: true},
+		{name: "no deadline", ctx: context.Background(), want: true},
+		{name: "cancelled", ctx: cancelled, want: false},
+		{name: "deadline too close", ctx: soon, want: false},
+		{name: "deadline far enough", ctx: later, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{ctx: tt.ctx}
+			if got := r.hasTimeFor(replacementDuration); got != tt.want {
+				t.Errorf("hasTimeFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	r := &region{ctx: ctx}
+	wait, cancelWait := r.waitContext(spotTaggingDuration)
+	defer cancelWait()
+
+	deadline, _ := ctx.Deadline()
+	waitDeadline, ok := wait.Deadline()
+	if !ok || !waitDeadline.Equal(deadline.Add(-spotTaggingDuration)) {
+		t.Errorf("waitContext() deadline = %v, want %v", waitDeadline,
+			deadline.Add(-spotTaggingDuration))
+	}
+
+	// the wait is interrupted when the deadline is too close for tagging
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	sr := spotInstanceRequest{
+		SpotInstanceRequest: &ec2.SpotInstanceRequest{
+			SpotInstanceRequestId: aws.String("sir-1"),
+		},
+		region: &region{ctx: ctx, services: connections{ec2: mockEC2{}}},
+		asg:    &autoScalingGroup{name: "test"},
+	}
+
+	if err := sr.waitForAndTagSpotInstance(); err == nil {
+		t.Errorf("waitForAndTagSpotInstance() didn't stop before the deadline")
+	}
+}
+
+func TestProcessEnabledAutoScalingGroupsAfterDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the groups would fail to be processed for lack of data, but they should
+	// be skipped anyway
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{groupPool: newWorkerPool(1)},
+		ctx:  ctx,
+		enabledASGs: []autoScalingGroup{
+			{name: "test", Group: &autoscaling.Group{}},
+		},
+	}
+	r.processEnabledAutoScalingGroups()
+}
@@ -0,0 +1,247 @@
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Engine replaces the on-demand instances of the AutoScaling groups of a
+// region with spot instances, using the AWS clients it was given, so it can
+// be embedded in other programs. The runs also process each region using an
+// engine. The instance data, the logger and the clock are taken from its
+// configuration, each engine logging through its own logger.
+type Engine struct {
+	cfg    *Config
+	region string
+
+	// the account of the region when processing multiple accounts
+	account string
+
+	// the loggers of the engine, the ones of the process being used if nil
+	logs *loggers
+
+	// the result of the run processing the region, if any
+	report *runReport
+
+	ec2         ec2iface.EC2API
+	autoScaling autoscalingiface.AutoScalingAPI
+}
+
+// GroupEvaluation is the state of a group and how its on-demand instances
+// would be replaced, computed without making any changes.
+type GroupEvaluation struct {
+	Coverage GroupCoverage `json:"coverage"`
+
+	// missing when the group has no running instances
+	Explanation *Explanation `json:"explanation,omitempty"`
+}
+
+// InstanceTypePrices are the hourly prices of an instance type in a region.
+type InstanceTypePrices struct {
+	InstanceType string `json:"instance_type"`
+
+	// including the configured on-demand price multipliers
+	OnDemand float64 `json:"on_demand"`
+
+	// added to the spot price of the EBS optimized instances
+	EBSSurcharge float64 `json:"ebs_surcharge"`
+
+	// current spot prices in each availability zone
+	Spot map[string]float64 `json:"spot"`
+}
+
+// NewEngine creates an engine processing the groups of a region. The
+// configuration must contain the instance data, its other settings taking
+// their default values when unset. The configuration is copied, so changing
+// it later has no effect on the engine. The engine logs to the configured
+// Logger, or else to the LogFile, without changing the logger of the process.
+func NewEngine(cfg *Config, region string, ec2Client ec2iface.EC2API,
+	autoScalingClient autoscalingiface.AutoScalingAPI) (*Engine, error) {
+
+	switch {
+	case cfg == nil || cfg.InstanceData == nil:
+		return nil, errors.New("the instance data is missing")
+	case region == "":
+		return nil, errors.New("the region is missing")
+	case ec2Client == nil || autoScalingClient == nil:
+		return nil, errors.New("the AWS clients are missing")
+	}
+
+	c := *cfg
+
+	if c.OnDemandPriceMultiplier == 0 {
+		c.OnDemandPriceMultiplier = 1
+	}
+	if c.SpotPriceBufferPercentage == 0 {
+		c.SpotPriceBufferPercentage = DefaultSpotPriceBufferPercentage
+	}
+	if c.BiddingPolicy == "" {
+		c.BiddingPolicy = DefaultBiddingPolicy
+	}
+	if c.SpotProductDescription == "" {
+		c.SpotProductDescription = DefaultSpotProductDescription
+	}
+	if c.SleepMultiplier == 0 {
+		c.SleepMultiplier = 1
+	}
+
+	c.logs = newLoggers(&c)
+	prepareRun(&c)
+
+	return &Engine{
+		cfg:         &c,
+		region:      region,
+		logs:        c.logs,
+		ec2:         ec2Client,
+		autoScaling: autoScalingClient,
+	}, nil
+}
+
+// newRegion sets up the processing of the region for a single call, using
+// the engine's clients.
+func (e *Engine) newRegion(ctx context.Context) *region {
+	r := &region{name: e.region, conf: e.cfg, ctx: ctx,
+		priceProvider: e.cfg.priceProvider}
+
+	r.services.ec2 = e.ec2
+	r.services.autoScaling = e.autoScaling
+	r.services.region = e.region
+	r.services.account = e.account
+	r.services.logs = e.logs
+	r.report = e.report
+	return r
+}
+
+// ProcessRegion runs a single replacement step on all the groups of the
+// region matching the configured tag filters, like each run does for every
+// region. The outcome of each group is reported in the status.
+func (e *Engine) ProcessRegion(ctx context.Context) {
+	e.newRegion(ctx).processRegion()
+}
+
+// ProcessGroup runs a single replacement step on a group, like each run does
+// for the enabled groups, regardless of its tags. The returned error is the
+// one reported in the status, if any.
+func (e *Engine) ProcessGroup(ctx context.Context, name string) (GroupStatus, error) {
+	r := e.newRegion(ctx)
+
+	asg, err := r.scanGroup(name)
+	if err != nil {
+		return GroupStatus{}, err
+	}
+
+	if e.cfg.ReservedInstancesAware {
+		if err := r.scanReservedInstances(); err != nil {
+			r.logger().Printf("Failed to scan reserved instances in %s error: %s\n", r.name, err)
+		}
+	}
+
+	status := asg.process()
+	if status.Error != "" {
+		return status, errors.New(status.Error)
+	}
+	return status, nil
+}
+
+// EvaluateGroup computes the spot coverage of a group and how its on-demand
+// instances would be replaced, without making any changes.
+func (e *Engine) EvaluateGroup(ctx context.Context, name string) (*GroupEvaluation, error) {
+	r := e.newRegion(ctx)
+
+	asg, err := r.scanGroup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	asg.loadState()
+
+	evaluation := &GroupEvaluation{Coverage: asg.coverage()}
+
+	// the group may have no running instances to be replaced
+	if explanation, err := asg.explain(""); err == nil {
+		evaluation.Explanation = explanation
+	}
+	return evaluation, nil
+}
+
+// CompatibleInstanceTypes returns the spot instance types which can replace
+// an on-demand instance of the given type in an availability zone, ranked by
+// price, using the configured allowed and disallowed instance types.
+func (e *Engine) CompatibleInstanceTypes(ctx context.Context, instanceType,
+	availabilityZone string) ([]CandidateExplanation, error) {
+
+	r := e.newRegion(ctx)
+	r.determineInstanceTypeInformation(e.cfg)
+
+	info, ok := r.instanceTypeInformation[instanceType]
+	if !ok {
+		return nil, fmt.Errorf("unknown instance type %s in %s", instanceType, e.region)
+	}
+
+	asg := &autoScalingGroup{
+		Group: &autoscaling.Group{
+			AvailabilityZones: []*string{aws.String(availabilityZone)},
+		},
+		region:                 r,
+		spotProductDescription: e.cfg.SpotProductDescription,
+	}
+
+	// the instance is assumed to run an HVM image whenever possible, and to
+	// be EBS optimized when supported
+	virtualization := "paravirtual"
+	for _, v := range info.virtualizationTypes {
+		if v == "HVM" {
+			virtualization = "hvm"
+		}
+	}
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceType:       aws.String(instanceType),
+			Placement:          &ec2.Placement{AvailabilityZone: aws.String(availabilityZone)},
+			VirtualizationType: aws.String(virtualization),
+			EbsOptimized:       aws.Bool(info.hasEBSOptimization),
+		},
+		typeInfo: info,
+		price:    info.pricing.onDemand,
+		region:   r,
+		asg:      asg,
+	}
+
+	var compatible []CandidateExplanation
+	for _, c := range i.explain(asg, "").Candidates {
+		if c.RejectedBy == "" {
+			compatible = append(compatible, c)
+		}
+	}
+	return compatible, nil
+}
+
+// Prices returns the current prices of an instance type in the region.
+func (e *Engine) Prices(ctx context.Context, instanceType string) (*InstanceTypePrices, error) {
+	r := e.newRegion(ctx)
+	r.determineInstanceTypeInformation(e.cfg)
+
+	info, ok := r.instanceTypeInformation[instanceType]
+	if !ok {
+		return nil, fmt.Errorf("unknown instance type %s in %s", instanceType, e.region)
+	}
+
+	p := &InstanceTypePrices{
+		InstanceType: instanceType,
+		OnDemand:     info.pricing.onDemand,
+		EBSSurcharge: info.pricing.ebsSurcharge,
+		Spot:         make(map[string]float64),
+	}
+	for az, price := range info.pricing.spot {
+		p.Spot[az] = price
+	}
+	return p, nil
+}
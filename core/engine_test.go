@@ -0,0 +1,223 @@
+package autospotting
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestEngine(t *testing.T, f *fakeAWS) *Engine {
+	data, err := parseInstanceData([]byte(scenarioInstanceData))
+	if err != nil {
+		t.Fatalf("parseInstanceData() error = %v", err)
+	}
+
+	conn := f.connections()
+
+	e, err := NewEngine(&Config{
+		InstanceData: data,
+		Logger:       log.New(ioutil.Discard, "", 0),
+
+		// waits without sleeping, later than the launch of the instances
+		Clock: &replayClock{now: time.Now().Add(time.Hour)},
+	}, f.region, conn.ec2, conn.autoScaling)
+	if err != nil {
+		t.Fatalf("NewEngine() error = %v", err)
+	}
+	return e
+}
+
+func TestNewEngine(t *testing.T) {
+	f := newFakeAWS("eu-west-1")
+	conn := f.connections()
+
+	if _, err := NewEngine(&Config{}, "eu-west-1", conn.ec2, conn.autoScaling); err == nil {
+		t.Errorf("NewEngine() without instance data succeeded")
+	}
+
+	e := newTestEngine(t, f)
+	if e.cfg.OnDemandPriceMultiplier != 1 || e.cfg.BiddingPolicy != DefaultBiddingPolicy ||
+		e.cfg.SpotProductDescription != DefaultSpotProductDescription ||
+		e.cfg.SpotPriceBufferPercentage != DefaultSpotPriceBufferPercentage {
+		t.Errorf("NewEngine() configuration = %+v, want the defaults", e.cfg)
+	}
+}
+
+func TestEngine(t *testing.T) {
+	ctx := context.Background()
+
+	f := newFakeAWS("eu-west-1")
+	for _, az := range []string{"eu-west-1a", "eu-west-1b"} {
+		f.setSpotPrice("m4.large", az, 0.03)
+		f.setSpotPrice("m5.large", az, 0.04)
+		f.setSpotPrice("m5.xlarge", az, 0.2)
+	}
+	f.setSpotPrice("m4.large", "eu-west-1b", 0.05)
+
+	// not matching the default tag filters
+	f.addGroup("web", "m5.large", 1, 1, 2, []string{"eu-west-1a"}, nil)
+
+	e := newTestEngine(t, f)
+
+	prices, err := e.Prices(ctx, "m4.large")
+	if err != nil {
+		t.Fatalf("Prices() error = %v", err)
+	}
+	want := &InstanceTypePrices{InstanceType: "m4.large", OnDemand: 0.111,
+		Spot: map[string]float64{"eu-west-1a": 0.03, "eu-west-1b": 0.05}}
+	if !reflect.DeepEqual(prices, want) {
+		t.Errorf("Prices() = %+v, want %+v", prices, want)
+	}
+
+	if _, err := e.Prices(ctx, "x1.32xlarge"); err == nil {
+		t.Errorf("Prices() of an unknown instance type succeeded")
+	}
+
+	compatible, err := e.CompatibleInstanceTypes(ctx, "m5.large", "eu-west-1b")
+	if err != nil {
+		t.Fatalf("CompatibleInstanceTypes() error = %v", err)
+	}
+	var types []string
+	for _, c := range compatible {
+		types = append(types, c.InstanceType)
+	}
+	if !reflect.DeepEqual(types, []string{"m5.large", "m4.large"}) {
+		t.Errorf("CompatibleInstanceTypes() = %v", types)
+	}
+
+	evaluation, err := e.EvaluateGroup(ctx, "web")
+	if err != nil {
+		t.Fatalf("EvaluateGroup() error = %v", err)
+	}
+	if evaluation.Coverage.OnDemand != 1 || evaluation.Explanation == nil ||
+		evaluation.Explanation.Chosen != "m4.large" {
+		t.Errorf("EvaluateGroup() = %+v", evaluation)
+	}
+
+	if _, err := e.EvaluateGroup(ctx, "missing"); err == nil {
+		t.Errorf("EvaluateGroup() of a missing group succeeded")
+	}
+
+	// launching the spot instance, then replacing the on-demand one
+	actions := []string{"launching spot instance", "replacing on-demand instance"}
+	for _, action := range actions {
+		status, err := e.ProcessGroup(ctx, "web")
+		if err != nil || status.Action != action {
+			t.Errorf("ProcessGroup() = %+v, %v, want the action %q", status, err, action)
+		}
+	}
+
+	onDemand, spot := f.groupInstances("web")
+	if len(onDemand) != 0 || len(spot) != 1 || *spot[0].InstanceType != "m4.large" {
+		t.Errorf("the group has the on-demand instances %v and the spot ones %v",
+			onDemand, spot)
+	}
+}
+
+func TestEngineProcessRegion(t *testing.T) {
+	f := newFakeAWS("eu-west-1")
+	for _, az := range []string{"eu-west-1a", "eu-west-1b"} {
+		f.setSpotPrice("m4.large", az, 0.03)
+		f.setSpotPrice("m5.large", az, 0.04)
+	}
+
+	f.addGroup("web", "m5.large", 1, 1, 2, []string{"eu-west-1a"},
+		map[string]string{"spot-enabled": "true"})
+	f.addGroup("db", "m5.large", 1, 1, 2, []string{"eu-west-1a"}, nil)
+
+	e := newTestEngine(t, f)
+
+	// launching the spot instance, then replacing the on-demand one
+	for n := 0; n < 2; n++ {
+		e.ProcessRegion(context.Background())
+	}
+
+	if onDemand, spot := f.groupInstances("web"); len(onDemand) != 0 || len(spot) != 1 {
+		t.Errorf("the enabled group has %d on-demand and %d spot instances, want 0 and 1",
+			len(onDemand), len(spot))
+	}
+	if onDemand, spot := f.groupInstances("db"); len(onDemand) != 1 || len(spot) != 0 {
+		t.Errorf("the disabled group has %d on-demand and %d spot instances, want 1 and 0",
+			len(onDemand), len(spot))
+	}
+}
+
+func TestEngineLoggers(t *testing.T) {
+	processLogger := logger
+
+	data, err := parseInstanceData([]byte(scenarioInstanceData))
+	if err != nil {
+		t.Fatalf("parseInstanceData() error = %v", err)
+	}
+
+	logs := make(map[string]*bytes.Buffer)
+	for _, region := range []string{"eu-west-1", "us-east-1"} {
+		f := newFakeAWS(region)
+		conn := f.connections()
+
+		logs[region] = &bytes.Buffer{}
+		e, err := NewEngine(&Config{
+			InstanceData: data,
+			Logger:       log.New(logs[region], "", 0),
+		}, region, conn.ec2, conn.autoScaling)
+		if err != nil {
+			t.Fatalf("NewEngine() error = %v", err)
+		}
+		e.ProcessRegion(context.Background())
+	}
+
+	if logger != processLogger {
+		t.Errorf("NewEngine() replaced the logger of the process")
+	}
+
+	for region, other := range map[string]string{
+		"eu-west-1": "us-east-1", "us-east-1": "eu-west-1"} {
+		if out := logs[region].String(); !strings.Contains(out, region) ||
+			strings.Contains(out, other) {
+			t.Errorf("the engine of %s logged %q", region, out)
+		}
+	}
+}
+
+func TestRegionReports(t *testing.T) {
+	var regions []*region
+	var cfg *Config
+
+	for _, name := range []string{"eu-west-1", "us-east-1"} {
+		f := newFakeAWS(name)
+		f.addGroup("web-"+name, "m5.large", 1, 1, 2, []string{name + "a"},
+			map[string]string{"spot-enabled": "true"})
+
+		// the runs of both regions share the same configuration
+		if cfg == nil {
+			cfg = newTestEngine(t, f).cfg
+		}
+		regions = append(regions, &region{name: name, conf: cfg,
+			services: f.connections(), report: newRunReport(time.Now())})
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range regions {
+		wg.Add(1)
+		go func(r *region) {
+			defer wg.Done()
+			r.engine().ProcessRegion(context.Background())
+		}(r)
+	}
+	wg.Wait()
+
+	for _, r := range regions {
+		result := r.report.result(time.Now())
+		if len(result.Regions) != 1 || result.Regions[0].Region != r.name ||
+			len(result.Regions[0].Groups) != 1 ||
+			result.Regions[0].Groups[0].Name != "web-"+r.name {
+			t.Errorf("the report of %s = %+v", r.name, result.Regions)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Explanation describes how the spot instance type used for replacing the
+// on-demand instances of a group is chosen.
+type Explanation struct {
+	Account string `json:"account,omitempty"`
+	Region  string `json:"region"`
+	Group   string `json:"asg"`
+
+	// the instance used as a template for the spot instances
+	BaseInstanceID    string   `json:"base_instance_id"`
+	BaseInstanceType  string   `json:"base_instance_type"`
+	AvailabilityZone  string   `json:"availability_zone"`
+	OnDemandPrice     float64  `json:"on_demand_price"`
+	SpotProduct       string   `json:"spot_product_description"`
+	AvailabilityZones []string `json:"availability_zones"`
+
+	// the strategy ranking the compatible candidates
+	SelectionStrategy string `json:"selection_strategy"`
+
+	// the chosen instance type, empty if none is compatible
+	Chosen string `json:"chosen,omitempty"`
+
+	// all the candidates, the compatible ones first, ranked by the selection
+	// strategy, then the rejected ones by price
+	Candidates []CandidateExplanation `json:"candidates"`
+}
+
+// CandidateExplanation is the result of evaluating a spot candidate.
+type CandidateExplanation struct {
+	// position among the compatible candidates, zero for the rejected ones
+	Rank         int     `json:"rank,omitempty"`
+	InstanceType string  `json:"instance_type"`
+	VCPU         int     `json:"vcpu"`
+	Memory       float32 `json:"memory"`
+
+	// the price compared with the on-demand price in the base instance's
+	// availability zone, including the EBS and CPU credits surcharges
+	Price float64 `json:"price"`
+
+	// spot prices in each availability zone of the group
+	SpotPrices map[string]float64 `json:"spot_prices"`
+
+	// the interruption frequency band, empty if unknown
+	InterruptionBand string `json:"interruption_band,omitempty"`
+
+	// the first failed compatibility check, empty if compatible
+	RejectedBy string `json:"rejected_by,omitempty"`
+}
+
+// Explain evaluates all the spot candidates for replacing an instance of a
+// group, without making any changes. The group doesn't need to be enabled.
+func Explain(cfg *Config, accountID, regionName, asgName string) (*Explanation, error) {
+
+	setupLogging(cfg)
+	prepareRun(cfg)
+
+	a, err := findAccount(cfg, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newRegion(context.Background(), cfg, a, regionName, cfg.priceProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	asg, err := r.scanGroup(asgName)
+	if err != nil {
+		return nil, err
+	}
+
+	asg.loadInstanceTypeInformation()
+	asg.scanInstances()
+
+	return asg.explain(a.id)
+}
+
+// explain evaluates all the spot candidates for replacing an on-demand
+// instance of the group, or a spot instance if there are none left.
+func (asg *autoScalingGroup) explain(accountID string) (*Explanation, error) {
+
+	base := asg.getInstance(nil, true, false)
+	if base == nil {
+		// explain how a running spot instance would be chosen today
+		base = asg.getInstance(nil, false, true)
+	}
+	if base == nil {
+		return nil, errors.New("the group has no running instances")
+	}
+
+	if base.isSpot() {
+		replaced := *base
+		replaced.price = base.typeInfo.pricing.onDemand
+		base = &replaced
+	}
+
+	if base.typeInfo.burstable {
+		if err := base.loadCPUCredits(); err != nil {
+			asg.region.logger().Println("Couldn't determine the CPU credits of",
+				*base.InstanceId, err.Error())
+		}
+	}
+
+	return base.explain(asg, accountID), nil
+}
+
+// explain evaluates all the spot candidates for replacing the instance.
+func (i *instance) explain(asg *autoScalingGroup, accountID string) *Explanation {
+
+	e := &Explanation{
+		Account:          accountID,
+		Region:           i.region.name,
+		Group:            asg.name,
+		BaseInstanceID:   aws.StringValue(i.InstanceId),
+		BaseInstanceType: aws.StringValue(i.InstanceType),
+		AvailabilityZone: aws.StringValue(i.Placement.AvailabilityZone),
+		OnDemandPrice:    i.price,
+		SpotProduct:      asg.spotProductDescription,
+
+		SelectionStrategy: asg.getSelectionStrategyName(),
+	}
+
+	for _, az := range asg.AvailabilityZones {
+		e.AvailabilityZones = append(e.AvailabilityZones, aws.StringValue(az))
+	}
+	sort.Strings(e.AvailabilityZones)
+
+	allowed := asg.getAllowedInstanceTypes(i)
+	disallowed := asg.getDisallowedInstanceTypes(i)
+	attachedVolumes := i.attachedVolumesNumber()
+	strategy := asg.getSelectionStrategy()
+
+	// the compatible candidates, for ranking them using the strategy
+	compatible := make(map[string]spotCandidate)
+
+	for _, candidate := range i.getInstanceTypeInformation() {
+		price := i.calculatePrice(candidate)
+
+		c := CandidateExplanation{
+			InstanceType: candidate.instanceType,
+			VCPU:         candidate.vCPU,
+			Memory:       candidate.memory,
+			Price:        price,
+			SpotPrices:   make(map[string]float64),
+			RejectedBy: i.incompatibility(candidate, price, attachedVolumes,
+				allowed, disallowed),
+		}
+
+		for _, az := range e.AvailabilityZones {
+			if p, ok := candidate.pricing.spot[az]; ok {
+				c.SpotPrices[az] = p
+			}
+		}
+
+		sc := i.newSpotCandidate(candidate, price)
+		if sc.hasBand {
+			c.InterruptionBand = i.region.conf.InterruptionData.label(sc.band)
+		}
+
+		if c.RejectedBy == "" {
+			compatible[c.InstanceType] = sc
+		}
+		e.Candidates = append(e.Candidates, c)
+	}
+
+	sort.Slice(e.Candidates, func(a, b int) bool {
+		ca, cb := e.Candidates[a], e.Candidates[b]
+		if (ca.RejectedBy == "") != (cb.RejectedBy == "") {
+			return ca.RejectedBy == ""
+		}
+		if ca.RejectedBy == "" {
+			return strategy.prefers(compatible[ca.InstanceType], compatible[cb.InstanceType])
+		}
+		if ca.Price != cb.Price {
+			return ca.Price < cb.Price
+		}
+		return ca.InstanceType < cb.InstanceType
+	})
+
+	for n := range e.Candidates {
+		if e.Candidates[n].RejectedBy == "" {
+			e.Candidates[n].Rank = n + 1
+		}
+	}
+
+	if len(e.Candidates) > 0 && e.Candidates[0].RejectedBy == "" {
+		e.Chosen = e.Candidates[0].InstanceType
+	}
+
+	return e
+}
+
+// WriteTable writes the explanation as an aligned text table.
+func (e *Explanation) WriteTable(w io.Writer) error {
+	fmt.Fprintf(w, "Group %s in %s, replacing %s (%s) in %s at the on-demand price %.4f\n",
+		e.Group, e.Region, e.BaseInstanceID, e.BaseInstanceType,
+		e.AvailabilityZone, e.OnDemandPrice)
+
+	if e.Chosen != "" {
+		fmt.Fprintf(w, "Chosen instance type: %s (%s strategy)\n\n", e.Chosen,
+			e.SelectionStrategy)
+	} else {
+		fmt.Fprintf(w, "No compatible instance type found\n\n")
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	header := []string{"RANK", "INSTANCE TYPE", "VCPU", "MEMORY", "PRICE", "INTERRUPTIONS"}
+	header = append(header, e.AvailabilityZones...)
+	header = append(header, "RESULT")
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, c := range e.Candidates {
+		rank, result := "-", "rejected by "+c.RejectedBy
+		if c.RejectedBy == "" {
+			rank, result = fmt.Sprint(c.Rank), "compatible"
+			if c.InstanceType == e.Chosen {
+				result = "chosen"
+			}
+		}
+
+		interruptions := c.InterruptionBand
+		if interruptions == "" {
+			interruptions = "-"
+		}
+
+		row := []string{rank, c.InstanceType, fmt.Sprint(c.VCPU),
+			fmt.Sprint(c.Memory), formatPrice(c.Price, c.Price != 0), interruptions}
+		for _, az := range e.AvailabilityZones {
+			p, ok := c.SpotPrices[az]
+			row = append(row, formatPrice(p, ok))
+		}
+		row = append(row, result)
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+func formatPrice(price float64, known bool) string {
+	if !known {
+		return "-"
+	}
+	return fmt.Sprintf("%.4f", price)
+}
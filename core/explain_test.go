@@ -0,0 +1,115 @@
+package autospotting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestInstanceExplain(t *testing.T) {
+	candidate := func(name string, vCPU int, spot map[string]float64) instanceTypeInformation {
+		return instanceTypeInformation{
+			instanceType:        name,
+			vCPU:                vCPU,
+			memory:              4,
+			virtualizationTypes: []string{"HVM"},
+			pricing:             prices{spot: spot},
+		}
+	}
+
+	r := &region{
+		name: "eu-west-1",
+		conf: &Config{
+			DisallowedInstanceTypes: "c4.*",
+			InterruptionData: &InterruptionData{
+				SpotAdvisor: map[string]map[string]map[string]InterruptionStats{
+					"eu-west-1": {"Linux": {"m4.large": {Range: 1}}},
+				},
+			},
+		},
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"m5.large":  candidate("m5.large", 2, map[string]float64{"eu-west-1a": 0.04, "eu-west-1b": 0.05}),
+			"m4.large":  candidate("m4.large", 2, map[string]float64{"eu-west-1a": 0.03}),
+			"c4.large":  candidate("c4.large", 2, map[string]float64{"eu-west-1a": 0.02}),
+			"t2.micro":  candidate("t2.micro", 1, map[string]float64{"eu-west-1a": 0.01}),
+			"m5.xlarge": candidate("m5.xlarge", 4, map[string]float64{"eu-west-1a": 0.2}),
+		},
+	}
+
+	asg := &autoScalingGroup{
+		name:   "asg",
+		region: r,
+		Group: &autoscaling.Group{
+			AvailabilityZones: []*string{aws.String("eu-west-1b"), aws.String("eu-west-1a")},
+		},
+		launchConfiguration: &launchConfiguration{
+			LaunchConfiguration: &autoscaling.LaunchConfiguration{},
+		},
+	}
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:         aws.String("i-1"),
+			InstanceType:       aws.String("m5.large"),
+			VirtualizationType: aws.String("hvm"),
+			Placement:          &ec2.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+		},
+		typeInfo: candidate("m5.large", 2, nil),
+		price:    0.1,
+		region:   r,
+		asg:      asg,
+	}
+
+	e := i.explain(asg, "")
+
+	if e.Chosen != "m4.large" {
+		t.Errorf("explain() chosen = %s, want m4.large", e.Chosen)
+	}
+
+	want := []struct {
+		instanceType string
+		rank         int
+		rejectedBy   string
+	}{
+		{"m4.large", 1, ""},
+		{"m5.large", 2, ""},
+		{"t2.micro", 0, "class"},
+		{"c4.large", 0, "allowed"},
+		{"m5.xlarge", 0, "price"},
+	}
+
+	if len(e.Candidates) != len(want) {
+		t.Fatalf("explain() candidates = %+v", e.Candidates)
+	}
+
+	for n, w := range want {
+		c := e.Candidates[n]
+		if c.InstanceType != w.instanceType || c.Rank != w.rank || c.RejectedBy != w.rejectedBy {
+			t.Errorf("explain() candidate %d = %+v, want %+v", n, c, w)
+		}
+	}
+
+	if got := e.AvailabilityZones; len(got) != 2 || got[0] != "eu-west-1a" {
+		t.Errorf("explain() availability zones = %v", got)
+	}
+
+	var out bytes.Buffer
+	if err := e.WriteTable(&out); err != nil {
+		t.Fatalf("WriteTable() error = %v", err)
+	}
+
+	for _, line := range []string{
+		"Chosen instance type: m4.large",
+		"RANK  INSTANCE TYPE  VCPU  MEMORY  PRICE   INTERRUPTIONS  eu-west-1a  eu-west-1b  RESULT",
+		"1     m4.large       2     4       0.0300  5-10%          0.0300      -           chosen",
+		"-     c4.large       2     4       0.0200  -              0.0200      -           rejected by allowed",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("WriteTable() is missing %q in:\n%s", line, out.String())
+		}
+	}
+}
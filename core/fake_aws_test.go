@@ -0,0 +1,720 @@
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// fakeAWS is a stateful in-memory fake of the EC2 and AutoScaling APIs of a
+// region, keeping track of the instances, groups, spot instance requests, tags
+// and spot prices, so that multi-step flows can be tested end to end. Unlike
+// the mocks, the effects of each call are visible to the subsequent calls.
+//
+// The spot instance requests are fulfilled immediately when the bid covers
+// the spot price and there is capacity, and the groups replace the terminated
+// instances with on-demand instances launched from their launch configuration.
+type fakeAWS struct {
+	sync.Mutex
+
+	region string
+	lastID int
+
+	instances            map[string]*ec2.Instance
+	spotRequests         map[string]*ec2.SpotInstanceRequest
+	groups               map[string]*autoscaling.Group
+	launchConfigurations map[string]*autoscaling.LaunchConfiguration
+
+	// spot prices by instance type and availability zone
+	spotPrices map[string]map[string]float64
+
+	// instance types without spot capacity, whose requests stay open
+	noSpotCapacity map[string]bool
+}
+
+func newFakeAWS(region string) *fakeAWS {
+	return &fakeAWS{
+		region:               region,
+		instances:            make(map[string]*ec2.Instance),
+		spotRequests:         make(map[string]*ec2.SpotInstanceRequest),
+		groups:               make(map[string]*autoscaling.Group),
+		launchConfigurations: make(map[string]*autoscaling.LaunchConfiguration),
+		spotPrices:           make(map[string]map[string]float64),
+		noSpotCapacity:       make(map[string]bool),
+	}
+}
+
+// connections returns the connections using the fake, which are kept by
+// connect since they're already set for the region.
+func (f *fakeAWS) connections() connections {
+	return connections{
+		ec2:         &fakeEC2{fakeAWS: f},
+		autoScaling: &fakeAutoScaling{fakeAWS: f},
+		region:      f.region,
+	}
+}
+
+// setSpotPrice sets the current spot price of an instance type.
+func (f *fakeAWS) setSpotPrice(instanceType, az string, price float64) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.spotPrices[instanceType] == nil {
+		f.spotPrices[instanceType] = make(map[string]float64)
+	}
+	f.spotPrices[instanceType][az] = price
+}
+
+// addGroup creates a group using a new launch configuration, and launches its
+// on-demand instances spread across the availability zones.
+func (f *fakeAWS) addGroup(name, instanceType string, min, desired, max int64,
+	azs []string, tags map[string]string) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	lcName := name + "-lc"
+	f.launchConfigurations[lcName] = &autoscaling.LaunchConfiguration{
+		LaunchConfigurationName: aws.String(lcName),
+		ImageId:                 aws.String("ami-12345678"),
+		InstanceType:            aws.String(instanceType),
+	}
+
+	g := &autoscaling.Group{
+		AutoScalingGroupName:    aws.String(name),
+		LaunchConfigurationName: aws.String(lcName),
+		MinSize:                 aws.Int64(min),
+		MaxSize:                 aws.Int64(max),
+		DesiredCapacity:         aws.Int64(0),
+		HealthCheckGracePeriod:  aws.Int64(0),
+		AvailabilityZones:       aws.StringSlice(azs),
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		g.Tags = append(g.Tags, &autoscaling.TagDescription{
+			Key:               aws.String(k),
+			Value:             aws.String(tags[k]),
+			PropagateAtLaunch: aws.Bool(true),
+			ResourceId:        aws.String(name),
+			ResourceType:      aws.String("auto-scaling-group"),
+		})
+	}
+	f.groups[name] = g
+
+	for n := int64(0); n < desired; n++ {
+		f.launchForGroup(g)
+	}
+}
+
+// setGroupTag sets a tag of a group, as done by its users between runs.
+func (f *fakeAWS) setGroupTag(name, key, value string) {
+	f.Lock()
+	defer f.Unlock()
+
+	g := f.groups[name]
+	for _, t := range g.Tags {
+		if *t.Key == key {
+			t.Value = aws.String(value)
+			return
+		}
+	}
+	g.Tags = append(g.Tags, &autoscaling.TagDescription{
+		Key:               aws.String(key),
+		Value:             aws.String(value),
+		PropagateAtLaunch: aws.Bool(false),
+	})
+}
+
+// groupInstances returns the on-demand and spot instances of a group.
+func (f *fakeAWS) groupInstances(name string) (onDemand, spot []*ec2.Instance) {
+	f.Lock()
+	defer f.Unlock()
+
+	for _, gi := range f.groups[name].Instances {
+		inst := f.instances[*gi.InstanceId]
+		if aws.StringValue(inst.InstanceLifecycle) == "spot" {
+			spot = append(spot, inst)
+		} else {
+			onDemand = append(onDemand, inst)
+		}
+	}
+	return onDemand, spot
+}
+
+// runningInstances returns the IDs of the instances which weren't terminated.
+func (f *fakeAWS) runningInstances() []string {
+	f.Lock()
+	defer f.Unlock()
+
+	var ids []string
+	for id, inst := range f.instances {
+		if *inst.State.Name != ec2.InstanceStateNameTerminated {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// interrupt terminates a spot instance, as done when the spot capacity is
+// reclaimed.
+func (f *fakeAWS) interrupt(id string) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.terminate(id)
+}
+
+func (f *fakeAWS) newID(prefix string) string {
+	f.lastID++
+	return fmt.Sprintf("%s-%08d", prefix, f.lastID)
+}
+
+func (f *fakeAWS) launch(instanceType, az string, spot bool, tags []*ec2.Tag) *ec2.Instance {
+	inst := &ec2.Instance{
+		InstanceId:         aws.String(f.newID("i")),
+		ImageId:            aws.String("ami-12345678"),
+		InstanceType:       aws.String(instanceType),
+		LaunchTime:         aws.Time(time.Now()),
+		State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		Placement:          &ec2.Placement{AvailabilityZone: aws.String(az), Tenancy: aws.String(ec2.TenancyDefault)},
+		SubnetId:           aws.String("subnet-" + az),
+		VirtualizationType: aws.String(ec2.VirtualizationTypeHvm),
+		Tags:               tags,
+	}
+	if spot {
+		inst.InstanceLifecycle = aws.String("spot")
+	}
+	f.instances[*inst.InstanceId] = inst
+	return inst
+}
+
+// launchForGroup launches an on-demand instance in the least used
+// availability zone of the group, as done by AutoScaling.
+func (f *fakeAWS) launchForGroup(g *autoscaling.Group) {
+	lc := f.launchConfigurations[*g.LaunchConfigurationName]
+
+	used := make(map[string]int)
+	for _, gi := range g.Instances {
+		used[*gi.AvailabilityZone]++
+	}
+	az := *g.AvailabilityZones[0]
+	for _, candidate := range g.AvailabilityZones {
+		if used[*candidate] < used[az] {
+			az = *candidate
+		}
+	}
+
+	tags := []*ec2.Tag{{Key: aws.String("aws:autoscaling:groupName"), Value: g.AutoScalingGroupName}}
+	for _, t := range g.Tags {
+		if *t.PropagateAtLaunch {
+			tags = append(tags, &ec2.Tag{Key: t.Key, Value: t.Value})
+		}
+	}
+
+	inst := f.launch(*lc.InstanceType, az, false, tags)
+	f.addToGroup(g, inst)
+}
+
+func (f *fakeAWS) addToGroup(g *autoscaling.Group, inst *ec2.Instance) {
+	g.Instances = append(g.Instances, &autoscaling.Instance{
+		InstanceId:              inst.InstanceId,
+		AvailabilityZone:        inst.Placement.AvailabilityZone,
+		LaunchConfigurationName: g.LaunchConfigurationName,
+		LifecycleState:          aws.String(autoscaling.LifecycleStateInService),
+		HealthStatus:            aws.String("Healthy"),
+	})
+	g.DesiredCapacity = aws.Int64(*g.DesiredCapacity + 1)
+	setTag(&inst.Tags, "aws:autoscaling:groupName", *g.AutoScalingGroupName)
+}
+
+// removeFromGroup removes the instance from its group, if any, returning the
+// group.
+func (f *fakeAWS) removeFromGroup(id string) *autoscaling.Group {
+	for _, g := range f.groups {
+		for n, gi := range g.Instances {
+			if *gi.InstanceId == id {
+				g.Instances = append(g.Instances[:n], g.Instances[n+1:]...)
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+// terminate terminates an instance, which is replaced if it was still part
+// of a group.
+func (f *fakeAWS) terminate(id string) error {
+	inst, ok := f.instances[id]
+	if !ok {
+		return fmt.Errorf("InvalidInstanceID.NotFound: %s", id)
+	}
+	inst.State.Name = aws.String(ec2.InstanceStateNameTerminated)
+
+	if g := f.removeFromGroup(id); g != nil {
+		g.DesiredCapacity = aws.Int64(*g.DesiredCapacity - 1)
+		f.launchForGroup(g)
+	}
+	return nil
+}
+
+func setTag(tags *[]*ec2.Tag, key, value string) {
+	for _, t := range *tags {
+		if *t.Key == key {
+			t.Value = aws.String(value)
+			return
+		}
+	}
+	*tags = append(*tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+}
+
+func hasTag(tags []*ec2.Tag, key, value string) bool {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == key && aws.StringValue(t.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func copyTags(tags []*ec2.Tag) []*ec2.Tag {
+	var c []*ec2.Tag
+	for _, t := range tags {
+		c = append(c, &ec2.Tag{Key: t.Key, Value: t.Value})
+	}
+	return c
+}
+
+// fakeEC2 implements the EC2 API calls made while processing the groups.
+type fakeEC2 struct {
+	ec2iface.EC2API
+	*fakeAWS
+}
+
+func (f *fakeEC2) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	return &ec2.DescribeRegionsOutput{
+		Regions: []*ec2.Region{{RegionName: aws.String(f.region)}},
+	}, nil
+}
+
+func (f *fakeEC2) DescribeInstancesPages(in *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	f.Lock()
+
+	states := make(map[string]bool)
+	for _, filter := range in.Filters {
+		if *filter.Name == "instance-state-name" {
+			for _, v := range filter.Values {
+				states[*v] = true
+			}
+		}
+	}
+
+	ids := make(map[string]bool)
+	for _, id := range in.InstanceIds {
+		ids[*id] = true
+	}
+
+	res := &ec2.Reservation{}
+	for _, id := range sortedKeys(f.instances) {
+		inst := f.instances[id]
+		if len(states) > 0 && !states[*inst.State.Name] ||
+			len(ids) > 0 && !ids[id] {
+			continue
+		}
+		c := *inst
+		c.State = &ec2.InstanceState{Name: inst.State.Name}
+		c.Tags = copyTags(inst.Tags)
+		res.Instances = append(res.Instances, &c)
+	}
+	f.Unlock()
+
+	page := &ec2.DescribeInstancesOutput{}
+	if len(res.Instances) > 0 {
+		page.Reservations = []*ec2.Reservation{res}
+	}
+	fn(page, true)
+	return nil
+}
+
+func sortedKeys(instances map[string]*ec2.Instance) []string {
+	var keys []string
+	for k := range instances {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeEC2) DescribeSpotPriceHistoryPages(in *ec2.DescribeSpotPriceHistoryInput, fn func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool) error {
+	f.Lock()
+
+	wanted := make(map[string]bool)
+	for _, t := range in.InstanceTypes {
+		wanted[*t] = true
+	}
+
+	page := &ec2.DescribeSpotPriceHistoryOutput{}
+	for instanceType, azs := range f.spotPrices {
+		if len(wanted) > 0 && !wanted[instanceType] {
+			continue
+		}
+		for az, price := range azs {
+			page.SpotPriceHistory = append(page.SpotPriceHistory, &ec2.SpotPrice{
+				AvailabilityZone:   aws.String(az),
+				InstanceType:       aws.String(instanceType),
+				ProductDescription: in.ProductDescriptions[0],
+				SpotPrice:          aws.String(strconv.FormatFloat(price, 'f', -1, 64)),
+				Timestamp:          aws.Time(time.Now()),
+			})
+		}
+	}
+	f.Unlock()
+
+	fn(page, true)
+	return nil
+}
+
+func (f *fakeEC2) DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{}, nil
+}
+
+func (f *fakeEC2) DescribeReservedInstances(*ec2.DescribeReservedInstancesInput) (*ec2.DescribeReservedInstancesOutput, error) {
+	return &ec2.DescribeReservedInstancesOutput{}, nil
+}
+
+func (f *fakeEC2) DescribeSecurityGroups(in *ec2.DescribeSecurityGroupsInput) (*ec2.DescribeSecurityGroupsOutput, error) {
+	out := &ec2.DescribeSecurityGroupsOutput{}
+	for _, id := range in.GroupIds {
+		out.SecurityGroups = append(out.SecurityGroups, &ec2.SecurityGroup{GroupId: id})
+	}
+	return out, nil
+}
+
+func (f *fakeEC2) RequestSpotInstances(in *ec2.RequestSpotInstancesInput) (*ec2.RequestSpotInstancesOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	ls := in.LaunchSpecification
+	instanceType, az := *ls.InstanceType, *ls.Placement.AvailabilityZone
+
+	bid, err := strconv.ParseFloat(*in.SpotPrice, 64)
+	if err != nil {
+		return nil, fmt.Errorf("InvalidParameterValue: %s", *in.SpotPrice)
+	}
+
+	req := &ec2.SpotInstanceRequest{
+		SpotInstanceRequestId:    aws.String(f.newID("sir")),
+		SpotPrice:                in.SpotPrice,
+		LaunchSpecification:      &ec2.LaunchSpecification{InstanceType: ls.InstanceType},
+		LaunchedAvailabilityZone: aws.String(az),
+		State:                    aws.String(ec2.SpotInstanceStateOpen),
+	}
+
+	price, available := f.spotPrices[instanceType][az]
+	switch {
+	case !available || f.noSpotCapacity[instanceType]:
+		req.Status = &ec2.SpotInstanceStatus{Code: aws.String("capacity-not-available")}
+	case bid < price:
+		req.Status = &ec2.SpotInstanceStatus{Code: aws.String("price-too-low")}
+	default:
+		inst := f.launch(instanceType, az, true, nil)
+		inst.SpotInstanceRequestId = req.SpotInstanceRequestId
+		req.InstanceId = inst.InstanceId
+		req.State = aws.String(ec2.SpotInstanceStateActive)
+		req.Status = &ec2.SpotInstanceStatus{Code: aws.String("fulfilled")}
+	}
+	f.spotRequests[*req.SpotInstanceRequestId] = req
+
+	c := *req
+	return &ec2.RequestSpotInstancesOutput{SpotInstanceRequests: []*ec2.SpotInstanceRequest{&c}}, nil
+}
+
+func (f *fakeEC2) describeSpotRequests(in *ec2.DescribeSpotInstanceRequestsInput) []*ec2.SpotInstanceRequest {
+	ids := make(map[string]bool)
+	for _, id := range in.SpotInstanceRequestIds {
+		ids[*id] = true
+	}
+
+	var keys []string
+	for k := range f.spotRequests {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var out []*ec2.SpotInstanceRequest
+	for _, id := range keys {
+		req := f.spotRequests[id]
+		if len(ids) > 0 && !ids[id] {
+			continue
+		}
+
+		matches := true
+		for _, filter := range in.Filters {
+			if key := strings.TrimPrefix(*filter.Name, "tag:"); key != *filter.Name {
+				matches = matches && hasTag(req.Tags, key, aws.StringValue(filter.Values[0]))
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		c := *req
+		c.Tags = copyTags(req.Tags)
+		out = append(out, &c)
+	}
+	return out
+}
+
+func (f *fakeEC2) DescribeSpotInstanceRequests(in *ec2.DescribeSpotInstanceRequestsInput) (*ec2.DescribeSpotInstanceRequestsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	return &ec2.DescribeSpotInstanceRequestsOutput{
+		SpotInstanceRequests: f.describeSpotRequests(in),
+	}, nil
+}
+
+func (f *fakeEC2) WaitUntilSpotInstanceRequestFulfilledWithContext(ctx aws.Context, in *ec2.DescribeSpotInstanceRequestsInput, opts ...request.WaiterOption) error {
+	f.Lock()
+	defer f.Unlock()
+
+	for _, req := range f.describeSpotRequests(in) {
+		if *req.State != ec2.SpotInstanceStateActive {
+			return errors.New("ResourceNotReady: exceeded wait attempts")
+		}
+	}
+	return nil
+}
+
+func (f *fakeEC2) CancelSpotInstanceRequests(in *ec2.CancelSpotInstanceRequestsInput) (*ec2.CancelSpotInstanceRequestsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	for _, id := range in.SpotInstanceRequestIds {
+		if req, ok := f.spotRequests[*id]; ok {
+			req.State = aws.String(ec2.SpotInstanceStateCancelled)
+		}
+	}
+	return &ec2.CancelSpotInstanceRequestsOutput{}, nil
+}
+
+func (f *fakeEC2) CreateTags(in *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	for _, id := range in.Resources {
+		var tags *[]*ec2.Tag
+		if req, ok := f.spotRequests[*id]; ok {
+			tags = &req.Tags
+		} else if inst, ok := f.instances[*id]; ok {
+			tags = &inst.Tags
+		} else {
+			return nil, fmt.Errorf("InvalidID: %s", *id)
+		}
+
+		for _, t := range in.Tags {
+			setTag(tags, *t.Key, *t.Value)
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (f *fakeEC2) RunInstances(in *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	var tags []*ec2.Tag
+	for _, spec := range in.TagSpecifications {
+		tags = append(tags, copyTags(spec.Tags)...)
+	}
+
+	inst := f.launch(*in.InstanceType, *in.Placement.AvailabilityZone, false, tags)
+	c := *inst
+	return &ec2.Reservation{Instances: []*ec2.Instance{&c}}, nil
+}
+
+func (f *fakeEC2) WaitUntilInstanceRunningWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, opts ...request.WaiterOption) error {
+	return ctx.Err()
+}
+
+func (f *fakeEC2) TerminateInstances(in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	for _, id := range in.InstanceIds {
+		if err := f.terminate(*id); err != nil {
+			return nil, err
+		}
+	}
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+// fakeAutoScaling implements the AutoScaling API calls made while processing
+// the groups.
+type fakeAutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	*fakeAWS
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingGroupsPages(in *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+	f.Lock()
+
+	names := make(map[string]bool)
+	for _, n := range in.AutoScalingGroupNames {
+		names[*n] = true
+	}
+
+	var keys []string
+	for k := range f.groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	page := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	for _, name := range keys {
+		if len(names) > 0 && !names[name] {
+			continue
+		}
+		g := *f.groups[name]
+		g.Instances = append([]*autoscaling.Instance(nil), g.Instances...)
+		g.MinSize = aws.Int64(*g.MinSize)
+		g.MaxSize = aws.Int64(*g.MaxSize)
+		g.DesiredCapacity = aws.Int64(*g.DesiredCapacity)
+		page.AutoScalingGroups = append(page.AutoScalingGroups, &g)
+	}
+	f.Unlock()
+
+	fn(page, true)
+	return nil
+}
+
+func (f *fakeAutoScaling) DescribeLaunchConfigurations(in *autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	out := &autoscaling.DescribeLaunchConfigurationsOutput{}
+	for _, name := range in.LaunchConfigurationNames {
+		if lc, ok := f.launchConfigurations[*name]; ok {
+			out.LaunchConfigurations = append(out.LaunchConfigurations, lc)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeAutoScaling) AttachInstances(in *autoscaling.AttachInstancesInput) (*autoscaling.AttachInstancesOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	g, ok := f.groups[*in.AutoScalingGroupName]
+	if !ok {
+		return nil, fmt.Errorf("ValidationError: group %s not found", *in.AutoScalingGroupName)
+	}
+
+	if *g.DesiredCapacity+int64(len(in.InstanceIds)) > *g.MaxSize {
+		return nil, errors.New("ValidationError: the desired capacity would exceed the group's MaxSize")
+	}
+
+	for _, id := range in.InstanceIds {
+		inst, ok := f.instances[*id]
+		if !ok || *inst.State.Name != ec2.InstanceStateNameRunning {
+			return nil, fmt.Errorf("ValidationError: instance %s is not running", *id)
+		}
+		if hasTag(inst.Tags, "aws:autoscaling:groupName", *g.AutoScalingGroupName) {
+			return nil, fmt.Errorf("ValidationError: instance %s is already attached", *id)
+		}
+	}
+
+	for _, id := range in.InstanceIds {
+		f.addToGroup(g, f.instances[*id])
+	}
+	return &autoscaling.AttachInstancesOutput{}, nil
+}
+
+func (f *fakeAutoScaling) DetachInstances(in *autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	g, ok := f.groups[*in.AutoScalingGroupName]
+	if !ok {
+		return nil, fmt.Errorf("ValidationError: group %s not found", *in.AutoScalingGroupName)
+	}
+
+	decrement := aws.BoolValue(in.ShouldDecrementDesiredCapacity)
+	if decrement && *g.DesiredCapacity-int64(len(in.InstanceIds)) < *g.MinSize {
+		return nil, errors.New("ValidationError: the desired capacity would be below the group's MinSize")
+	}
+
+	for _, id := range in.InstanceIds {
+		if f.removeFromGroup(*id) != g {
+			return nil, fmt.Errorf("ValidationError: instance %s is not part of the group", *id)
+		}
+		inst := f.instances[*id]
+		for n, t := range inst.Tags {
+			if *t.Key == "aws:autoscaling:groupName" {
+				inst.Tags = append(inst.Tags[:n], inst.Tags[n+1:]...)
+				break
+			}
+		}
+
+		g.DesiredCapacity = aws.Int64(*g.DesiredCapacity - 1)
+		if !decrement {
+			f.launchForGroup(g)
+		}
+	}
+	return &autoscaling.DetachInstancesOutput{}, nil
+}
+
+func (f *fakeAutoScaling) UpdateAutoScalingGroup(in *autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	g, ok := f.groups[*in.AutoScalingGroupName]
+	if !ok {
+		return nil, fmt.Errorf("ValidationError: group %s not found", *in.AutoScalingGroupName)
+	}
+
+	min, max, desired := *g.MinSize, *g.MaxSize, *g.DesiredCapacity
+	if in.MinSize != nil {
+		min = *in.MinSize
+	}
+	if in.MaxSize != nil {
+		max = *in.MaxSize
+	}
+	if in.DesiredCapacity != nil {
+		desired = *in.DesiredCapacity
+	}
+
+	if min > desired || desired > max {
+		return nil, fmt.Errorf("ValidationError: the desired capacity %d must be "+
+			"between the MinSize %d and MaxSize %d", desired, min, max)
+	}
+
+	g.MinSize, g.MaxSize = aws.Int64(min), aws.Int64(max)
+	for *g.DesiredCapacity < desired {
+		f.launchForGroup(g)
+	}
+	for *g.DesiredCapacity > desired {
+		last := g.Instances[len(g.Instances)-1]
+		f.removeFromGroup(*last.InstanceId)
+		f.instances[*last.InstanceId].State.Name = aws.String(ec2.InstanceStateNameTerminated)
+		g.DesiredCapacity = aws.Int64(*g.DesiredCapacity - 1)
+	}
+	return &autoscaling.UpdateAutoScalingGroupOutput{}, nil
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/davecgh/go-spew/spew"
 )
@@ -52,7 +53,7 @@ func (is *instanceManager) add(inst *instance) {
 	if inst == nil {
 		return
 	}
-	debug.Println(inst)
+	inst.region.debugLogger().Println(inst)
 	is.Lock()
 	defer is.Unlock()
 	is.catalog[*inst.InstanceId] = inst
@@ -95,6 +96,10 @@ type instance struct {
 	price    float64
 	region   *region
 	asg      *autoScalingGroup
+
+	// CPU credit option of burstable instances, either "standard" or
+	// "unlimited", only populated when needed.
+	cpuCredits string
 }
 
 type instanceTypeInformation struct {
@@ -109,22 +114,74 @@ type instanceTypeInformation struct {
 	instanceStoreDeviceCount int
 	instanceStoreIsSSD       bool
 	hasEBSOptimization       bool
+
+	// the instance category as given by ec2instances.info, such as "General
+	// purpose" or "Compute optimized"
+	category string
+
+	// whether the instance type belongs to the current generation or is
+	// considered a previous generation type
+	currentGeneration bool
+
+	// burstable performance instance types, such as the T2 and T3 families,
+	// which accumulate and spend CPU credits
+	burstable bool
 }
 
 func (i *instance) calculatePrice(spotCandidate instanceTypeInformation) float64 {
 	spotPrice := spotCandidate.pricing.spot[*i.Placement.AvailabilityZone]
-	debug.Println("Comparing price spot/instance:")
+	i.region.debugLogger().Println("Comparing price spot/instance:")
 
 	if i.EbsOptimized != nil && *i.EbsOptimized {
 		spotPrice += spotCandidate.pricing.ebsSurcharge
-		debug.Println("\tEBS Surcharge : ", spotCandidate.pricing.ebsSurcharge)
+		i.region.debugLogger().Println("\tEBS Surcharge : ", spotCandidate.pricing.ebsSurcharge)
+	}
+
+	if surcharge := i.estimatedSurplusCreditsCost(spotCandidate); surcharge > 0 {
+		spotPrice += surcharge
+		i.region.debugLogger().Println("\tSurplus CPU credits estimate : ", surcharge)
 	}
 
-	debug.Println("\tSpot price: ", spotPrice)
-	debug.Println("\tInstance price: ", i.price)
+	i.region.debugLogger().Println("\tSpot price: ", spotPrice)
+	i.region.debugLogger().Println("\tInstance price: ", i.price)
 	return spotPrice
 }
 
+// estimatedSurplusCreditsCost estimates the hourly cost of the surplus CPU
+// credits spent by a burstable spot candidate running in unlimited mode, based
+// on the configured CPU utilization above the baseline and on the price of the
+// credits for the operating system of the instance's group.
+func (i *instance) estimatedSurplusCreditsCost(spotCandidate instanceTypeInformation) float64 {
+	if !spotCandidate.burstable || i.cpuCredits != "unlimited" ||
+		i.region == nil || i.region.conf == nil {
+		return 0
+	}
+
+	product := i.region.conf.SpotProductDescription
+	if i.asg != nil && i.asg.spotProductDescription != "" {
+		product = i.asg.spotProductDescription
+	}
+
+	return float64(spotCandidate.vCPU) * surplusCreditPricePerVCPUHour(pricingOS(product)) *
+		i.region.conf.BurstableSurplusCreditsPercentage / 100.0
+}
+
+// getInstanceTypeInformation returns the spot candidates priced for the
+// operating system of the instance's group.
+func (i *instance) getInstanceTypeInformation() map[string]instanceTypeInformation {
+	if i.asg != nil && i.asg.instanceTypeInformation != nil {
+		return i.asg.instanceTypeInformation
+	}
+	return i.region.instanceTypeInformation
+}
+
+// Spot instances can't be launched on dedicated hosts, so instances running
+// there can't be replaced.
+func (i *instance) hasSpotCompatibleTenancy() bool {
+	return i.Placement == nil || i.Placement.Tenancy == nil ||
+		*i.Placement.Tenancy != ec2.TenancyHost
+}
+
 func (i *instance) isSpot() bool {
 	return (i.InstanceLifecycle != nil &&
 		*i.InstanceLifecycle == "spot")
@@ -138,7 +195,7 @@ func (i *instance) terminate() error {
 		},
 	)
 	if err != nil {
-		logger.Printf("Issue while terminating %v: %v", *i.InstanceId, err.Error())
+		i.region.logger().Printf("Issue while terminating %v: %v", *i.InstanceId, err.Error())
 		return err
 	}
 	return nil
@@ -148,13 +205,23 @@ func (i *instance) isPriceCompatible(spotPrice float64, bestPrice float64) bool
 	return spotPrice != 0 && spotPrice <= i.price && spotPrice <= bestPrice
 }
 
+// Burstable instance types are only considered as replacements for burstable
+// instances, unless explicitly allowed for the group, since their sustained
+// performance is much lower than that of fixed-performance instances.
+func (i *instance) isBurstableCompatible(spotCandidate instanceTypeInformation) bool {
+	if !spotCandidate.burstable || i.typeInfo.burstable {
+		return true
+	}
+	return i.asg != nil && i.asg.allowsBurstableInstanceTypes()
+}
+
 func (i *instance) isClassCompatible(spotCandidate instanceTypeInformation) bool {
 	current := i.typeInfo
 
-	debug.Println("Comparing class spot/instance:")
-	debug.Println("\tSpot CPU/memory/GPU: ", spotCandidate.vCPU,
+	i.region.debugLogger().Println("Comparing class spot/instance:")
+	i.region.debugLogger().Println("\tSpot CPU/memory/GPU: ", spotCandidate.vCPU,
 		" / ", spotCandidate.memory, " / ", spotCandidate.GPU)
-	debug.Println("\tInstance CPU/memory/GPU: ", current.vCPU,
+	i.region.debugLogger().Println("\tInstance CPU/memory/GPU: ", current.vCPU,
 		" / ", current.memory, " / ", current.GPU)
 
 	return spotCandidate.vCPU >= current.vCPU &&
@@ -180,12 +247,12 @@ func (i *instance) isEBSCompatible(spotCandidate instanceTypeInformation) bool {
 func (i *instance) isStorageCompatible(spotCandidate instanceTypeInformation, attachedVolumes int) bool {
 	existing := i.typeInfo
 
-	debug.Println("Comparing storage spot/instance:")
-	debug.Println("\tSpot volumes/size/ssd: ",
+	i.region.debugLogger().Println("Comparing storage spot/instance:")
+	i.region.debugLogger().Println("\tSpot volumes/size/ssd: ",
 		spotCandidate.instanceStoreDeviceCount,
 		spotCandidate.instanceStoreDeviceSize,
 		spotCandidate.instanceStoreIsSSD)
-	debug.Println("\tInstance volumes/size/ssd: ",
+	i.region.debugLogger().Println("\tInstance volumes/size/ssd: ",
 		attachedVolumes,
 		existing.instanceStoreDeviceSize,
 		existing.instanceStoreIsSSD)
@@ -200,9 +267,9 @@ func (i *instance) isStorageCompatible(spotCandidate instanceTypeInformation, at
 func (i *instance) isVirtualizationCompatible(spotVirtualizationTypes []string) bool {
 	current := *i.VirtualizationType
 
-	debug.Println("Comparing virtualization spot/instance:")
-	debug.Println("\tSpot virtualization: ", spotVirtualizationTypes)
-	debug.Println("\tInstance virtualization: ", current)
+	i.region.debugLogger().Println("Comparing virtualization spot/instance:")
+	i.region.debugLogger().Println("\tSpot virtualization: ", spotVirtualizationTypes)
+	i.region.debugLogger().Println("\tInstance virtualization: ", current)
 
 	for _, avt := range spotVirtualizationTypes {
 		if (avt == "PV") && (current == "paravirtual") ||
@@ -213,72 +280,152 @@ func (i *instance) isVirtualizationCompatible(spotVirtualizationTypes []string)
 	return false
 }
 
-func (i *instance) isAllowed(instanceType string, allowedList []string, disallowedList []string) bool {
-	debug.Println("Checking allowed/disallowed list")
+// isAllowed evaluates the allowed and disallowed instance types lists. The
+// disallowed list is ignored when the allowed list names instance types, but
+// still applies when it only contains policies and categories.
+func (i *instance) isAllowed(spotCandidate instanceTypeInformation, allowedList []string, disallowedList []string) bool {
+	i.region.debugLogger().Println("Checking allowed/disallowed list")
+
+	instanceType := spotCandidate.instanceType
 
 	if len(allowedList) > 0 {
-		for _, a := range allowedList {
-			if match, _ := filepath.Match(a, instanceType); match {
-				return true
-			}
+		if !i.matchesAllowedList(spotCandidate, allowedList) {
+			i.region.debugLogger().Println("Instance has been excluded since it was not in the allowed instance types list")
+			return false
 		}
-		debug.Println("Instance has been excluded since it was not in the allowed instance types list")
-		return false
-	} else if len(disallowedList) > 0 {
-		for _, a := range disallowedList {
-			// glob matching
-			if match, _ := filepath.Match(a, instanceType); match {
-				debug.Println("Instance has been excluded since it was in the disallowed instance types list")
+		if hasInstanceTypeGlobs(allowedList) {
+			return true
+		}
+	}
+
+	for _, a := range disallowedList {
+		// glob matching
+		if match, _ := filepath.Match(a, instanceType); match {
+			i.region.debugLogger().Println("Instance has been excluded since it was in the disallowed instance types list")
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasInstanceTypeGlobs tells if a list of instance type selectors contains
+// any glob patterns, besides the policies and categories.
+func hasInstanceTypeGlobs(list []string) bool {
+	for _, a := range list {
+		_, policy := instanceTypePolicies[a]
+		_, category := instanceCategories[a]
+		if !policy && !category {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAllowedList evaluates the allowed instance types list, which may mix
+// glob patterns with instance type selectors. Globs and categories are each
+// evaluated as alternatives, while the generation and family policies are
+// constraints that all need to be satisfied by the spot candidate.
+func (i *instance) matchesAllowedList(spotCandidate instanceTypeInformation, allowedList []string) bool {
+	var globMatched, globsGiven, categoryMatched, categoriesGiven bool
+
+	for _, a := range allowedList {
+
+		if policy, ok := instanceTypePolicies[a]; ok {
+			if !policy(i.typeInfo, spotCandidate) {
+				i.region.debugLogger().Println("Instance type", spotCandidate.instanceType,
+					"doesn't satisfy the", a, "policy")
 				return false
 			}
+			continue
+		}
+
+		if categories, ok := instanceCategories[a]; ok {
+			categoriesGiven = true
+			if isInCategory(spotCandidate, categories) {
+				categoryMatched = true
+			}
+			continue
+		}
+
+		globsGiven = true
+		if match, _ := filepath.Match(a, spotCandidate.instanceType); match {
+			globMatched = true
 		}
-		return true
 	}
 
-	return true
+	return (!globsGiven || globMatched) && (!categoriesGiven || categoryMatched)
 }
 
-func (i *instance) getCheapestCompatibleSpotInstanceType(allowedList []string, disallowedList []string) (string, error) {
+// getCompatibleSpotInstanceType returns the compatible spot instance type
+// preferred by the selection strategy.
+func (i *instance) getCompatibleSpotInstanceType(allowedList []string,
+	disallowedList []string, strategy selectionStrategy) (string, error) {
+
 	current := i.typeInfo
-	bestPrice := math.MaxFloat64
-	chosenSpotType := ""
-	attachedVolumesNumber := current.instanceStoreDeviceCount
-
-	// Count the ephemeral volumes attached to the original instance's block
-	// device mappings, this number is used later when comparing with each
-	// instance type.
-	lc := i.asg.getLaunchConfiguration()
-
-	if lc != nil {
-		lcMappings := lc.countLaunchConfigEphemeralVolumes()
-		attachedVolumesNumber = min(lcMappings, current.instanceStoreDeviceCount)
-	}
+	var chosen *spotCandidate
+	attachedVolumesNumber := i.attachedVolumesNumber()
 
-	for _, candidate := range i.region.instanceTypeInformation {
+	for _, candidate := range i.getInstanceTypeInformation() {
 
-		logger.Println("Comparing ", candidate.instanceType, " with ",
+		i.region.logger().Println("Comparing ", candidate.instanceType, " with ",
 			current.instanceType)
 
-		candidatePrice := i.calculatePrice(candidate)
-
-		if i.isPriceCompatible(candidatePrice, bestPrice) &&
-			i.isEBSCompatible(candidate) &&
-			i.isClassCompatible(candidate) &&
-			i.isStorageCompatible(candidate, attachedVolumesNumber) &&
-			i.isVirtualizationCompatible(candidate.virtualizationTypes) &&
-			i.isAllowed(candidate.instanceType, allowedList, disallowedList) {
-			bestPrice = candidatePrice
-			chosenSpotType = candidate.instanceType
-			debug.Println("Best option is now: ", chosenSpotType, " at ", bestPrice)
-		} else if chosenSpotType != "" {
-			debug.Println("Current best option: ", chosenSpotType, " at ", bestPrice)
+		c := i.newSpotCandidate(candidate, i.calculatePrice(candidate))
+
+		if i.incompatibility(candidate, c.price, attachedVolumesNumber,
+			allowedList, disallowedList) == "" &&
+			(chosen == nil || strategy.prefers(c, *chosen)) {
+			chosen = &c
+			i.region.debugLogger().Println("Best option is now: ", chosen.instanceType, " at ", chosen.price)
+		} else if chosen != nil {
+			i.region.debugLogger().Println("Current best option: ", chosen.instanceType, " at ", chosen.price)
 		}
 	}
-	if chosenSpotType != "" {
-		debug.Println("Cheapest compatible spot instance found: ", chosenSpotType)
-		return chosenSpotType, nil
+	if chosen != nil {
+		i.region.debugLogger().Println("Preferred compatible spot instance found: ", chosen.instanceType)
+		return chosen.instanceType, nil
 	}
-	return chosenSpotType, fmt.Errorf("No cheaper spot instance types could be found")
+	return "", fmt.Errorf("No cheaper spot instance types could be found")
+}
+
+// attachedVolumesNumber counts the ephemeral volumes attached to the original
+// instance's block device mappings, this number is used later when comparing
+// with each instance type.
+func (i *instance) attachedVolumesNumber() int {
+	count := i.typeInfo.instanceStoreDeviceCount
+
+	if lc := i.asg.getLaunchConfiguration(); lc != nil {
+		count = min(lc.countLaunchConfigEphemeralVolumes(), count)
+	}
+	return count
+}
+
+// incompatibility returns the first compatibility check failed by a spot
+// candidate, or an empty string if it can replace the instance.
+func (i *instance) incompatibility(candidate instanceTypeInformation,
+	candidatePrice float64, attachedVolumes int, allowedList []string,
+	disallowedList []string) string {
+
+	switch {
+	case !i.isPriceCompatible(candidatePrice, math.MaxFloat64):
+		return "price"
+	case !i.isEBSCompatible(candidate):
+		return "ebs"
+	case !i.isClassCompatible(candidate):
+		return "class"
+	case !i.isBurstableCompatible(candidate):
+		return "burstable"
+	case !i.isStorageCompatible(candidate, attachedVolumes):
+		return "storage"
+	case !i.isVirtualizationCompatible(candidate.virtualizationTypes):
+		return "virtualization"
+	case !i.isAllowed(candidate, allowedList, disallowedList):
+		return "allowed"
+	case !i.isInterruptionCompatible(candidate):
+		return "interruption"
+	}
+	return ""
 }
 
 func (i *instance) tag(tags []*ec2.Tag, maxIter int) error {
@@ -288,7 +435,7 @@ func (i *instance) tag(tags []*ec2.Tag, maxIter int) error {
 	)
 
 	if len(tags) == 0 {
-		logger.Println(i.region.name, "Tagging spot instance", *i.InstanceId,
+		i.region.logger().Println(i.region.name, "Tagging spot instance", *i.InstanceId,
 			"no tags were defined, skipping...")
 		return nil
 	}
@@ -299,24 +446,66 @@ func (i *instance) tag(tags []*ec2.Tag, maxIter int) error {
 		Tags:      tags,
 	}
 
-	logger.Println(i.region.name, "Tagging spot instance", *i.InstanceId)
+	i.region.logger().Println(i.region.name, "Tagging spot instance", *i.InstanceId)
 
 	for n = 0; n < maxIter; n++ {
 		_, err = svc.CreateTags(&params)
 		if err == nil {
-			logger.Println("Instance", *i.InstanceId,
+			i.region.logger().Println("Instance", *i.InstanceId,
 				"was tagged with the following tags:", tags)
 			break
 		}
-		logger.Println(i.region.name,
+		i.region.logger().Println(i.region.name,
 			"Failed to create tags for the spot instance", *i.InstanceId, err.Error())
-		logger.Println(i.region.name,
+		i.region.logger().Println(i.region.name,
 			"Sleeping for 5 seconds before retrying")
-		time.Sleep(5 * time.Second * i.region.conf.SleepMultiplier)
+		i.region.conf.clock().Sleep(5 * time.Second * i.region.conf.SleepMultiplier)
 	}
 	return err
 }
 
+// loadCPUCredits retrieves the CPU credit option of a burstable instance.
+func (i *instance) loadCPUCredits() error {
+	resp, err := i.region.services.ec2.DescribeInstanceCreditSpecifications(
+		&ec2.DescribeInstanceCreditSpecificationsInput{
+			InstanceIds: []*string{i.InstanceId},
+		})
+	if err != nil {
+		return err
+	}
+
+	for _, cs := range resp.InstanceCreditSpecifications {
+		if cs.InstanceId != nil && *cs.InstanceId == *i.InstanceId &&
+			cs.CpuCredits != nil {
+			i.cpuCredits = *cs.CpuCredits
+			i.region.debugLogger().Println("Instance", *i.InstanceId, "uses", i.cpuCredits, "CPU credits")
+		}
+	}
+	return nil
+}
+
+// setCPUCredits changes the CPU credit option of a burstable instance.
+func (i *instance) setCPUCredits(cpuCredits string) error {
+	_, err := i.region.services.ec2.ModifyInstanceCreditSpecification(
+		&ec2.ModifyInstanceCreditSpecificationInput{
+			InstanceCreditSpecifications: []*ec2.InstanceCreditSpecificationRequest{
+				{
+					InstanceId: i.InstanceId,
+					CpuCredits: aws.String(cpuCredits),
+				},
+			},
+		})
+	if err != nil {
+		i.region.logger().Println(i.region.name, "Failed to set", cpuCredits,
+			"CPU credits on instance", *i.InstanceId, err.Error())
+		return err
+	}
+	i.cpuCredits = cpuCredits
+	i.region.logger().Println(i.region.name, "Instance", *i.InstanceId, "now uses",
+		cpuCredits, "CPU credits")
+	return nil
+}
+
 // Why the heck isn't this in the Go standard library?
 func min(x, y int) int {
 	if x < y {
@@ -0,0 +1,326 @@
+package autospotting
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/cristim/ec2-instances-info"
+	instancesdata "github.com/cristim/ec2-instances-info/data"
+)
+
+// errNotModified is returned when the remote instance data didn't change
+// since it was cached.
+var errNotModified = errors.New("not modified")
+
+// instanceDataTimeout limits the time spent downloading the instance data.
+const instanceDataTimeout = 30 * time.Second
+
+// loadInstanceData replaces the bundled instance data with the configured
+// external data, if any, and applies the local overrides on top of it.
+func loadInstanceData(cfg *Config) error {
+
+	if cfg.InstanceDataSource == "" && cfg.InstanceDataOverrides == "" {
+		return nil
+	}
+
+	raw, err := instancesdata.Asset("data/instances.json")
+	if err != nil {
+		return err
+	}
+
+	if cfg.InstanceDataSource != "" {
+		external, err := fetchVerifiedInstanceData(cfg.InstanceDataSource,
+			cfg.InstanceDataChecksum, cfg.InstanceDataCache, cfg.logs)
+		if err != nil {
+			return err
+		}
+		raw = external
+	}
+
+	if cfg.InstanceDataOverrides != "" {
+		overrides, err := ioutil.ReadFile(cfg.InstanceDataOverrides)
+		if err != nil {
+			return err
+		}
+
+		if raw, err = applyInstanceDataOverrides(raw, overrides); err != nil {
+			return err
+		}
+	}
+
+	// the daemon mode reuses the data parsed by the previous run if unchanged
+	sum := sha256.Sum256(raw)
+	hash := hex.EncodeToString(sum[:])
+	if hash == cfg.instanceDataHash && cfg.InstanceData != nil {
+		cfg.logs.debugLogger().Println("The instance data didn't change since the previous run")
+		return nil
+	}
+
+	data, err := parseInstanceData(raw)
+	if err != nil {
+		return err
+	}
+
+	osPrices, err := ParseOSPrices(raw)
+	if err != nil {
+		return err
+	}
+
+	cfg.logs.logger().Println("Loaded the data of", len(*data), "instance types")
+	cfg.InstanceData, cfg.OSPrices, cfg.instanceDataHash = data, osPrices, hash
+	return nil
+}
+
+// fetchVerifiedInstanceData reads instance data in the ec2instances.info JSON
+// format from a local file, an S3 URL such as s3://bucket/instances.json or an
+// HTTP URL. Remote data is cached in cacheDir, if given, and only downloaded again
+// when modified, while the cached copy is also used if the download fails.
+// When a SHA-256 checksum is given the data is verified against it, before
+// being cached.
+func fetchVerifiedInstanceData(source, checksum, cacheDir string,
+	logs *loggers) ([]byte, error) {
+
+	verify := func(raw []byte) error {
+		if checksum == "" {
+			return nil
+		}
+		sum := sha256.Sum256(raw)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), checksum) {
+			return fmt.Errorf("checksum mismatch for the instance data from %s",
+				source)
+		}
+		return nil
+	}
+
+	return fetchData(source, cacheDir, "instances", "instance data", verify, logs)
+}
+
+// fetchData reads the data from a local file, an S3 or an HTTP URL, caching
+// the remote data in cacheDir, if given, in a file named after the prefix and
+// the source. The description is used in the messages logged to logs. The
+// data is checked by the verify function, if given, so only valid data is
+// cached or used, a failed verification of the downloaded data being handled
+// like a failed download.
+func fetchData(source, cacheDir, prefix, description string,
+	verify func([]byte) error, logs *loggers) ([]byte, error) {
+
+	if verify == nil {
+		verify = func([]byte) error { return nil }
+	}
+
+	var download func(since time.Time) ([]byte, error)
+
+	switch {
+	case strings.HasPrefix(source, "s3://"):
+		download = func(since time.Time) ([]byte, error) {
+			return downloadS3(source, since)
+		}
+	case strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://"):
+		download = func(since time.Time) ([]byte, error) {
+			return downloadHTTP(source, since)
+		}
+	default:
+		download = func(time.Time) ([]byte, error) {
+			return ioutil.ReadFile(source)
+		}
+		// local files aren't cached
+		cacheDir = ""
+	}
+
+	if cacheDir == "" {
+		raw, err := download(time.Time{})
+		if err == nil {
+			err = verify(raw)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return raw, nil
+	}
+
+	cache := fileCache{dir: cacheDir}
+	sum := sha256.Sum256([]byte(source))
+	name := prefix + "-" + hex.EncodeToString(sum[:8]) + ".json"
+
+	// the cached copy may have been verified with different settings
+	cached, modified, cacheErr := cache.load(name)
+	if cacheErr == nil {
+		cacheErr = verify(cached)
+	}
+	if cacheErr != nil {
+		modified = time.Time{}
+	}
+
+	raw, err := download(modified)
+	if err == nil {
+		err = verify(raw)
+	}
+
+	switch {
+	case err == errNotModified:
+		logs.debugLogger().Println("Using the cached", description, "from", source)
+		return cached, nil
+
+	case err != nil && cacheErr == nil:
+		logs.logger().Println("Couldn't download the", description, "from", source,
+			"using the cached copy:", err)
+		return cached, nil
+
+	case err != nil:
+		return nil, err
+	}
+
+	if err := cache.save(name, raw); err != nil {
+		logs.logger().Println("Couldn't cache the", description+":", err)
+	}
+	return raw, nil
+}
+
+func downloadHTTP(source string, since time.Time) ([]byte, error) {
+	req, err := http.NewRequest("GET", source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !since.IsZero() {
+		req.Header.Set("If-Modified-Since", since.UTC().Format(http.TimeFormat))
+	}
+
+	client := http.Client{Timeout: instanceDataTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotModified:
+		return nil, errNotModified
+	}
+	return nil, fmt.Errorf("unexpected response status %s for %s", resp.Status, source)
+}
+
+func downloadS3(source string, since time.Time) ([]byte, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	region, err := s3manager.GetBucketRegion(aws.BackgroundContext(), sess,
+		u.Host, "us-east-1")
+	if err != nil {
+		return nil, err
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	}
+	if !since.IsZero() {
+		input.IfModifiedSince = aws.Time(since)
+	}
+
+	resp, err := s3.New(sess, aws.NewConfig().WithRegion(region)).GetObject(input)
+	if err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok &&
+			reqErr.StatusCode() == http.StatusNotModified {
+			return nil, errNotModified
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// applyInstanceDataOverrides patches instance data with a list of instance
+// types given in the same JSON format. Existing instance types are merged
+// with the overrides, so only the changed attributes need to be given, while
+// unknown instance types are added.
+func applyInstanceDataOverrides(raw, overrides []byte) ([]byte, error) {
+	var data, patches []map[string]interface{}
+
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(overrides, &patches); err != nil {
+		return nil, fmt.Errorf("couldn't parse the instance data overrides: %s",
+			err.Error())
+	}
+
+	index := make(map[string]int)
+	for i, it := range data {
+		if instanceType, ok := it["instance_type"].(string); ok {
+			index[instanceType] = i
+		}
+	}
+
+	for _, patch := range patches {
+		instanceType, ok := patch["instance_type"].(string)
+		if !ok || instanceType == "" {
+			return nil, errors.New("instance data override without instance_type")
+		}
+
+		if i, ok := index[instanceType]; ok {
+			mergeJSON(data[i], patch)
+			continue
+		}
+		index[instanceType] = len(data)
+		data = append(data, patch)
+	}
+
+	return json.Marshal(data)
+}
+
+// mergeJSON recursively merges the patch object into the destination one.
+func mergeJSON(dst, patch map[string]interface{}) {
+	for k, v := range patch {
+		pv, pok := v.(map[string]interface{})
+		dv, dok := dst[k].(map[string]interface{})
+		if pok && dok {
+			mergeJSON(dv, pv)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// parseInstanceData converts raw instance data in the ec2instances.info JSON
+// format, in the same way as the data bundled in the ec2instancesinfo library.
+func parseInstanceData(raw []byte) (*ec2instancesinfo.InstanceData, error) {
+	var d ec2instancesinfo.InstanceData
+
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+
+	// the vCPU field is "N/A" for some instance types
+	for i := range d {
+		var n int
+		if err := json.Unmarshal(d[i].VCPURaw, &n); err == nil {
+			d[i].VCPU = n
+		}
+	}
+	return &d, nil
+}
@@ -0,0 +1,210 @@
+package autospotting
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testInstanceData = `[
+	{
+		"instance_type": "m5.large",
+		"vCPU": 2,
+		"memory": 8,
+		"pricing": {
+			"us-east-1": {
+				"linux": {"ondemand": "0.096"},
+				"mswin": {"ondemand": "0.188"},
+				"ebs": "0.0"
+			}
+		}
+	},
+	{
+		"instance_type": "i3.metal",
+		"vCPU": "N/A",
+		"memory": 512
+	}
+]`
+
+func TestApplyInstanceDataOverrides(t *testing.T) {
+	overrides := `[
+		{"instance_type": "m5.large", "pricing": {"us-east-1": {"linux": {"ondemand": "0.08"}}}},
+		{"instance_type": "m9.large", "vCPU": 4, "memory": 16,
+		 "pricing": {"us-east-1": {"linux": {"ondemand": "0.2"}}}}
+	]`
+
+	raw, err := applyInstanceDataOverrides([]byte(testInstanceData), []byte(overrides))
+	if err != nil {
+		t.Fatalf("applyInstanceDataOverrides() error = %v", err)
+	}
+
+	data, err := parseInstanceData(raw)
+	if err != nil {
+		t.Fatalf("parseInstanceData() error = %v", err)
+	}
+
+	types := make(map[string]int)
+	for i, it := range *data {
+		types[it.InstanceType] = i
+	}
+
+	if len(types) != 3 {
+		t.Fatalf("got %d instance types, want 3", len(types))
+	}
+
+	m5 := (*data)[types["m5.large"]]
+	if m5.Pricing["us-east-1"].Linux.OnDemand != 0.08 || m5.VCPU != 2 {
+		t.Errorf("m5.large wasn't patched correctly: %+v", m5)
+	}
+
+	osPrices, err := ParseOSPrices(raw)
+	if err != nil {
+		t.Fatalf("ParseOSPrices() error = %v", err)
+	}
+	if p, _ := osPrices.onDemand("m5.large", "us-east-1", windowsPricing); p != 0.188 {
+		t.Errorf("the Windows price of m5.large was lost while patching")
+	}
+
+	if m9 := (*data)[types["m9.large"]]; m9.VCPU != 4 ||
+		m9.Pricing["us-east-1"].Linux.OnDemand != 0.2 {
+		t.Errorf("m9.large wasn't added correctly: %+v", m9)
+	}
+
+	if (*data)[types["i3.metal"]].VCPU != 0 {
+		t.Errorf("i3.metal vCPU = %d, want 0", (*data)[types["i3.metal"]].VCPU)
+	}
+
+	if _, err := applyInstanceDataOverrides([]byte(testInstanceData),
+		[]byte(`[{"vCPU": 2}]`)); err == nil {
+		t.Errorf("override without instance type didn't fail")
+	}
+}
+
+func TestFetchVerifiedInstanceData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var status int
+	var requests int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+	defer server.Close()
+
+	sum := sha256.Sum256([]byte(testInstanceData))
+	checksum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		checksum string
+		cacheDir string
+		wantErr  bool
+	}{
+		{name: "download without cache",
+			status: http.StatusOK,
+		},
+		{name: "download with valid checksum",
+			status:   http.StatusOK,
+			checksum: checksum,
+			cacheDir: dir,
+		},
+		{name: "not modified since cached",
+			status:   http.StatusNotModified,
+			checksum: checksum,
+			cacheDir: dir,
+		},
+		{name: "checksum mismatch using the cached copy",
+			status:   http.StatusOK,
+			body:     "[]",
+			checksum: checksum,
+			cacheDir: dir,
+		},
+		{name: "download failure using the cached copy",
+			status:   http.StatusInternalServerError,
+			checksum: checksum,
+			cacheDir: dir,
+		},
+		{name: "download failure without cache",
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+		{name: "checksum mismatch",
+			status:   http.StatusOK,
+			checksum: "0123456789abcdef",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, requests, body = tt.status, 0, tt.body
+			if body == "" {
+				body = testInstanceData
+			}
+
+			raw, err := fetchVerifiedInstanceData(server.URL+"/instances.json",
+				tt.checksum, tt.cacheDir, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchVerifiedInstanceData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != 1 {
+				t.Errorf("made %d requests, want 1", requests)
+			}
+			if !tt.wantErr && string(raw) != testInstanceData {
+				t.Errorf("fetchVerifiedInstanceData() = %s", raw)
+			}
+		})
+	}
+}
+
+func TestLoadInstanceData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "instances.json")
+	overrides := filepath.Join(dir, "overrides.json")
+
+	ioutil.WriteFile(source, []byte(testInstanceData), 0644)
+	ioutil.WriteFile(overrides, []byte(`[{"instance_type": "m5.large", "memory": 16}]`), 0644)
+
+	cfg := &Config{
+		InstanceDataSource:    source,
+		InstanceDataOverrides: overrides,
+	}
+
+	if err := loadInstanceData(cfg); err != nil {
+		t.Fatalf("loadInstanceData() error = %v", err)
+	}
+
+	if len(*cfg.InstanceData) != 2 || (*cfg.InstanceData)[0].Memory != 16 {
+		t.Errorf("loadInstanceData() = %+v", *cfg.InstanceData)
+	}
+
+	if p, _ := cfg.OSPrices.onDemand("m5.large", "us-east-1", windowsPricing); p != 0.188 {
+		t.Errorf("loadInstanceData() didn't load the operating system prices")
+	}
+
+	cfg = &Config{InstanceDataSource: filepath.Join(dir, "missing.json")}
+	if err := loadInstanceData(cfg); err == nil || cfg.InstanceData != nil {
+		t.Errorf("loadInstanceData() of a missing file should fail")
+	}
+}
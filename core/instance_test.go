@@ -2,6 +2,7 @@ package autospotting
 
 import (
 	"errors"
+	"math"
 	"reflect"
 	"testing"
 
@@ -233,6 +234,38 @@ func TestIsSpot(t *testing.T) {
 		})
 	}
 }
+func TestHasSpotCompatibleTenancy(t *testing.T) {
+	tests := []struct {
+		name      string
+		placement *ec2.Placement
+		want      bool
+	}{
+		{name: "no placement information",
+			want: true,
+		},
+		{name: "default tenancy",
+			placement: &ec2.Placement{Tenancy: aws.String("default")},
+			want:      true,
+		},
+		{name: "dedicated tenancy",
+			placement: &ec2.Placement{Tenancy: aws.String("dedicated")},
+			want:      true,
+		},
+		{name: "dedicated host",
+			placement: &ec2.Placement{Tenancy: aws.String("host")},
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{Instance: &ec2.Instance{Placement: tt.placement}}
+			if got := i.hasSpotCompatibleTenancy(); got != tt.want {
+				t.Errorf("hasSpotCompatibleTenancy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestIsEBSCompatible(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -644,7 +677,281 @@ func TestIsVirtualizationCompatible(t *testing.T) {
 	}
 }
 
-func TestGetCheapestCompatibleSpotInstanceType(t *testing.T) {
+func TestIsAllowed(t *testing.T) {
+	tests := []struct {
+		name           string
+		current        instanceTypeInformation
+		candidate      instanceTypeInformation
+		allowedList    []string
+		disallowedList []string
+		want           bool
+	}{
+		{name: "no lists",
+			current:   instanceTypeInformation{instanceType: "m4.large"},
+			candidate: instanceTypeInformation{instanceType: "c5.large"},
+			want:      true,
+		},
+		{name: "glob in allowed list",
+			current:     instanceTypeInformation{instanceType: "m4.large"},
+			candidate:   instanceTypeInformation{instanceType: "c5.large"},
+			allowedList: []string{"c5.*", "m4.xlarge"},
+			want:        true,
+		},
+		{name: "glob not in allowed list",
+			current:     instanceTypeInformation{instanceType: "m4.large"},
+			candidate:   instanceTypeInformation{instanceType: "c4.large"},
+			allowedList: []string{"c5.*", "m4.xlarge"},
+			want:        false,
+		},
+		{name: "glob in disallowed list",
+			current:        instanceTypeInformation{instanceType: "m4.large"},
+			candidate:      instanceTypeInformation{instanceType: "t2.large"},
+			disallowedList: []string{"t2.*"},
+			want:           false,
+		},
+		{name: "category filter matching",
+			current:     instanceTypeInformation{instanceType: "m4.large"},
+			candidate:   instanceTypeInformation{instanceType: "c5.large", category: "Compute optimized"},
+			allowedList: []string{"compute", "memory"},
+			want:        true,
+		},
+		{name: "category filter not matching",
+			current:     instanceTypeInformation{instanceType: "m4.large"},
+			candidate:   instanceTypeInformation{instanceType: "m5.large", category: "General purpose"},
+			allowedList: []string{"compute", "memory"},
+			want:        false,
+		},
+		{name: "policy combined with a glob",
+			current:     instanceTypeInformation{instanceType: "m4.large"},
+			candidate:   instanceTypeInformation{instanceType: "m3.large"},
+			allowedList: []string{"m*", "same-generation-or-newer"},
+			want:        false,
+		},
+		{name: "policy only",
+			current:     instanceTypeInformation{instanceType: "m4.large"},
+			candidate:   instanceTypeInformation{instanceType: "m5.large", currentGeneration: true},
+			allowedList: []string{"same-generation-or-newer", "current-generation-only"},
+			want:        true,
+		},
+		{name: "policy only with the disallowed list",
+			current:        instanceTypeInformation{instanceType: "m4.large"},
+			candidate:      instanceTypeInformation{instanceType: "t3.large", currentGeneration: true},
+			allowedList:    []string{"current-generation-only"},
+			disallowedList: []string{"t3.*"},
+			want:           false,
+		},
+		{name: "category only with the disallowed list",
+			current:        instanceTypeInformation{instanceType: "c4.large"},
+			candidate:      instanceTypeInformation{instanceType: "c5.large", category: "Compute optimized"},
+			allowedList:    []string{"compute"},
+			disallowedList: []string{"c5.*"},
+			want:           false,
+		},
+		{name: "glob in allowed list overriding the disallowed list",
+			current:        instanceTypeInformation{instanceType: "m4.large"},
+			candidate:      instanceTypeInformation{instanceType: "c5.large"},
+			allowedList:    []string{"c5.*"},
+			disallowedList: []string{"c5.*"},
+			want:           true,
+		},
+		{name: "policy combined with category and glob",
+			current:     instanceTypeInformation{instanceType: "c4.large"},
+			candidate:   instanceTypeInformation{instanceType: "c5.large", category: "Compute optimized", currentGeneration: true},
+			allowedList: []string{"current-generation-only", "compute", "c*"},
+			want:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{typeInfo: tt.current}
+			if got := i.isAllowed(tt.candidate, tt.allowedList, tt.disallowedList); got != tt.want {
+				t.Errorf("isAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBurstableCompatible(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   instanceTypeInformation
+		candidate instanceTypeInformation
+		asg       *autoScalingGroup
+		want      bool
+	}{
+		{name: "fixed-performance candidate",
+			current:   instanceTypeInformation{instanceType: "m4.large"},
+			candidate: instanceTypeInformation{instanceType: "m5.large"},
+			want:      true,
+		},
+		{name: "burstable candidate for burstable instance",
+			current:   instanceTypeInformation{instanceType: "t2.large", burstable: true},
+			candidate: instanceTypeInformation{instanceType: "t3.large", burstable: true},
+			want:      true,
+		},
+		{name: "burstable candidate for fixed-performance instance",
+			current:   instanceTypeInformation{instanceType: "m4.large"},
+			candidate: instanceTypeInformation{instanceType: "t3.large", burstable: true},
+			asg: &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				region: &region{conf: &Config{}},
+			},
+			want: false,
+		},
+		{name: "burstable candidate allowed globally",
+			current:   instanceTypeInformation{instanceType: "m4.large"},
+			candidate: instanceTypeInformation{instanceType: "t3.large", burstable: true},
+			asg: &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				region: &region{conf: &Config{AllowBurstableInstanceTypes: true}},
+			},
+			want: true,
+		},
+		{name: "burstable candidate allowed by tag",
+			current:   instanceTypeInformation{instanceType: "m4.large"},
+			candidate: instanceTypeInformation{instanceType: "t3.large", burstable: true},
+			asg: &autoScalingGroup{
+				Group: &autoscaling.Group{
+					Tags: []*autoscaling.TagDescription{
+						{
+							Key:   aws.String(AllowBurstableInstanceTypesTag),
+							Value: aws.String("true"),
+						},
+					},
+				},
+				region: &region{conf: &Config{}},
+			},
+			want: true,
+		},
+		{name: "burstable candidate denied by tag",
+			current:   instanceTypeInformation{instanceType: "m4.large"},
+			candidate: instanceTypeInformation{instanceType: "t3.large", burstable: true},
+			asg: &autoScalingGroup{
+				Group: &autoscaling.Group{
+					Tags: []*autoscaling.TagDescription{
+						{
+							Key:   aws.String(AllowBurstableInstanceTypesTag),
+							Value: aws.String("false"),
+						},
+					},
+				},
+				region: &region{conf: &Config{AllowBurstableInstanceTypes: true}},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{typeInfo: tt.current, asg: tt.asg}
+			if got := i.isBurstableCompatible(tt.candidate); got != tt.want {
+				t.Errorf("isBurstableCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimatedSurplusCreditsCost(t *testing.T) {
+	tests := []struct {
+		name       string
+		cpuCredits string
+		candidate  instanceTypeInformation
+		percentage float64
+		product    string
+		want       float64
+	}{
+		{name: "fixed-performance candidate",
+			cpuCredits: "unlimited",
+			candidate:  instanceTypeInformation{instanceType: "m5.large", vCPU: 2},
+			percentage: 10,
+			want:       0,
+		},
+		{name: "standard credits",
+			cpuCredits: "standard",
+			candidate:  instanceTypeInformation{instanceType: "t3.large", vCPU: 2, burstable: true},
+			percentage: 10,
+			want:       0,
+		},
+		{name: "unlimited credits",
+			cpuCredits: "unlimited",
+			candidate:  instanceTypeInformation{instanceType: "t3.large", vCPU: 2, burstable: true},
+			percentage: 10,
+			want:       0.01,
+		},
+		{name: "unlimited credits on Linux",
+			cpuCredits: "unlimited",
+			candidate:  instanceTypeInformation{instanceType: "t3.large", vCPU: 2, burstable: true},
+			percentage: 10,
+			product:    "Linux/UNIX (Amazon VPC)",
+			want:       0.01,
+		},
+		{name: "unlimited credits on Windows",
+			cpuCredits: "unlimited",
+			candidate:  instanceTypeInformation{instanceType: "t3.large", vCPU: 2, burstable: true},
+			percentage: 10,
+			product:    "Windows (Amazon VPC)",
+			want:       0.0192,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				cpuCredits: tt.cpuCredits,
+				region: &region{
+					conf: &Config{BurstableSurplusCreditsPercentage: tt.percentage},
+				},
+				asg: &autoScalingGroup{spotProductDescription: tt.product},
+			}
+			if got := i.estimatedSurplusCreditsCost(tt.candidate); math.Abs(got-tt.want) > 0.000001 {
+				t.Errorf("estimatedSurplusCreditsCost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCPUCredits(t *testing.T) {
+	tests := []struct {
+		name    string
+		ec2     mockEC2
+		want    string
+		wantErr error
+	}{
+		{name: "unlimited credits",
+			ec2: mockEC2{
+				dicso: &ec2.DescribeInstanceCreditSpecificationsOutput{
+					InstanceCreditSpecifications: []*ec2.InstanceCreditSpecification{
+						{
+							InstanceId: aws.String("i-1"),
+							CpuCredits: aws.String("unlimited"),
+						},
+					},
+				},
+			},
+			want: "unlimited",
+		},
+		{name: "API error",
+			ec2: mockEC2{
+				dicserr: errors.New("describe error"),
+			},
+			want:    "",
+			wantErr: errors.New("describe error"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-1")},
+				region:   &region{services: connections{ec2: tt.ec2}},
+			}
+			err := i.loadCPUCredits()
+			CheckErrors(t, err, tt.wantErr)
+			if i.cpuCredits != tt.want {
+				t.Errorf("loadCPUCredits() loaded %v, want %v", i.cpuCredits, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetCompatibleSpotInstanceType(t *testing.T) {
 	tests := []struct {
 		name           string
 		spotInfos      map[string]instanceTypeInformation
@@ -1099,7 +1406,7 @@ func TestGetCheapestCompatibleSpotInstanceType(t *testing.T) {
 			i.asg = tt.asg
 			allowedList := tt.allowedList
 			disallowedList := tt.disallowedList
-			retValue, err := i.getCheapestCompatibleSpotInstanceType(allowedList, disallowedList)
+			retValue, err := i.getCompatibleSpotInstanceType(allowedList, disallowedList, cheapestStrategy{})
 			if err == nil && tt.expectedError != err {
 				t.Errorf("Error received: %v expected %v", err, tt.expectedError.Error())
 			} else if err != nil && tt.expectedError == nil {
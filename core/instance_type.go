@@ -0,0 +1,118 @@
+package autospotting
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Instance type selectors which can be used in the allowed instance types list
+// next to the usual globs, both from the command line and from the
+// autospotting_allowed_instance_types tag.
+const (
+	// SameFamilyPolicy only allows instance types from the same family as the
+	// original instance, for example m5.large can only be replaced with other
+	// m5 instance types.
+	SameFamilyPolicy = "same-family"
+
+	// SameGenerationOrNewerPolicy only allows instance types from the same
+	// series having the same or a newer generation than the original instance,
+	// for example m4.large can be replaced with m4 or m5 instance types.
+	SameGenerationOrNewerPolicy = "same-generation-or-newer"
+
+	// CurrentGenerationOnlyPolicy excludes all the instance types marked as
+	// previous generation.
+	CurrentGenerationOnlyPolicy = "current-generation-only"
+)
+
+// instanceTypePolicies maps the policy names to functions evaluating them
+// against the original instance type and a spot candidate.
+var instanceTypePolicies = map[string]func(current, candidate instanceTypeInformation) bool{
+	SameFamilyPolicy: func(current, candidate instanceTypeInformation) bool {
+		return parseInstanceType(current.instanceType).family() ==
+			parseInstanceType(candidate.instanceType).family()
+	},
+	SameGenerationOrNewerPolicy: func(current, candidate instanceTypeInformation) bool {
+		c, s := parseInstanceType(current.instanceType), parseInstanceType(candidate.instanceType)
+		return c.series == s.series && s.generation >= c.generation
+	},
+	CurrentGenerationOnlyPolicy: func(current, candidate instanceTypeInformation) bool {
+		return candidate.currentGeneration
+	},
+}
+
+// instanceCategories maps the category filters which can be given in the
+// allowed instance types list to the instance categories used in the
+// ec2instances.info data.
+var instanceCategories = map[string][]string{
+	"general-purpose": {"General purpose", "Micro"},
+	"compute":         {"Compute optimized"},
+	"memory":          {"Memory optimized"},
+	"storage":         {"Storage optimized"},
+	"accelerated":     {"Accelerated computing", "GPU optimized"},
+}
+
+func isInCategory(candidate instanceTypeInformation, categories []string) bool {
+	for _, c := range categories {
+		if strings.EqualFold(candidate.category, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// surplusCreditPricesPerVCPUHour are the prices of the surplus CPU credits
+// spent by burstable instances running in unlimited mode, keyed by the
+// operating system names used in the pricing data. The other operating
+// systems are charged the Linux price.
+var surplusCreditPricesPerVCPUHour = map[string]float64{
+	linuxPricing:   0.05,
+	windowsPricing: 0.096,
+}
+
+func surplusCreditPricePerVCPUHour(os string) float64 {
+	if price, ok := surplusCreditPricesPerVCPUHour[os]; ok {
+		return price
+	}
+	return surplusCreditPricesPerVCPUHour[linuxPricing]
+}
+
+// burstableSeries lists the instance series having burstable performance.
+var burstableSeries = map[string]bool{
+	"t": true,
+}
+
+func isBurstableInstanceType(instanceType string) bool {
+	return burstableSeries[parseInstanceType(instanceType).series]
+}
+
+var instanceTypeRegex = regexp.MustCompile(`^([a-z]+)(\d+)([a-z]*)\.`)
+
+// instanceTypeName stores the components of an instance type name such as
+// "m5d.xlarge", which would be parsed into the series "m", generation 5 and
+// attributes "d".
+type instanceTypeName struct {
+	series     string
+	generation int
+	attributes string
+}
+
+func parseInstanceType(instanceType string) instanceTypeName {
+	m := instanceTypeRegex.FindStringSubmatch(instanceType)
+	if m == nil {
+		return instanceTypeName{series: instanceType}
+	}
+
+	generation, _ := strconv.Atoi(m[2])
+
+	return instanceTypeName{
+		series:     m[1],
+		generation: generation,
+		attributes: m[3],
+	}
+}
+
+// family returns the instance family, such as "m5d" for "m5d.xlarge".
+func (n instanceTypeName) family() string {
+	return n.series + strconv.Itoa(n.generation) + n.attributes
+}
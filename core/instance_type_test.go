@@ -0,0 +1,107 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseInstanceType(t *testing.T) {
+	tests := []struct {
+		name         string
+		instanceType string
+		want         instanceTypeName
+		wantFamily   string
+	}{
+		{name: "simple instance type",
+			instanceType: "m5.large",
+			want:         instanceTypeName{series: "m", generation: 5},
+			wantFamily:   "m5",
+		},
+		{name: "instance type with attributes",
+			instanceType: "m5d.xlarge",
+			want:         instanceTypeName{series: "m", generation: 5, attributes: "d"},
+			wantFamily:   "m5d",
+		},
+		{name: "instance type with multi-letter series",
+			instanceType: "x1e.32xlarge",
+			want:         instanceTypeName{series: "x", generation: 1, attributes: "e"},
+			wantFamily:   "x1e",
+		},
+		{name: "instance type with two digit generation",
+			instanceType: "c10.large",
+			want:         instanceTypeName{series: "c", generation: 10},
+			wantFamily:   "c10",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseInstanceType(tt.instanceType)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseInstanceType() = %v, want %v", got, tt.want)
+			}
+			if got.family() != tt.wantFamily {
+				t.Errorf("family() = %v, want %v", got.family(), tt.wantFamily)
+			}
+		})
+	}
+}
+
+func TestInstanceTypePolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    string
+		current   instanceTypeInformation
+		candidate instanceTypeInformation
+		want      bool
+	}{
+		{name: "same family, matching",
+			policy:    SameFamilyPolicy,
+			current:   instanceTypeInformation{instanceType: "m5.large"},
+			candidate: instanceTypeInformation{instanceType: "m5.2xlarge"},
+			want:      true,
+		},
+		{name: "same family, different attributes",
+			policy:    SameFamilyPolicy,
+			current:   instanceTypeInformation{instanceType: "m5.large"},
+			candidate: instanceTypeInformation{instanceType: "m5d.large"},
+			want:      false,
+		},
+		{name: "same generation or newer, newer generation",
+			policy:    SameGenerationOrNewerPolicy,
+			current:   instanceTypeInformation{instanceType: "m4.large"},
+			candidate: instanceTypeInformation{instanceType: "m5.large"},
+			want:      true,
+		},
+		{name: "same generation or newer, older generation",
+			policy:    SameGenerationOrNewerPolicy,
+			current:   instanceTypeInformation{instanceType: "m4.large"},
+			candidate: instanceTypeInformation{instanceType: "m3.large"},
+			want:      false,
+		},
+		{name: "same generation or newer, different series",
+			policy:    SameGenerationOrNewerPolicy,
+			current:   instanceTypeInformation{instanceType: "m4.large"},
+			candidate: instanceTypeInformation{instanceType: "c5.large"},
+			want:      false,
+		},
+		{name: "current generation only, current",
+			policy:    CurrentGenerationOnlyPolicy,
+			current:   instanceTypeInformation{instanceType: "m3.large"},
+			candidate: instanceTypeInformation{instanceType: "m5.large", currentGeneration: true},
+			want:      true,
+		},
+		{name: "current generation only, previous",
+			policy:    CurrentGenerationOnlyPolicy,
+			current:   instanceTypeInformation{instanceType: "m5.large"},
+			candidate: instanceTypeInformation{instanceType: "m3.large"},
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := instanceTypePolicies[tt.policy](tt.current, tt.candidate); got != tt.want {
+				t.Errorf("policy %s = %v, want %v", tt.policy, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// interruptionBands are the labels of the interruption frequency bands of the
+// Spot Instance Advisor, in the order of their indexes.
+var interruptionBands = []string{"<5%", "5-10%", "10-15%", "15-20%", ">20%"}
+
+// InterruptionData is the spot interruption frequency data of the instance
+// types in the JSON format of the Spot Instance Advisor, such as the one
+// published at https://spot-bid-advisor.s3.amazonaws.com/spot-advisor-data.json
+type InterruptionData struct {
+	Ranges []InterruptionRange `json:"ranges"`
+
+	// keyed by region, operating system ("Linux" or "Windows") and instance
+	// type
+	SpotAdvisor map[string]map[string]map[string]InterruptionStats `json:"spot_advisor"`
+}
+
+// InterruptionRange is an interruption frequency band.
+type InterruptionRange struct {
+	Index int    `json:"index"`
+	Label string `json:"label"`
+	Max   int    `json:"max"`
+}
+
+// InterruptionStats are the interruption frequency band of an instance type
+// and its savings percentage compared to the on-demand price.
+type InterruptionStats struct {
+	Range   int `json:"r"`
+	Savings int `json:"s"`
+}
+
+// band returns the interruption frequency band of an instance type in a
+// region, for the operating system of a spot product description.
+func (d *InterruptionData) band(region, product, instanceType string) (int, bool) {
+	if d == nil {
+		return 0, false
+	}
+
+	os := "Linux"
+	if pricingOS(product) == windowsPricing {
+		os = "Windows"
+	}
+
+	stats, ok := d.SpotAdvisor[region][os][instanceType]
+	return stats.Range, ok
+}
+
+// label returns the name of a band, taken from the data when available.
+func (d *InterruptionData) label(band int) string {
+	if d != nil {
+		for _, r := range d.Ranges {
+			if r.Index == band {
+				return r.Label
+			}
+		}
+	}
+	if band >= 0 && band < len(interruptionBands) {
+		return interruptionBands[band]
+	}
+	return strconv.Itoa(band)
+}
+
+// parseInterruptionBand converts an interruption frequency band given as
+// its index or its label, such as "10-15%", to its index.
+func parseInterruptionBand(value string) (int, error) {
+	for n, label := range interruptionBands {
+		if value == label {
+			return n, nil
+		}
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n >= len(interruptionBands) {
+		return 0, fmt.Errorf("unknown interruption band %q, expecting an index "+
+			"between 0 and %d or one of %v", value, len(interruptionBands)-1,
+			interruptionBands)
+	}
+	return n, nil
+}
+
+// loadInterruptionData loads the configured interruption data, keeping the
+// data loaded by the previous runs if it can't be fetched.
+func loadInterruptionData(cfg *Config) error {
+	if cfg.InterruptionDataSource == "" {
+		return nil
+	}
+
+	raw, err := fetchData(cfg.InterruptionDataSource, cfg.InstanceDataCache,
+		"interruptions", "interruption data", nil, cfg.logs)
+	if err != nil {
+		return err
+	}
+
+	var data InterruptionData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("couldn't parse the interruption data: %s", err.Error())
+	}
+
+	cfg.logs.logger().Println("Loaded the interruption data of", len(data.SpotAdvisor), "regions")
+	cfg.InterruptionData = &data
+	return nil
+}
+
+// getMaxInterruptionBand returns the highest interruption frequency band of
+// the spot instance types acceptable for the group, given by its tag or else
+// by the global configuration. There is no limit if none is set.
+func (a *autoScalingGroup) getMaxInterruptionBand() (int, bool) {
+	if tag := a.getTagValue(MaxInterruptionBandTag); tag != nil {
+		band, err := parseInterruptionBand(*tag)
+		if err == nil {
+			return band, true
+		}
+		a.region.debugLogger().Println("Ignoring the tag", MaxInterruptionBandTag, err.Error())
+	}
+
+	if a.region.conf.MaxInterruptionBand == "" {
+		return 0, false
+	}
+
+	band, err := parseInterruptionBand(a.region.conf.MaxInterruptionBand)
+	if err != nil {
+		a.region.debugLogger().Println("Ignoring the maximum interruption band:", err.Error())
+		return 0, false
+	}
+	return band, true
+}
+
+// interruptionBand returns the interruption frequency band of a spot candidate
+// replacing the instance, if known.
+func (i *instance) interruptionBand(instanceType string) (int, bool) {
+	if i.asg == nil || i.region == nil || i.region.conf == nil {
+		return 0, false
+	}
+	return i.region.conf.InterruptionData.band(i.region.name,
+		i.asg.spotProductDescription, instanceType)
+}
+
+// isInterruptionCompatible tells if the interruption frequency band of a spot
+// candidate is acceptable for the group. The instance types missing from the
+// interruption data are accepted.
+func (i *instance) isInterruptionCompatible(spotCandidate instanceTypeInformation) bool {
+	band, ok := i.interruptionBand(spotCandidate.instanceType)
+	if !ok {
+		return true
+	}
+
+	max, limited := i.asg.getMaxInterruptionBand()
+	return !limited || band <= max
+}
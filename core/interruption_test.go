@@ -0,0 +1,189 @@
+package autospotting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const testInterruptionData = `{
+	"ranges": [
+		{"index": 0, "label": "<5%", "dots": 0, "max": 5},
+		{"index": 1, "label": "5-10%", "dots": 1, "max": 11}
+	],
+	"spot_advisor": {
+		"eu-west-1": {
+			"Linux": {"m5.large": {"s": 70, "r": 1}, "c5.large": {"s": 60, "r": 4}},
+			"Windows": {"m5.large": {"s": 40, "r": 0}}
+		}
+	}
+}`
+
+func TestParseInterruptionBand(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "<5%", want: 0},
+		{value: "15-20%", want: 3},
+		{value: "2", want: 2},
+		{value: "5", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "low", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseInterruptionBand(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseInterruptionBand() = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadInterruptionData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "spot-advisor-data.json")
+	invalid := filepath.Join(dir, "invalid.json")
+	ioutil.WriteFile(valid, []byte(testInterruptionData), 0644)
+	ioutil.WriteFile(invalid, []byte("{"), 0644)
+
+	cfg := &Config{InterruptionDataSource: valid}
+	if err := loadInterruptionData(cfg); err != nil {
+		t.Fatalf("loadInterruptionData() error = %v", err)
+	}
+
+	d := cfg.InterruptionData
+	tests := []struct {
+		product      string
+		instanceType string
+		want         int
+		wantOK       bool
+	}{
+		{product: DefaultSpotProductDescription, instanceType: "m5.large", want: 1, wantOK: true},
+		{product: "SUSE Linux", instanceType: "c5.large", want: 4, wantOK: true},
+		{product: "Windows (Amazon VPC)", instanceType: "m5.large", want: 0, wantOK: true},
+		{product: "Windows", instanceType: "c5.large"},
+	}
+	for _, tt := range tests {
+		if got, ok := d.band("eu-west-1", tt.product, tt.instanceType); got != tt.want || ok != tt.wantOK {
+			t.Errorf("band(%s, %s) = %d, %v, want %d, %v", tt.product,
+				tt.instanceType, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	if d.label(1) != "5-10%" || d.label(3) != "15-20%" {
+		t.Errorf("label() = %s and %s", d.label(1), d.label(3))
+	}
+
+	// the previously loaded data is kept
+	cfg.InterruptionDataSource = invalid
+	if err := loadInterruptionData(cfg); err == nil || cfg.InterruptionData != d {
+		t.Errorf("loadInterruptionData() of invalid data = %v", err)
+	}
+
+	var missing *InterruptionData
+	if _, ok := missing.band("eu-west-1", DefaultSpotProductDescription, "m5.large"); ok {
+		t.Errorf("band() without data succeeded")
+	}
+}
+
+func TestInstanceInterruption(t *testing.T) {
+	data := &InterruptionData{
+		SpotAdvisor: map[string]map[string]map[string]InterruptionStats{
+			"eu-west-1": {"Linux": {
+				"m4.large": {Range: 3},
+				"m5.large": {Range: 1},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		global    string
+		tag       *string
+		penalty   float64
+		candidate string
+
+		wantCompatible bool
+		wantPrice      float64
+	}{
+		{name: "no limit",
+			candidate:      "m4.large",
+			wantCompatible: true,
+			wantPrice:      0.1,
+		},
+		{name: "band above the global limit",
+			global:    "10-15%",
+			candidate: "m4.large",
+			wantPrice: 0.1,
+		},
+		{name: "tag overriding the global limit",
+			global:         "1",
+			tag:            aws.String(">20%"),
+			candidate:      "m4.large",
+			wantCompatible: true,
+			wantPrice:      0.1,
+		},
+		{name: "invalid tag ignored",
+			global:    "0",
+			tag:       aws.String("often"),
+			candidate: "m5.large",
+			wantPrice: 0.1,
+		},
+		{name: "penalized price",
+			penalty:        10,
+			candidate:      "m4.large",
+			wantCompatible: true,
+			wantPrice:      0.13,
+		},
+		{name: "missing from the data",
+			global:         "0",
+			penalty:        10,
+			candidate:      "c5.large",
+			wantCompatible: true,
+			wantPrice:      0.1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asg := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{name: "eu-west-1", conf: &Config{
+					InterruptionData:              data,
+					MaxInterruptionBand:           tt.global,
+					InterruptionPenaltyPercentage: tt.penalty,
+				}},
+				spotProductDescription: DefaultSpotProductDescription,
+			}
+			if tt.tag != nil {
+				asg.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(MaxInterruptionBandTag), Value: tt.tag},
+				}
+			}
+			i := &instance{region: asg.region, asg: asg}
+
+			info := instanceTypeInformation{instanceType: tt.candidate}
+			if got := i.isInterruptionCompatible(info); got != tt.wantCompatible {
+				t.Errorf("isInterruptionCompatible() = %v, want %v", got, tt.wantCompatible)
+			}
+
+			c := i.newSpotCandidate(info, 0.1)
+			if c.price != 0.1 || c.rankingPrice < tt.wantPrice-1e-9 ||
+				c.rankingPrice > tt.wantPrice+1e-9 {
+				t.Errorf("newSpotCandidate() = %+v, want the ranking price %v",
+					c, tt.wantPrice)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package autospotting
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -12,6 +13,9 @@ import (
 
 type launchConfiguration struct {
 	*autoscaling.LaunchConfiguration
+
+	// the loggers of the region, the ones of the process being used if nil
+	logs *loggers
 }
 
 var secGroupRegex = regexp.MustCompile(`^sg-[a-f0-9]{8,17}$`)
@@ -26,12 +30,12 @@ func (lc *launchConfiguration) countLaunchConfigEphemeralVolumes() int {
 	for _, mapping := range lc.BlockDeviceMappings {
 		if mapping.VirtualName != nil &&
 			strings.Contains(*mapping.VirtualName, "ephemeral") {
-			debug.Println("Found ephemeral device mapping", *mapping.VirtualName)
+			lc.logs.debugLogger().Println("Found ephemeral device mapping", *mapping.VirtualName)
 			count++
 		}
 	}
 
-	logger.Printf("Launch configuration would attach %d ephemeral volumes if available", count)
+	lc.logs.logger().Printf("Launch configuration would attach %d ephemeral volumes if available", count)
 
 	return count
 }
@@ -44,6 +48,10 @@ func (lc *launchConfiguration) convertLaunchConfigurationToSpotSpecification(
 
 	var spotLS ec2.RequestSpotLaunchSpecification
 
+	if err := lc.checkSpotCompatibility(); err != nil {
+		return nil, err
+	}
+
 	// convert attributes
 	spotLS.BlockDeviceMappings = copyBlockDeviceMappings(lc.BlockDeviceMappings)
 
@@ -74,10 +82,16 @@ func (lc *launchConfiguration) convertLaunchConfigurationToSpotSpecification(
 
 	spotLS.InstanceType = &newInstance.instanceType
 
-	// these ones should NOT be copied, they break the SpotLaunchSpecification,
-	// so that it can't be launched
-	// - spotLS.KernelId
-	// - spotLS.RamdiskId
+	// the launch configurations returned by the API contain empty strings
+	// instead of missing kernel and ramdisk IDs, which would break the spot
+	// request, so these are only copied when actually set.
+	if lc.KernelId != nil && *lc.KernelId != "" {
+		spotLS.KernelId = lc.KernelId
+	}
+
+	if lc.RamdiskId != nil && *lc.RamdiskId != "" {
+		spotLS.RamdiskId = lc.RamdiskId
+	}
 
 	if lc.KeyName != nil && *lc.KeyName != "" {
 		spotLS.KeyName = lc.KeyName
@@ -113,11 +127,118 @@ func (lc *launchConfiguration) convertLaunchConfigurationToSpotSpecification(
 		spotLS.UserData = lc.UserData
 	}
 
-	spotLS.Placement = &ec2.SpotPlacement{AvailabilityZone: &az}
+	tenancy, err := spotTenancy(lc.PlacementTenancy, baseInstance)
+	if err != nil {
+		return nil, err
+	}
+
+	spotLS.Placement = &ec2.SpotPlacement{
+		AvailabilityZone: &az,
+		Tenancy:          tenancy,
+	}
 
 	return &spotLS, nil
 }
 
+// convertLaunchConfigurationToRunInstancesInput builds the parameters for
+// launching an on-demand instance from the launch configuration, configured
+// like the spot instances launched from it.
+func (lc *launchConfiguration) convertLaunchConfigurationToRunInstancesInput(
+	baseInstance *instance,
+	newInstance instanceTypeInformation,
+	conn *connections,
+	az string) (*ec2.RunInstancesInput, error) {
+
+	ls, err := lc.convertLaunchConfigurationToSpotSpecification(
+		baseInstance, newInstance, conn, az)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ec2.RunInstancesInput{
+		MinCount:            aws.Int64(1),
+		MaxCount:            aws.Int64(1),
+		BlockDeviceMappings: ls.BlockDeviceMappings,
+		EbsOptimized:        ls.EbsOptimized,
+		IamInstanceProfile:  ls.IamInstanceProfile,
+		ImageId:             ls.ImageId,
+		InstanceType:        ls.InstanceType,
+		KernelId:            ls.KernelId,
+		RamdiskId:           ls.RamdiskId,
+		KeyName:             ls.KeyName,
+		Monitoring:          ls.Monitoring,
+		NetworkInterfaces:   ls.NetworkInterfaces,
+		SecurityGroupIds:    ls.SecurityGroupIds,
+		UserData:            ls.UserData,
+		Placement: &ec2.Placement{
+			AvailabilityZone: ls.Placement.AvailabilityZone,
+			Tenancy:          ls.Placement.Tenancy,
+		},
+	}, nil
+}
+
+// spotTenancy determines the tenancy of the spot instance from the launch
+// configuration, falling back to the tenancy of the base instance, which may
+// be dedicated because of the VPC settings. Spot instances can't run on
+// dedicated hosts, and we never want to replace dedicated instances with
+// instances running on shared hardware.
+func spotTenancy(lcTenancy *string, baseInstance *instance) (*string, error) {
+	var instanceTenancy *string
+
+	if baseInstance.Placement != nil {
+		instanceTenancy = baseInstance.Placement.Tenancy
+	}
+
+	for _, tenancy := range []*string{lcTenancy, instanceTenancy} {
+		if tenancy == nil {
+			continue
+		}
+		switch *tenancy {
+		case ec2.TenancyHost:
+			return nil, fmt.Errorf("spot instances can't be launched with %s tenancy", *tenancy)
+		case ec2.TenancyDedicated:
+			return aws.String(ec2.TenancyDedicated), nil
+		}
+	}
+	return nil, nil
+}
+
+// checkSpotCompatibility fails for the launch configuration fields which can't
+// be expressed in a spot launch specification, instead of silently launching
+// spot instances configured differently than the on-demand ones.
+func (lc *launchConfiguration) checkSpotCompatibility() error {
+	name := aws.StringValue(lc.LaunchConfigurationName)
+
+	if lc.ClassicLinkVPCId != nil && *lc.ClassicLinkVPCId != "" {
+		return fmt.Errorf("launch configuration %s links instances to the "+
+			"ClassicLink VPC %s, which is not supported by spot instance requests",
+			name, *lc.ClassicLinkVPCId)
+	}
+
+	if len(lc.ClassicLinkVPCSecurityGroups) > 0 {
+		return fmt.Errorf("launch configuration %s uses ClassicLink VPC security "+
+			"groups, which are not supported by spot instance requests", name)
+	}
+
+	return nil
+}
+
+// spotPriceLimit returns the maximum spot price set in the launch
+// configuration, if any, which the bids should never exceed.
+func (lc *launchConfiguration) spotPriceLimit() (float64, bool) {
+	if lc == nil || lc.SpotPrice == nil || *lc.SpotPrice == "" {
+		return 0, false
+	}
+
+	price, err := strconv.ParseFloat(*lc.SpotPrice, 64)
+	if err != nil || price <= 0 {
+		lc.logs.logger().Println("Ignoring invalid spot price", *lc.SpotPrice,
+			"of launch configuration", aws.StringValue(lc.LaunchConfigurationName))
+		return 0, false
+	}
+	return price, true
+}
+
 func copyBlockDeviceMappings(
 	lcBDMs []*autoscaling.BlockDeviceMapping) []*ec2.BlockDeviceMapping {
 
@@ -128,6 +249,15 @@ func copyBlockDeviceMappings(
 
 		ec2BDM.DeviceName = lcBDM.DeviceName
 
+		// The NoDevice flag suppresses a device defined in the AMI, in which case
+		// EC2 expects an empty string and no other device information. Any value
+		// set in EC2 suppresses the device, so nothing should be set otherwise.
+		if lcBDM.NoDevice != nil && *lcBDM.NoDevice {
+			ec2BDM.NoDevice = aws.String("")
+			ec2BDMlist = append(ec2BDMlist, &ec2BDM)
+			continue
+		}
+
 		// EBS volume information
 		if lcBDM.Ebs != nil {
 			ec2BDM.Ebs = &ec2.EbsBlockDevice{
@@ -140,12 +270,6 @@ func copyBlockDeviceMappings(
 			}
 		}
 
-		// it turns out that the noDevice field needs to be converted from bool to
-		// *string
-		if lcBDM.NoDevice != nil {
-			ec2BDM.NoDevice = aws.String(fmt.Sprintf("%t", *lcBDM.NoDevice))
-		}
-
 		ec2BDM.VirtualName = lcBDM.VirtualName
 
 		ec2BDMlist = append(ec2BDMlist, &ec2BDM)
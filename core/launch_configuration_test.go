@@ -34,13 +34,11 @@ func Test_copyBlockDeviceMappings(t *testing.T) {
 			{
 				DeviceName:  aws.String("/dev/ephemeral0"),
 				Ebs:         nil,
-				NoDevice:    aws.String("false"),
 				VirtualName: aws.String("foo"),
 			},
 			{
 				DeviceName:  aws.String("/dev/ephemeral1"),
 				Ebs:         nil,
-				NoDevice:    aws.String("false"),
 				VirtualName: aws.String("bar"),
 			},
 		},
@@ -74,7 +72,6 @@ func Test_copyBlockDeviceMappings(t *testing.T) {
 				{
 					DeviceName:  aws.String("/dev/ephemeral0"),
 					Ebs:         nil,
-					NoDevice:    aws.String("false"),
 					VirtualName: aws.String("foo"),
 				},
 				{
@@ -95,6 +92,29 @@ func Test_copyBlockDeviceMappings(t *testing.T) {
 				},
 			},
 		},
+		{name: "suppressed AMI device",
+			asbdm: []*autoscaling.BlockDeviceMapping{
+				{
+					DeviceName: aws.String("/dev/sdb"),
+					NoDevice:   aws.Bool(true),
+				},
+				{
+					DeviceName:  aws.String("/dev/sdc"),
+					NoDevice:    aws.Bool(false),
+					VirtualName: aws.String("ephemeral0"),
+				},
+			},
+			want: []*ec2.BlockDeviceMapping{
+				{
+					DeviceName: aws.String("/dev/sdb"),
+					NoDevice:   aws.String(""),
+				},
+				{
+					DeviceName:  aws.String("/dev/sdc"),
+					VirtualName: aws.String("ephemeral0"),
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -179,7 +199,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "empty everything",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{},
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{},
 			},
 			instance: &instance{
 				Instance: &ec2.Instance{},
@@ -195,7 +215,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "empty structs, but with az and instanceType",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{},
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{},
 			},
 			instance: &instance{
 				Instance: &ec2.Instance{},
@@ -214,7 +234,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "ESB optimized",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					EbsOptimized: aws.Bool(true),
 				},
 			},
@@ -244,7 +264,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "ESB optimized for free",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					EbsOptimized: aws.Bool(false),
 				},
 			},
@@ -274,7 +294,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "IAM instance profile ARN",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					IamInstanceProfile: aws.String("arn:aws:something"),
 				},
 			},
@@ -294,7 +314,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "IAM instance profile name",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					IamInstanceProfile: aws.String("bla bla bla something"),
 				},
 			},
@@ -314,7 +334,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "IAM instance profile key",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					KeyName: aws.String("key xyz"),
 				},
 			},
@@ -332,7 +352,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "instance monitoring",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					InstanceMonitoring: &autoscaling.InstanceMonitoring{
 						Enabled: aws.Bool(false),
 					},
@@ -354,7 +374,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "user data",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					UserData: aws.String("user data"),
 				},
 			},
@@ -372,7 +392,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "networking",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					AssociatePublicIpAddress: aws.Bool(true),
 				},
 			},
@@ -395,7 +415,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "classic-nonid-networking",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					SecurityGroups: aws.StringSlice([]string{"non-sgstart", "non-sg"}),
 				},
 			},
@@ -413,7 +433,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "classic-id-networking",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					SecurityGroups: aws.StringSlice([]string{"sg-12345fdd", "sg-4567fed0"}),
 				},
 			},
@@ -434,7 +454,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 			// names
 			name: "classic-fake-id-networking",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					SecurityGroups: aws.StringSlice([]string{"sg-12345", "sg-4567"}),
 				},
 			},
@@ -453,7 +473,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "classic-long-id-networking",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					SecurityGroups: aws.StringSlice([]string{"sg-123456aedf6aedf78", "sg-2671decc18123770b"}),
 				},
 			},
@@ -472,7 +492,7 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		{
 			name: "classic-mixed-networking",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					SecurityGroups: aws.StringSlice([]string{"sg-12345678", "non-sg"}),
 				},
 			},
@@ -487,10 +507,28 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 				SecurityGroupIds: aws.StringSlice([]string{"sg-12345678", "sg-non-sgde"}),
 			},
 		},
+		{
+			name: "dedicated tenancy",
+			lc: &launchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
+					PlacementTenancy: aws.String("dedicated"),
+				},
+			},
+			instance: &instance{
+				Instance: &ec2.Instance{},
+			},
+			spotRequest: &ec2.RequestSpotLaunchSpecification{
+				InstanceType: aws.String(""),
+				Placement: &ec2.SpotPlacement{
+					AvailabilityZone: aws.String(""),
+					Tenancy:          aws.String("dedicated"),
+				},
+			},
+		},
 		{
 			name: "full configuration",
 			lc: &launchConfiguration{
-				&autoscaling.LaunchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
 					AssociatePublicIpAddress: aws.Bool(true),
 					UserData:                 aws.String("user data"),
 					InstanceMonitoring: &autoscaling.InstanceMonitoring{
@@ -536,3 +574,272 @@ func Test_convertLaunchConfigurationToSpotSpecification(t *testing.T) {
 		})
 	}
 }
+
+func Test_spotTenancy(t *testing.T) {
+	tests := []struct {
+		name            string
+		lcTenancy       *string
+		instanceTenancy *string
+		want            *string
+		wantErr         bool
+	}{
+		{name: "default tenancy",
+			want: nil,
+		},
+		{name: "explicit default tenancy",
+			lcTenancy:       aws.String("default"),
+			instanceTenancy: aws.String("default"),
+			want:            nil,
+		},
+		{name: "dedicated launch configuration",
+			lcTenancy: aws.String("dedicated"),
+			want:      aws.String("dedicated"),
+		},
+		{name: "dedicated instance from a dedicated VPC",
+			lcTenancy:       aws.String("default"),
+			instanceTenancy: aws.String("dedicated"),
+			want:            aws.String("dedicated"),
+		},
+		{name: "dedicated host",
+			instanceTenancy: aws.String("host"),
+			wantErr:         true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					Placement: &ec2.Placement{Tenancy: tt.instanceTenancy},
+				},
+			}
+			got, err := spotTenancy(tt.lcTenancy, i)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("spotTenancy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spotTenancy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// launchConfigurationFromSpotSpecification converts a spot launch
+// specification back to a launch configuration, in order to check that no
+// information is lost when converting launch configurations.
+func launchConfigurationFromSpotSpecification(
+	spec *ec2.RequestSpotLaunchSpecification) *autoscaling.LaunchConfiguration {
+
+	lc := &autoscaling.LaunchConfiguration{
+		EbsOptimized: spec.EbsOptimized,
+		ImageId:      spec.ImageId,
+		InstanceType: spec.InstanceType,
+		KernelId:     spec.KernelId,
+		KeyName:      spec.KeyName,
+		RamdiskId:    spec.RamdiskId,
+		UserData:     spec.UserData,
+	}
+
+	for _, bdm := range spec.BlockDeviceMappings {
+		lcBDM := &autoscaling.BlockDeviceMapping{
+			DeviceName:  bdm.DeviceName,
+			VirtualName: bdm.VirtualName,
+		}
+		if bdm.NoDevice != nil {
+			lcBDM.NoDevice = aws.Bool(true)
+		}
+		if bdm.Ebs != nil {
+			lcBDM.Ebs = &autoscaling.Ebs{
+				DeleteOnTermination: bdm.Ebs.DeleteOnTermination,
+				Encrypted:           bdm.Ebs.Encrypted,
+				Iops:                bdm.Ebs.Iops,
+				SnapshotId:          bdm.Ebs.SnapshotId,
+				VolumeSize:          bdm.Ebs.VolumeSize,
+				VolumeType:          bdm.Ebs.VolumeType,
+			}
+		}
+		lc.BlockDeviceMappings = append(lc.BlockDeviceMappings, lcBDM)
+	}
+
+	if spec.IamInstanceProfile != nil {
+		if spec.IamInstanceProfile.Arn != nil {
+			lc.IamInstanceProfile = spec.IamInstanceProfile.Arn
+		} else {
+			lc.IamInstanceProfile = spec.IamInstanceProfile.Name
+		}
+	}
+
+	if spec.Monitoring != nil {
+		lc.InstanceMonitoring = &autoscaling.InstanceMonitoring{
+			Enabled: spec.Monitoring.Enabled,
+		}
+	}
+
+	lc.SecurityGroups = spec.SecurityGroupIds
+	for _, ni := range spec.NetworkInterfaces {
+		lc.AssociatePublicIpAddress = ni.AssociatePublicIpAddress
+		lc.SecurityGroups = ni.Groups
+	}
+
+	if spec.Placement != nil {
+		lc.PlacementTenancy = spec.Placement.Tenancy
+	}
+
+	return lc
+}
+
+func Test_convertLaunchConfigurationRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		lc       *autoscaling.LaunchConfiguration
+		instance *ec2.Instance
+		wantErr  bool
+	}{
+		{name: "EC2 Classic with instance profile name",
+			lc: &autoscaling.LaunchConfiguration{
+				ImageId:            aws.String("ami-12345678"),
+				InstanceType:       aws.String("m4.large"),
+				IamInstanceProfile: aws.String("instance-profile"),
+				KeyName:            aws.String("key"),
+				SecurityGroups:     aws.StringSlice([]string{"sg-12345678"}),
+				UserData:           aws.String("dXNlciBkYXRh"),
+				InstanceMonitoring: &autoscaling.InstanceMonitoring{
+					Enabled: aws.Bool(true),
+				},
+			},
+			instance: &ec2.Instance{},
+		},
+		{name: "VPC with public IP and instance profile ARN",
+			lc: &autoscaling.LaunchConfiguration{
+				AssociatePublicIpAddress: aws.Bool(true),
+				EbsOptimized:             aws.Bool(true),
+				ImageId:                  aws.String("ami-12345678"),
+				InstanceType:             aws.String("c5.xlarge"),
+				IamInstanceProfile:       aws.String("arn:aws:iam::123456789012:instance-profile/p"),
+				SecurityGroups:           aws.StringSlice([]string{"sg-12345678", "sg-87654321"}),
+			},
+			instance: &ec2.Instance{SubnetId: aws.String("subnet-1")},
+		},
+		{name: "paravirtual with kernel and ramdisk",
+			lc: &autoscaling.LaunchConfiguration{
+				ImageId:      aws.String("ami-12345678"),
+				InstanceType: aws.String("m1.small"),
+				KernelId:     aws.String("aki-12345678"),
+				RamdiskId:    aws.String("ari-12345678"),
+			},
+			instance: &ec2.Instance{},
+		},
+		{name: "block device mappings",
+			lc: &autoscaling.LaunchConfiguration{
+				ImageId:      aws.String("ami-12345678"),
+				InstanceType: aws.String("i3.large"),
+				BlockDeviceMappings: []*autoscaling.BlockDeviceMapping{
+					{
+						DeviceName: aws.String("/dev/xvda"),
+						Ebs: &autoscaling.Ebs{
+							DeleteOnTermination: aws.Bool(true),
+							Encrypted:           aws.Bool(true),
+							Iops:                aws.Int64(1000),
+							SnapshotId:          aws.String("snap-12345678"),
+							VolumeSize:          aws.Int64(100),
+							VolumeType:          aws.String("io1"),
+						},
+					},
+					{
+						DeviceName:  aws.String("/dev/sdb"),
+						VirtualName: aws.String("ephemeral0"),
+					},
+					{
+						DeviceName: aws.String("/dev/sdc"),
+						NoDevice:   aws.Bool(true),
+					},
+				},
+			},
+			instance: &ec2.Instance{},
+		},
+		{name: "dedicated tenancy",
+			lc: &autoscaling.LaunchConfiguration{
+				ImageId:          aws.String("ami-12345678"),
+				InstanceType:     aws.String("m4.large"),
+				PlacementTenancy: aws.String("dedicated"),
+			},
+			instance: &ec2.Instance{SubnetId: aws.String("subnet-1")},
+		},
+		{name: "ClassicLink VPC",
+			lc: &autoscaling.LaunchConfiguration{
+				ImageId:                      aws.String("ami-12345678"),
+				InstanceType:                 aws.String("m4.large"),
+				ClassicLinkVPCId:             aws.String("vpc-12345678"),
+				ClassicLinkVPCSecurityGroups: aws.StringSlice([]string{"sg-12345678"}),
+			},
+			instance: &ec2.Instance{},
+			wantErr:  true,
+		},
+		{name: "host tenancy",
+			lc: &autoscaling.LaunchConfiguration{
+				ImageId:          aws.String("ami-12345678"),
+				InstanceType:     aws.String("m4.large"),
+				PlacementTenancy: aws.String("host"),
+			},
+			instance: &ec2.Instance{},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := &launchConfiguration{LaunchConfiguration: tt.lc}
+			spec, err := lc.convertLaunchConfigurationToSpotSpecification(
+				&instance{Instance: tt.instance},
+				instanceTypeInformation{instanceType: *tt.lc.InstanceType},
+				&connections{ec2: &mockEC2{}},
+				"us-east-1a")
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("convertLaunchConfigurationToSpotSpecification() error = %v, wantErr %v",
+					err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := launchConfigurationFromSpotSpecification(spec); !reflect.DeepEqual(got, tt.lc) {
+				t.Errorf("round trip of %+v resulted in %+v", tt.lc, got)
+			}
+		})
+	}
+}
+
+func Test_spotPriceLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		spotPrice *string
+		want      float64
+		wantOK    bool
+	}{
+		{name: "no spot price",
+			wantOK: false,
+		},
+		{name: "valid spot price",
+			spotPrice: aws.String("0.05"),
+			want:      0.05,
+			wantOK:    true,
+		},
+		{name: "invalid spot price",
+			spotPrice: aws.String("cheap"),
+			wantOK:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lc := &launchConfiguration{
+				LaunchConfiguration: &autoscaling.LaunchConfiguration{
+					SpotPrice: tt.spotPrice,
+				},
+			}
+			got, ok := lc.spotPriceLimit()
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("spotPriceLimit() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
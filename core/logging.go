@@ -0,0 +1,74 @@
+package autospotting
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// loggers are the loggers of the normal and of the debug messages of an
+// Engine, or of the processing of a region. Nil loggers fall back to the ones
+// of the process, set up by the runs.
+type loggers struct {
+	out      *log.Logger
+	debugOut *log.Logger
+}
+
+// newLoggers creates the loggers configured by the Logger or by the LogFile
+// and LogFlag settings, writing to stderr when neither is set. The debug
+// messages are only logged when the AUTOSPOTTING_DEBUG environment variable is
+// set to true.
+func newLoggers(cfg *Config) *loggers {
+	l := &loggers{out: cfg.Logger}
+
+	if l.out == nil {
+		w := cfg.LogFile
+		if w == nil {
+			w = os.Stderr
+		}
+		l.out = log.New(w, "", cfg.LogFlag)
+	}
+
+	if os.Getenv("AUTOSPOTTING_DEBUG") == "true" {
+		l.debugOut = l.out
+	} else {
+		l.debugOut = log.New(ioutil.Discard, "", 0)
+	}
+	return l
+}
+
+func (l *loggers) logger() *log.Logger {
+	if l == nil {
+		return logger
+	}
+	return l.out
+}
+
+func (l *loggers) debugLogger() *log.Logger {
+	if l == nil {
+		return debug
+	}
+	return l.debugOut
+}
+
+// withPrefix returns loggers adding a prefix to the messages before passing
+// them on, leaving the current loggers unchanged.
+func (l *loggers) withPrefix(prefix string) *loggers {
+	return &loggers{
+		out:      log.New(loggerWriter{l.logger()}, prefix, 0),
+		debugOut: log.New(loggerWriter{l.debugLogger()}, prefix, 0),
+	}
+}
+
+// loggerWriter passes the messages written by a logger to another one, which
+// adds its own prefix and flags.
+type loggerWriter struct {
+	l *log.Logger
+}
+
+func (w loggerWriter) Write(p []byte) (int, error) {
+	// skipping the frames of the writing logger, so the file name flags
+	// point to the code logging the message
+	err := w.l.Output(4, string(p))
+	return len(p), err
+}
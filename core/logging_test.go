@@ -0,0 +1,22 @@
+package autospotting
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func Test_loggersWithPrefix(t *testing.T) {
+	var buf bytes.Buffer
+	l := log.New(&buf, "main ", 0)
+
+	logs := newLoggers(&Config{Logger: l}).withPrefix("123456789012 ")
+	logs.logger().Println("Processing region")
+
+	if l.Prefix() != "main " {
+		t.Errorf("withPrefix() changed the prefix of the logger to %q", l.Prefix())
+	}
+	if want := "main 123456789012 Processing region\n"; buf.String() != want {
+		t.Errorf("withPrefix() logged %q, want %q", buf.String(), want)
+	}
+}
@@ -1,6 +1,7 @@
 package autospotting
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 	"os"
@@ -13,31 +14,159 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
-var logger, debug *log.Logger
+// the loggers of the process, used when not processing a region, such as by
+// the commands, and by the regions processed without their own loggers
+var (
+	logger = log.New(os.Stderr, "", log.LstdFlags)
+	debug  = log.New(ioutil.Discard, "", 0)
+)
 
 // Run starts processing all AWS regions looking for AutoScaling groups
 // enabled and taking action by replacing more pricy on-demand instances with
 // compatible and cheaper spot instances.
-func Run(cfg *Config) {
+func Run(cfg *Config) (*RunResult, error) {
+	return RunContext(context.Background(), cfg)
+}
+
+// RunContext is like Run, but stops starting new instance replacements when
+// the context is cancelled or its deadline, such as the one of the Lambda
+// function invocation, is getting close. The run is also limited by the
+// MaxRuntime configuration, if set.
+//
+// The result describes the processed regions and groups, and the error
+// aggregates all the failures as a *RunError.
+func RunContext(ctx context.Context, cfg *Config) (*RunResult, error) {
 
 	setupLogging(cfg)
 
-	debug.Println(*cfg)
+	cfg.logs.debugLogger().Println(*cfg)
 
-	// use this only to list all the other regions
-	ec2Conn := connectEC2(cfg.MainRegion)
+	if cfg.MaxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MaxRuntime)
+		defer cancel()
+	}
+
+	cfg.status.runStarted()
+	defer cfg.status.runFinished()
+
+	prepareRun(cfg)
+
+	// each call has its own report, the configuration being shared by the
+	// concurrent runs
+	report := newRunReport(cfg.clock().Now())
+
+	err := forEachRegion(ctx, cfg, report, func(r *region) {
+		report.addRegion(r.services.account, r.name)
+		r.engine().ProcessRegion(ctx)
+	})
+	if err != nil {
+		cfg.logs.logger().Println("Couldn't determine the accounts to be processed:", err.Error())
+		report.addError("", "", err)
+	}
+
+	result := report.result(cfg.clock().Now())
+	return result, result.Err()
+}
+
+// forEachRegion processes the enabled regions of all the accounts using the
+// given function, in parallel within each account. The failures are added to
+// the report of the run, if any.
+func forEachRegion(ctx context.Context, cfg *Config, report *runReport,
+	process func(*region)) error {
+
+	accounts, err := accounts(cfg)
+	if err != nil {
+		return err
+	}
+
+	if len(accounts) == 0 {
+		processAccount(ctx, account{}, cfg, report, process)
+		return nil
+	}
+
+	for _, a := range accounts {
+		if ctx.Err() != nil {
+			cfg.logs.logger().Println("Deadline reached, not processing account", a.id)
+			continue
+		}
+
+		cfg.logs.logger().Println("Processing account", a.id)
+		processAccount(ctx, a, cfg, report, process)
+	}
+	return nil
+}
+
+// prepareRun loads the data and sets up the state used by the runs, keeping
+// the state already set up by the previous runs.
+func prepareRun(cfg *Config) {
+
+	// set up first, since it applies to the sessions created afterwards
+	if cfg.apiTraffic == nil {
+		setupAPITraffic(cfg)
+	}
+
+	if err := loadInstanceData(cfg); err != nil {
+		cfg.logs.logger().Println("Couldn't load the instance data, using the bundled data:",
+			err.Error())
+	}
+
+	if err := loadInterruptionData(cfg); err != nil {
+		cfg.logs.logger().Println("Couldn't load the interruption data:", err.Error())
+	}
 
 	addDefaultFilter(cfg)
 
-	allRegions, err := getRegions(ec2Conn)
+	if cfg.spotPriceCache == nil {
+		cfg.spotPriceCache = newSpotPriceCache()
+	}
+
+	if cfg.apiLimiter == nil {
+		cfg.apiLimiter = newRateLimiter(cfg.APIRequestsPerSecond)
+	}
+
+	if cfg.groupPool == nil {
+		cfg.groupPool = newWorkerPool(cfg.MaxConcurrentGroups)
+	}
+
+	if cfg.sessions == nil {
+		cfg.sessions = newSessionCache()
+	}
+
+	if cfg.LiveOnDemandPrices && cfg.priceProvider == nil {
+		var err error
+		cfg.priceProvider, err = newOnDemandPriceProvider(cfg)
+		if err != nil {
+			cfg.logs.logger().Println("Couldn't set up the on-demand price provider, using the "+
+				"bundled prices:", err.Error())
+		}
+	}
+}
+
+// processAccount processes all the regions of an account, which is the
+// current one when no session was created for it.
+func processAccount(ctx context.Context, a account, cfg *Config,
+	report *runReport, process func(*region)) {
+
+	logs := accountLoggers(cfg, a)
 
+	sess, err := regionSession(cfg, a, cfg.MainRegion)
 	if err != nil {
-		logger.Println(err.Error())
+		logs.logger().Println(err.Error())
+		report.addError(a.id, "", err)
 		return
 	}
 
-	processRegions(allRegions, cfg)
+	// use this only to list all the other regions
+	allRegions, err := getRegions(ec2.New(sess), logs)
+
+	if err != nil {
+		logs.logger().Println(err.Error())
+		report.addError(a.id, "", err)
+		return
+	}
 
+	processRegions(ctx, allRegions, cfg, a, report, process)
 }
 
 func addDefaultFilter(cfg *Config) {
@@ -51,36 +180,44 @@ func disableLogging() {
 }
 
 func setupLogging(cfg *Config) {
-	logger = log.New(cfg.LogFile, "", cfg.LogFlag)
-
-	if os.Getenv("AUTOSPOTTING_DEBUG") == "true" {
-		debug = log.New(cfg.LogFile, "", cfg.LogFlag)
-	} else {
-		debug = log.New(ioutil.Discard, "", 0)
-	}
-
+	l := newLoggers(cfg)
+	logger, debug = l.out, l.debugOut
 }
 
 // processAllRegions iterates all regions in parallel, and replaces instances
 // for each of the ASGs tagged with tags as specifed by slice represented by cfg.FilterByTags
 // by default this is all asg with the tag 'spot-enabled=true'.
-func processRegions(regions []string, cfg *Config) {
+func processRegions(ctx context.Context, regions []string, cfg *Config,
+	a account, report *runReport, process func(*region)) {
 
 	var wg sync.WaitGroup
+	workers := newWorkerPool(cfg.MaxConcurrentRegions)
 
-	for _, r := range regions {
+	for _, name := range regions {
 
 		wg.Add(1)
-		r := region{name: r, conf: cfg}
+		r, err := newRegion(ctx, cfg, a, name, cfg.priceProvider)
+		if err != nil {
+			accountLoggers(cfg, a).logger().Println(name, "Couldn't create a session:",
+				err.Error())
+			report.addError(a.id, name, err)
+			wg.Done()
+			continue
+		}
+		r.report = report
 
 		go func() {
-
-			if r.enabled() {
-				logger.Printf("Enabled to run in %s, processing region.\n", r.name)
-				r.processRegion()
+			workers.acquire()
+			defer workers.release()
+
+			if ctx.Err() != nil {
+				r.logger().Println("Deadline reached, not processing", r.name)
+			} else if r.enabled() {
+				r.logger().Printf("Enabled to run in %s, processing region.\n", r.name)
+				process(r)
 			} else {
-				debug.Println("Not enabled to run in", r.name)
-				debug.Println("List of enabled regions:", cfg.Regions)
+				r.debugLogger().Println("Not enabled to run in", r.name)
+				r.debugLogger().Println("List of enabled regions:", cfg.Regions)
 			}
 
 			wg.Done()
@@ -89,36 +226,65 @@ func processRegions(regions []string, cfg *Config) {
 	wg.Wait()
 }
 
-func connectEC2(region string) *ec2.EC2 {
+// newRegion sets up the processing of a region of an account.
+func newRegion(ctx context.Context, cfg *Config, a account, name string,
+	priceProvider *onDemandPriceProvider) (*region, error) {
+
+	r := &region{name: name, conf: cfg, ctx: ctx, priceProvider: priceProvider}
 
-	sess, err := session.NewSession()
+	r.services.account = a.id
+	r.services.logs = accountLoggers(cfg, a)
+	r.services.limiter = cfg.apiLimiter
+	r.services.maxRetries = cfg.APIMaxRetries
+
+	sess, err := regionSession(cfg, a, name)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	r.services.session = sess
+
+	return r, nil
+}
+
+// accountLoggers returns the loggers used when processing an account, which
+// attribute the messages to the account when processing multiple accounts.
+func accountLoggers(cfg *Config, a account) *loggers {
+	if a.id == "" {
+		return cfg.logs
 	}
+	return cfg.logs.withPrefix(a.id + " ")
+}
 
-	return ec2.New(sess,
-		aws.NewConfig().WithRegion(region))
+// regionSession returns the session used in a region of an account, which is
+// reused across runs.
+func regionSession(cfg *Config, a account, region string) (*session.Session, error) {
+	return cfg.sessions.get(a.id+"/"+region, func() (*session.Session, error) {
+		if a.session != nil {
+			return a.session.Copy(&aws.Config{Region: aws.String(region)}), nil
+		}
+		return newSession(cfg, &aws.Config{Region: aws.String(region)})
+	})
 }
 
 // getRegions generates a list of AWS regions.
-func getRegions(ec2conn ec2iface.EC2API) ([]string, error) {
+func getRegions(ec2conn ec2iface.EC2API, logs *loggers) ([]string, error) {
 	var output []string
 
-	logger.Println("Scanning for available AWS regions")
+	logs.logger().Println("Scanning for available AWS regions")
 
 	resp, err := ec2conn.DescribeRegions(&ec2.DescribeRegionsInput{})
 
 	if err != nil {
-		logger.Println(err.Error())
+		logs.logger().Println(err.Error())
 		return nil, err
 	}
 
-	debug.Println(resp)
+	logs.debugLogger().Println(resp)
 
 	for _, r := range resp.Regions {
 
 		if r != nil && r.RegionName != nil {
-			debug.Println("Found region", *r.RegionName)
+			logs.debugLogger().Println("Found region", *r.RegionName)
 			output = append(output, *r.RegionName)
 		}
 	}
@@ -48,7 +48,7 @@ func Test_getRegions(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			got, err := getRegions(tt.ec2conn)
+			got, err := getRegions(tt.ec2conn, nil)
 			CheckErrors(t, err, tt.wantErr)
 
 			if !reflect.DeepEqual(got, tt.want) {
@@ -6,10 +6,15 @@ import (
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
 )
 
 func CheckErrors(t *testing.T, err error, expected error) {
@@ -39,6 +44,11 @@ type mockEC2 struct {
 	dspho   *ec2.DescribeSpotPriceHistoryOutput
 	dspherr error
 
+	// Describe Spot Price History Pages, using dspho if not set, recording
+	// the inputs if dsphpi is set
+	dsphpo []*ec2.DescribeSpotPriceHistoryOutput
+	dsphpi *[]*ec2.DescribeSpotPriceHistoryInput
+
 	// Error in DescribeInstancesPages
 	diperr error
 
@@ -61,6 +71,30 @@ type mockEC2 struct {
 	// Cancel Spot instance request
 	csiro   *ec2.CancelSpotInstanceRequestsOutput
 	csirerr error
+
+	// Describe Instance Credit Specifications
+	dicso   *ec2.DescribeInstanceCreditSpecificationsOutput
+	dicserr error
+
+	// Modify Instance Credit Specification
+	micso   *ec2.ModifyInstanceCreditSpecificationOutput
+	micserr error
+
+	// Describe Images
+	dimo   *ec2.DescribeImagesOutput
+	dimerr error
+
+	// Describe Reserved Instances
+	drio   *ec2.DescribeReservedInstancesOutput
+	drierr error
+
+	// Run Instances, recording the inputs if rii is set
+	rio   *ec2.Reservation
+	rierr error
+	rii   *[]*ec2.RunInstancesInput
+
+	// Wait Until Instance Running
+	wuirerr error
 }
 
 func (m mockEC2) CreateTags(in *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
@@ -71,6 +105,13 @@ func (m mockEC2) WaitUntilSpotInstanceRequestFulfilled(in *ec2.DescribeSpotInsta
 	return m.wusirferr
 }
 
+func (m mockEC2) WaitUntilSpotInstanceRequestFulfilledWithContext(ctx aws.Context, in *ec2.DescribeSpotInstanceRequestsInput, opts ...request.WaiterOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.wusirferr
+}
+
 func (m mockEC2) DescribeSpotInstanceRequests(in *ec2.DescribeSpotInstanceRequestsInput) (*ec2.DescribeSpotInstanceRequestsOutput, error) {
 	return m.dsiro, m.dsirerr
 }
@@ -79,6 +120,25 @@ func (m mockEC2) DescribeSpotPriceHistory(in *ec2.DescribeSpotPriceHistoryInput)
 	return m.dspho, m.dspherr
 }
 
+func (m mockEC2) DescribeSpotPriceHistoryPages(in *ec2.DescribeSpotPriceHistoryInput, fn func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool) error {
+	if m.dsphpi != nil {
+		*m.dsphpi = append(*m.dsphpi, in)
+	}
+	if m.dspherr != nil {
+		return m.dspherr
+	}
+	pages := m.dsphpo
+	if pages == nil {
+		pages = []*ec2.DescribeSpotPriceHistoryOutput{m.dspho}
+	}
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
 func (m mockEC2) DescribeInstancesPages(in *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
 	return m.diperr
 }
@@ -95,6 +155,36 @@ func (m mockEC2) CancelSpotInstanceRequests(*ec2.CancelSpotInstanceRequestsInput
 	return m.csiro, m.csirerr
 }
 
+func (m mockEC2) DescribeInstanceCreditSpecifications(*ec2.DescribeInstanceCreditSpecificationsInput) (*ec2.DescribeInstanceCreditSpecificationsOutput, error) {
+	return m.dicso, m.dicserr
+}
+
+func (m mockEC2) ModifyInstanceCreditSpecification(*ec2.ModifyInstanceCreditSpecificationInput) (*ec2.ModifyInstanceCreditSpecificationOutput, error) {
+	return m.micso, m.micserr
+}
+
+func (m mockEC2) DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return m.dimo, m.dimerr
+}
+
+func (m mockEC2) DescribeReservedInstances(*ec2.DescribeReservedInstancesInput) (*ec2.DescribeReservedInstancesOutput, error) {
+	return m.drio, m.drierr
+}
+
+func (m mockEC2) RunInstances(in *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	if m.rii != nil {
+		*m.rii = append(*m.rii, in)
+	}
+	return m.rio, m.rierr
+}
+
+func (m mockEC2) WaitUntilInstanceRunningWithContext(ctx aws.Context, in *ec2.DescribeInstancesInput, opts ...request.WaiterOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.wuirerr
+}
+
 func (m mockEC2) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
 	return m.dro, m.drerr
 }
@@ -188,3 +278,35 @@ func (m mockASG) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoS
 	function(m.dasgo, true)
 	return nil
 }
+
+// All fields are composed of the abbreviation of their method
+type mockPricing struct {
+	pricingiface.PricingAPI
+	// Get Products
+	gpo   *pricing.GetProductsOutput
+	gperr error
+}
+
+func (m mockPricing) GetProductsPages(input *pricing.GetProductsInput, function func(*pricing.GetProductsOutput, bool) bool) error {
+	if m.gperr != nil {
+		return m.gperr
+	}
+	function(m.gpo, true)
+	return nil
+}
+
+// All fields are composed of the abbreviation of their method
+type mockOrganizations struct {
+	organizationsiface.OrganizationsAPI
+	// List Accounts
+	lao   *organizations.ListAccountsOutput
+	laerr error
+}
+
+func (m mockOrganizations) ListAccountsPages(input *organizations.ListAccountsInput, function func(*organizations.ListAccountsOutput, bool) bool) error {
+	if m.laerr != nil {
+		return m.laerr
+	}
+	function(m.lao, true)
+	return nil
+}
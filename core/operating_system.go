@@ -0,0 +1,127 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The operating system names used in the ec2instances.info pricing data
+const (
+	linuxPricing   = "linux"
+	susePricing    = "sles"
+	rhelPricing    = "rhel"
+	windowsPricing = "mswin"
+)
+
+// The spot product descriptions of the supported operating systems, without
+// the " (Amazon VPC)" suffix used for instances running in a VPC.
+const (
+	linuxProduct   = "Linux/UNIX"
+	suseProduct    = "SUSE Linux"
+	rhelProduct    = "Red Hat Enterprise Linux"
+	windowsProduct = "Windows"
+
+	vpcProductSuffix = " (Amazon VPC)"
+)
+
+// productPricing maps the spot product descriptions to the operating system
+// names used in the pricing data.
+var productPricing = map[string]string{
+	linuxProduct:   linuxPricing,
+	suseProduct:    susePricing,
+	rhelProduct:    rhelPricing,
+	windowsProduct: windowsPricing,
+}
+
+// OSPrices stores the on-demand prices of all the operating systems available
+// in the instance data. The keys are the instance type, region and operating
+// system name as used by ec2instances.info, for example "mswin" for Windows.
+type OSPrices map[string]map[string]map[string]float64
+
+// ParseOSPrices reads the on-demand prices of all operating systems from
+// instance data in the ec2instances.info JSON format.
+func ParseOSPrices(raw []byte) (OSPrices, error) {
+	var instances []struct {
+		InstanceType string                                `json:"instance_type"`
+		Pricing      map[string]map[string]json.RawMessage `json:"pricing"`
+	}
+
+	if err := json.Unmarshal(raw, &instances); err != nil {
+		return nil, err
+	}
+
+	prices := make(OSPrices)
+
+	for _, it := range instances {
+		regions := make(map[string]map[string]float64)
+
+		for region, osPricing := range it.Pricing {
+			regions[region] = make(map[string]float64)
+
+			for os, rawPrice := range osPricing {
+				var p struct {
+					OnDemand string `json:"ondemand"`
+				}
+
+				// some keys such as the EBS surcharge are not operating systems
+				if err := json.Unmarshal(rawPrice, &p); err != nil {
+					continue
+				}
+
+				if price, err := strconv.ParseFloat(p.OnDemand, 64); err == nil {
+					regions[region][os] = price
+				}
+			}
+		}
+		prices[it.InstanceType] = regions
+	}
+	return prices, nil
+}
+
+// onDemand returns the on-demand price of an instance type in a region for an
+// operating system, and whether it was found in the instance data.
+func (p OSPrices) onDemand(instanceType, region, os string) (float64, bool) {
+	price, ok := p[instanceType][region][os]
+	return price, ok
+}
+
+// pricingOS returns the operating system name used in the pricing data for a
+// given spot product description, defaulting to Linux.
+func pricingOS(product string) string {
+	if os, ok := productPricing[strings.TrimSuffix(product, vpcProductSuffix)]; ok {
+		return os
+	}
+	return linuxPricing
+}
+
+func isValidSpotProductDescription(product string) bool {
+	_, ok := productPricing[strings.TrimSuffix(product, vpcProductSuffix)]
+	return ok
+}
+
+// imageProduct determines the spot product description matching the operating
+// system of an AMI, without the VPC suffix.
+func imageProduct(image *ec2.Image) string {
+	if image.Platform != nil && strings.EqualFold(*image.Platform, "windows") {
+		return windowsProduct
+	}
+
+	var name string
+	if image.Name != nil {
+		name = strings.ToLower(*image.Name)
+	}
+	if image.Description != nil {
+		name += " " + strings.ToLower(*image.Description)
+	}
+
+	switch {
+	case strings.Contains(name, "suse") || strings.Contains(name, "sles"):
+		return suseProduct
+	case strings.Contains(name, "rhel") || strings.Contains(name, "red hat"):
+		return rhelProduct
+	}
+	return linuxProduct
+}
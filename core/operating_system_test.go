@@ -0,0 +1,111 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestParseOSPrices(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    OSPrices
+		wantErr bool
+	}{
+		{name: "multiple operating systems",
+			raw: `[{
+				"instance_type": "m1.small",
+				"pricing": {
+					"us-east-1": {
+						"linux": {"ondemand": "0.044"},
+						"mswin": {"ondemand": "0.075", "reserved": {"yrTerm1Standard.allUpfront": "0.05"}},
+						"ebs": "0.05"
+					}
+				}
+			}]`,
+			want: OSPrices{
+				"m1.small": {
+					"us-east-1": {
+						"linux": 0.044,
+						"mswin": 0.075,
+					},
+				},
+			},
+		},
+		{name: "invalid JSON",
+			raw:     `{`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOSPrices([]byte(tt.raw))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseOSPrices() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOSPrices() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPricingOS(t *testing.T) {
+	tests := []struct {
+		product string
+		want    string
+	}{
+		{product: "Linux/UNIX", want: "linux"},
+		{product: "Linux/UNIX (Amazon VPC)", want: "linux"},
+		{product: "Windows (Amazon VPC)", want: "mswin"},
+		{product: "SUSE Linux", want: "sles"},
+		{product: "Red Hat Enterprise Linux (Amazon VPC)", want: "rhel"},
+		{product: "", want: "linux"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.product, func(t *testing.T) {
+			if got := pricingOS(tt.product); got != tt.want {
+				t.Errorf("pricingOS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageProduct(t *testing.T) {
+	tests := []struct {
+		name  string
+		image *ec2.Image
+		want  string
+	}{
+		{name: "windows platform",
+			image: &ec2.Image{Platform: aws.String("windows")},
+			want:  "Windows",
+		},
+		{name: "SUSE image name",
+			image: &ec2.Image{Name: aws.String("suse-sles-12-sp3-v20180104-hvm-ssd-x86_64")},
+			want:  "SUSE Linux",
+		},
+		{name: "RHEL image description",
+			image: &ec2.Image{
+				Name:        aws.String("RHEL-7.5_HVM_GA-20180322-x86_64-1-Hourly2-GP2"),
+				Description: aws.String("Provided by Red Hat, Inc."),
+			},
+			want: "Red Hat Enterprise Linux",
+		},
+		{name: "other Linux",
+			image: &ec2.Image{Name: aws.String("amzn-ami-hvm-2018.03.0.20180508-x86_64-gp2")},
+			want:  "Linux/UNIX",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := imageProduct(tt.image); got != tt.want {
+				t.Errorf("imageProduct() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
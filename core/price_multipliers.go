@@ -0,0 +1,122 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PriceMultiplier applies an on-demand price multiplier, such as a negotiated
+// discount, to the instance types matching a glob in the regions matching
+// another glob.
+type PriceMultiplier struct {
+	Region       string  `json:"region"`
+	InstanceType string  `json:"instance_type"`
+	Multiplier   float64 `json:"multiplier"`
+}
+
+// PriceMultipliers is a discount table, where the first matching entry wins.
+type PriceMultipliers []PriceMultiplier
+
+// ParsePriceMultipliers parses a comma or whitespace separated list of
+// entries in the region:instance_type=multiplier format, both sides supporting
+// globs. The region can be omitted to match all regions, for example
+// "eu-*:m5.*=0.8,c5.*=0.9".
+func ParsePriceMultipliers(list string) (PriceMultipliers, error) {
+	var multipliers PriceMultipliers
+
+	for _, entry := range strings.Fields(strings.Replace(list, ",", " ", -1)) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid price multiplier %s, expected "+
+				"region:instance_type=multiplier", entry)
+		}
+
+		m := PriceMultiplier{Region: "*", InstanceType: parts[0]}
+		if selector := strings.SplitN(parts[0], ":", 2); len(selector) == 2 {
+			m.Region, m.InstanceType = selector[0], selector[1]
+		}
+
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price multiplier %s: %s", entry, err.Error())
+		}
+		m.Multiplier = value
+
+		if err := m.validate(); err != nil {
+			return nil, err
+		}
+		multipliers = append(multipliers, m)
+	}
+	return multipliers, nil
+}
+
+// LoadPriceMultipliers reads a discount table from a JSON file containing a
+// list of objects with the region, instance_type and multiplier attributes.
+func LoadPriceMultipliers(path string) (PriceMultipliers, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var multipliers PriceMultipliers
+	if err := json.Unmarshal(raw, &multipliers); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", path, err.Error())
+	}
+
+	for i := range multipliers {
+		if multipliers[i].Region == "" {
+			multipliers[i].Region = "*"
+		}
+		if multipliers[i].InstanceType == "" {
+			multipliers[i].InstanceType = "*"
+		}
+		if err := multipliers[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	return multipliers, nil
+}
+
+func (m PriceMultiplier) validate() error {
+	if m.Multiplier <= 0 {
+		return fmt.Errorf("the price multiplier for %s:%s must be positive",
+			m.Region, m.InstanceType)
+	}
+
+	for _, pattern := range []string{m.Region, m.InstanceType} {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %s", pattern, err.Error())
+		}
+	}
+	return nil
+}
+
+// lookup returns the multiplier of the first entry matching the region and
+// instance type.
+func (p PriceMultipliers) lookup(region, instanceType string) (float64, bool) {
+	for _, m := range p {
+		regionMatch, _ := filepath.Match(m.Region, region)
+		typeMatch, _ := filepath.Match(m.InstanceType, instanceType)
+		if regionMatch && typeMatch {
+			return m.Multiplier, true
+		}
+	}
+	return 0, false
+}
+
+// onDemandPriceMultiplier returns the multiplier applied to the on-demand
+// price of an instance type in this region, defaulting to the global one.
+func (r *region) onDemandPriceMultiplier(cfg *Config, instanceType string) float64 {
+	multiplier, ok := cfg.OnDemandPriceMultipliers.lookup(r.name, instanceType)
+	if !ok {
+		multiplier = cfg.OnDemandPriceMultiplier
+	}
+
+	r.debugLogger().Println(r.name, "Effective on-demand price multiplier for",
+		instanceType, "is", multiplier)
+	return multiplier
+}
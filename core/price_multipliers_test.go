@@ -0,0 +1,153 @@
+package autospotting
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/cristim/ec2-instances-info"
+)
+
+func TestParsePriceMultipliers(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    PriceMultipliers
+		wantErr bool
+	}{
+		{name: "empty list",
+			list: "",
+			want: nil,
+		},
+		{name: "region and instance type globs",
+			list: "eu-*:m5.*=0.8, c5.*=0.9",
+			want: PriceMultipliers{
+				{Region: "eu-*", InstanceType: "m5.*", Multiplier: 0.8},
+				{Region: "*", InstanceType: "c5.*", Multiplier: 0.9},
+			},
+		},
+		{name: "missing multiplier",
+			list:    "eu-*:m5.*",
+			wantErr: true,
+		},
+		{name: "invalid multiplier",
+			list:    "m5.*=foo",
+			wantErr: true,
+		},
+		{name: "negative multiplier",
+			list:    "m5.*=-1",
+			wantErr: true,
+		},
+		{name: "invalid glob",
+			list:    "[eu:m5.*=0.8",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePriceMultipliers(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePriceMultipliers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePriceMultipliers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPriceMultipliers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "multipliers.json")
+	ioutil.WriteFile(path, []byte(`[
+		{"region": "eu-*", "instance_type": "m5.*", "multiplier": 0.8},
+		{"instance_type": "c5.*", "multiplier": 0.9}
+	]`), 0644)
+
+	got, err := LoadPriceMultipliers(path)
+	if err != nil {
+		t.Fatalf("LoadPriceMultipliers() error = %v", err)
+	}
+
+	want := PriceMultipliers{
+		{Region: "eu-*", InstanceType: "m5.*", Multiplier: 0.8},
+		{Region: "*", InstanceType: "c5.*", Multiplier: 0.9},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadPriceMultipliers() = %v, want %v", got, want)
+	}
+}
+
+func TestRegionalOnDemandPriceMultipliers(t *testing.T) {
+	multipliers := PriceMultipliers{
+		{Region: "eu-*", InstanceType: "m1.*", Multiplier: 0.8},
+		{Region: "*", InstanceType: "m1.small", Multiplier: 0.9},
+	}
+
+	tests := []struct {
+		region string
+		want   float64
+	}{
+		{region: "eu-west-1", want: 0.04},
+		{region: "us-east-1", want: 0.045},
+	}
+	for _, tt := range tests {
+		t.Run(tt.region, func(t *testing.T) {
+			cfg := &Config{
+				InstanceData: &ec2instancesinfo.InstanceData{
+					0: {
+						InstanceType: "m1.small",
+						Pricing: map[string]ec2instancesinfo.RegionPrices{
+							tt.region: {
+								Linux: ec2instancesinfo.LinuxPricing{
+									OnDemand: 0.05,
+								},
+							},
+						},
+					},
+					1: {
+						InstanceType: "c1.medium",
+						Pricing: map[string]ec2instancesinfo.RegionPrices{
+							tt.region: {
+								Linux: ec2instancesinfo.LinuxPricing{
+									OnDemand: 0.1,
+								},
+							},
+						},
+					},
+				},
+				OnDemandPriceMultiplier:  0.5,
+				OnDemandPriceMultipliers: multipliers,
+			}
+			r := region{
+				name: tt.region,
+				conf: cfg,
+				services: connections{
+					ec2: mockEC2{
+						dspho: &ec2.DescribeSpotPriceHistoryOutput{
+							SpotPriceHistory: []*ec2.SpotPrice{},
+						},
+					},
+				}}
+			r.determineInstanceTypeInformation(cfg)
+
+			if got := r.instanceTypeInformation["m1.small"].pricing.onDemand; math.Abs(got-tt.want) > 0.000001 {
+				t.Errorf("m1.small pricing.onDemand = %.5f, want %.5f", got, tt.want)
+			}
+
+			// the global multiplier is used when no entry matches
+			if got := r.instanceTypeInformation["c1.medium"].pricing.onDemand; math.Abs(got-0.05) > 0.000001 {
+				t.Errorf("c1.medium pricing.onDemand = %.5f, want 0.05", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// pricingAPIRegion is one of the few regions where the AWS Pricing API is
+// available, it serves the prices of all the other regions.
+const pricingAPIRegion = "us-east-1"
+
+// DefaultPriceCacheTTL is how long the on-demand prices fetched from the
+// Pricing API are reused before querying the API again.
+const DefaultPriceCacheTTL = 24 * time.Hour
+
+// pricingLocations maps the region names to the location names used by the
+// Pricing API.
+var pricingLocations = map[string]string{
+	"ap-northeast-1": "Asia Pacific (Tokyo)",
+	"ap-northeast-2": "Asia Pacific (Seoul)",
+	"ap-northeast-3": "Asia Pacific (Osaka-Local)",
+	"ap-south-1":     "Asia Pacific (Mumbai)",
+	"ap-southeast-1": "Asia Pacific (Singapore)",
+	"ap-southeast-2": "Asia Pacific (Sydney)",
+	"ca-central-1":   "Canada (Central)",
+	"eu-central-1":   "EU (Frankfurt)",
+	"eu-west-1":      "EU (Ireland)",
+	"eu-west-2":      "EU (London)",
+	"eu-west-3":      "EU (Paris)",
+	"sa-east-1":      "South America (Sao Paulo)",
+	"us-east-1":      "US East (N. Virginia)",
+	"us-east-2":      "US East (Ohio)",
+	"us-gov-west-1":  "AWS GovCloud (US)",
+	"us-west-1":      "US West (N. California)",
+	"us-west-2":      "US West (Oregon)",
+}
+
+// pricingOperatingSystems maps the operating system names used in the
+// ec2instances.info data to the ones used by the Pricing API.
+var pricingOperatingSystems = map[string]string{
+	linuxPricing:   "Linux",
+	susePricing:    "SUSE",
+	rhelPricing:    "RHEL",
+	windowsPricing: "Windows",
+}
+
+// livePrice stores the on-demand price of an instance type as returned by the
+// Pricing API, together with the hardware specs needed for instance types
+// missing from the bundled instance data.
+type livePrice struct {
+	InstanceType      string  `json:"instance_type"`
+	OnDemand          float64 `json:"ondemand"`
+	VCPU              int     `json:"vcpu"`
+	Memory            float32 `json:"memory"`
+	GPU               int     `json:"gpu"`
+	Category          string  `json:"family"`
+	CurrentGeneration bool    `json:"current_generation"`
+}
+
+// priceListItem is the subset of a Pricing API price list entry we need.
+type priceListItem struct {
+	Product struct {
+		Attributes map[string]string `json:"attributes"`
+	} `json:"product"`
+	Terms struct {
+		OnDemand map[string]struct {
+			PriceDimensions map[string]struct {
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+// priceCache persists the prices fetched from the Pricing API between runs.
+type priceCache interface {
+	load(name string) ([]byte, time.Time, error)
+	save(name string, data []byte) error
+}
+
+// fileCache stores the prices in a local directory.
+type fileCache struct {
+	dir string
+}
+
+func (c fileCache) load(name string) ([]byte, time.Time, error) {
+	path := filepath.Join(c.dir, name)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	return data, fi.ModTime(), err
+}
+
+func (c fileCache) save(name string, data []byte) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(c.dir, name), data, 0644)
+}
+
+// s3Cache stores the prices in an S3 bucket, so they can be shared between
+// the Lambda function invocations.
+type s3Cache struct {
+	svc    s3iface.S3API
+	bucket string
+	prefix string
+}
+
+func (c s3Cache) key(name string) string {
+	if c.prefix == "" {
+		return name
+	}
+	return strings.TrimSuffix(c.prefix, "/") + "/" + name
+}
+
+func (c s3Cache) load(name string) ([]byte, time.Time, error) {
+	resp, err := c.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.key(name)),
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, aws.TimeValue(resp.LastModified), nil
+}
+
+func (c s3Cache) save(name string, data []byte) error {
+	_, err := c.svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(c.bucket),
+		Key:         aws.String(c.key(name)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// newPriceCache creates the price cache configured by its location, which is
+// either a local directory or an S3 URL such as s3://bucket/prefix.
+func newPriceCache(sess *session.Session, location, regionHint string) (priceCache, error) {
+	if location == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(location, "s3://") {
+		return fileCache{dir: location}, nil
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing bucket name in %s", location)
+	}
+
+	region, err := s3manager.GetBucketRegion(aws.BackgroundContext(), sess,
+		u.Host, regionHint)
+	if err != nil {
+		return nil, err
+	}
+
+	return s3Cache{
+		svc:    s3.New(sess, aws.NewConfig().WithRegion(region)),
+		bucket: u.Host,
+		prefix: strings.TrimPrefix(u.Path, "/"),
+	}, nil
+}
+
+// onDemandPriceProvider fetches the current on-demand prices from the Pricing
+// API, reusing the cached prices while they are fresh.
+type onDemandPriceProvider struct {
+	pricing pricingiface.PricingAPI
+	cache   priceCache
+	ttl     time.Duration
+
+	// the clock of the runs, defaulting to the system clock
+	clock Clock
+
+	// the loggers of the runs, the ones of the process being used if nil
+	logs *loggers
+
+	// prices kept in memory while fresh, when the provider is reused by
+	// several runs
+	sync.Mutex
+	memory map[string]memoryPrices
+}
+
+type memoryPrices struct {
+	prices  map[string]livePrice
+	fetched time.Time
+}
+
+func newOnDemandPriceProvider(cfg *Config) (*onDemandPriceProvider, error) {
+	sess, err := newSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newPriceCache(sess, cfg.PriceCache, cfg.MainRegion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &onDemandPriceProvider{
+		pricing: pricing.New(sess, aws.NewConfig().WithRegion(pricingAPIRegion)),
+		cache:   cache,
+		ttl:     cfg.PriceCacheTTL,
+		clock:   cfg.clock(),
+		logs:    cfg.logs,
+	}, nil
+}
+
+// prices returns the on-demand prices of an operating system in a region,
+// keyed by instance type. When the Pricing API can't be reached, expired
+// cached prices are still preferred over failing.
+func (p *onDemandPriceProvider) prices(region, os string) (map[string]livePrice, error) {
+
+	location, ok := pricingLocations[region]
+	if !ok {
+		return nil, fmt.Errorf("unknown pricing location for region %s", region)
+	}
+
+	name := region + "-" + os + ".json"
+
+	p.Lock()
+	cached, ok := p.memory[name]
+	p.Unlock()
+	if ok && p.age(cached.fetched) < p.ttl {
+		return cached.prices, nil
+	}
+
+	prices, modified, err := p.load(region, location, os, name)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Lock()
+	if p.memory == nil {
+		p.memory = make(map[string]memoryPrices)
+	}
+	p.memory[name] = memoryPrices{prices: prices, fetched: modified}
+	p.Unlock()
+
+	return prices, nil
+}
+
+// load returns the prices from the cache while fresh, otherwise from the API,
+// along with the time they were fetched at.
+func (p *onDemandPriceProvider) load(region, location, os,
+	name string) (map[string]livePrice, time.Time, error) {
+
+	var stale []livePrice
+
+	if p.cache != nil {
+		data, modified, err := p.cache.load(name)
+		if err == nil {
+			var cached []livePrice
+			if err := json.Unmarshal(data, &cached); err == nil {
+				if p.age(modified) < p.ttl {
+					p.logs.debugLogger().Println("Using cached on-demand prices for", region, os)
+					return indexPrices(cached), modified, nil
+				}
+				stale = cached
+			}
+		}
+	}
+
+	fetched, err := p.fetch(location, os)
+	if err != nil {
+		if stale != nil {
+			p.logs.logger().Println(region, "Couldn't fetch on-demand prices, using expired "+
+				"cached prices:", err)
+			// retried by the next run
+			return indexPrices(stale), time.Time{}, nil
+		}
+		return nil, time.Time{}, err
+	}
+
+	if p.cache != nil {
+		data, err := json.Marshal(fetched)
+		if err == nil {
+			err = p.cache.save(name, data)
+		}
+		if err != nil {
+			p.logs.logger().Println(region, "Couldn't cache the on-demand prices:", err)
+		}
+	}
+
+	return indexPrices(fetched), orRealClock(p.clock).Now(), nil
+}
+
+// age returns the time elapsed since the prices were fetched.
+func (p *onDemandPriceProvider) age(fetched time.Time) time.Duration {
+	return orRealClock(p.clock).Now().Sub(fetched)
+}
+
+func (p *onDemandPriceProvider) fetch(location, os string) ([]livePrice, error) {
+
+	operatingSystem, ok := pricingOperatingSystems[os]
+	if !ok {
+		return nil, fmt.Errorf("unknown pricing operating system %s", os)
+	}
+
+	licenseModel := "No License required"
+	if os == windowsPricing {
+		licenseModel = "License Included"
+	}
+
+	filter := func(field, value string) *pricing.Filter {
+		return &pricing.Filter{
+			Field: aws.String(field),
+			Type:  aws.String(pricing.FilterTypeTermMatch),
+			Value: aws.String(value),
+		}
+	}
+
+	input := &pricing.GetProductsInput{
+		ServiceCode:   aws.String("AmazonEC2"),
+		FormatVersion: aws.String("aws_v1"),
+		Filters: []*pricing.Filter{
+			filter("location", location),
+			filter("operatingSystem", operatingSystem),
+			filter("tenancy", "Shared"),
+			filter("preInstalledSw", "NA"),
+			filter("licenseModel", licenseModel),
+		},
+	}
+
+	var prices []livePrice
+	var parseErr error
+
+	err := p.pricing.GetProductsPages(input,
+		func(page *pricing.GetProductsOutput, lastPage bool) bool {
+			for _, item := range page.PriceList {
+				price, ok, err := parsePriceListItem(item)
+				if err != nil {
+					parseErr = err
+					return false
+				}
+				if ok {
+					prices = append(prices, price)
+				}
+			}
+			return true
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	if len(prices) == 0 {
+		return nil, errors.New("no on-demand prices returned for " +
+			operatingSystem + " in " + location)
+	}
+
+	return prices, nil
+}
+
+// parsePriceListItem extracts the instance type information from a price list
+// entry, returning false for entries not describing a usable on-demand price.
+func parsePriceListItem(item aws.JSONValue) (livePrice, bool, error) {
+	var p priceListItem
+
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return livePrice{}, false, err
+	}
+
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return livePrice{}, false, err
+	}
+
+	attrs := p.Product.Attributes
+
+	// capacity reservations are priced separately
+	if status, ok := attrs["capacitystatus"]; ok && status != "Used" {
+		return livePrice{}, false, nil
+	}
+
+	if attrs["instanceType"] == "" {
+		return livePrice{}, false, nil
+	}
+
+	var onDemand float64
+	for _, term := range p.Terms.OnDemand {
+		for _, dimension := range term.PriceDimensions {
+			onDemand, _ = strconv.ParseFloat(dimension.PricePerUnit["USD"], 64)
+		}
+	}
+
+	if onDemand <= 0 {
+		return livePrice{}, false, nil
+	}
+
+	vCPU, _ := strconv.Atoi(attrs["vcpu"])
+	GPU, _ := strconv.Atoi(attrs["gpu"])
+
+	return livePrice{
+		InstanceType:      attrs["instanceType"],
+		OnDemand:          onDemand,
+		VCPU:              vCPU,
+		Memory:            parseMemory(attrs["memory"]),
+		GPU:               GPU,
+		Category:          attrs["instanceFamily"],
+		CurrentGeneration: attrs["currentGeneration"] == "Yes",
+	}, true, nil
+}
+
+// parseMemory converts memory sizes such as "1,952 GiB" to GiB.
+func parseMemory(memory string) float32 {
+	fields := strings.Fields(strings.Replace(memory, ",", "", -1))
+	if len(fields) == 0 {
+		return 0
+	}
+	m, _ := strconv.ParseFloat(fields[0], 32)
+	return float32(m)
+}
+
+func indexPrices(prices []livePrice) map[string]livePrice {
+	index := make(map[string]livePrice)
+	for _, p := range prices {
+		index[p.InstanceType] = p
+	}
+	return index
+}
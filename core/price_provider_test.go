@@ -0,0 +1,360 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/cristim/ec2-instances-info"
+)
+
+// mockPriceCache is an in-memory price cache
+type mockPriceCache struct {
+	data     map[string][]byte
+	modified time.Time
+	saveErr  error
+}
+
+func (c *mockPriceCache) load(name string) ([]byte, time.Time, error) {
+	data, ok := c.data[name]
+	if !ok {
+		return nil, time.Time{}, errors.New("not found")
+	}
+	return data, c.modified, nil
+}
+
+func (c *mockPriceCache) save(name string, data []byte) error {
+	if c.saveErr != nil {
+		return c.saveErr
+	}
+	c.data[name] = data
+	return nil
+}
+
+func priceListEntry(instanceType, price string) aws.JSONValue {
+	var item aws.JSONValue
+	json.Unmarshal([]byte(`{
+		"product": {
+			"attributes": {
+				"instanceType": "`+instanceType+`",
+				"vcpu": "2",
+				"memory": "1,952 GiB",
+				"instanceFamily": "General purpose",
+				"currentGeneration": "Yes",
+				"capacitystatus": "Used"
+			}
+		},
+		"terms": {
+			"OnDemand": {
+				"SKU.JRTCKXETXF": {
+					"priceDimensions": {
+						"SKU.JRTCKXETXF.6YS6EN2CT7": {
+							"pricePerUnit": {"USD": "`+price+`"}
+						}
+					}
+				}
+			}
+		}
+	}`), &item)
+	return item
+}
+
+func cachedPrices(prices ...livePrice) []byte {
+	data, _ := json.Marshal(prices)
+	return data
+}
+
+func TestParsePriceListItem(t *testing.T) {
+	reservation := priceListEntry("m5.large", "0.096")
+	reservation["product"].(map[string]interface{})["attributes"].(map[string]interface{})["capacitystatus"] = "UnusedCapacityReservation"
+
+	tests := []struct {
+		name   string
+		item   aws.JSONValue
+		want   livePrice
+		wantOK bool
+	}{
+		{name: "regular on-demand price",
+			item: priceListEntry("m5.large", "0.0960000000"),
+			want: livePrice{
+				InstanceType:      "m5.large",
+				OnDemand:          0.096,
+				VCPU:              2,
+				Memory:            1952,
+				Category:          "General purpose",
+				CurrentGeneration: true,
+			},
+			wantOK: true,
+		},
+		{name: "zero price",
+			item:   priceListEntry("m5.large", "0.0000000000"),
+			wantOK: false,
+		},
+		{name: "capacity reservation",
+			item:   reservation,
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := parsePriceListItem(tt.item)
+			if err != nil {
+				t.Fatalf("parsePriceListItem() error = %v", err)
+			}
+			if ok != tt.wantOK {
+				t.Errorf("parsePriceListItem() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePriceListItem() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnDemandPriceProviderPrices(t *testing.T) {
+	apiPrices := &pricing.GetProductsOutput{
+		PriceList: []aws.JSONValue{priceListEntry("m5.large", "0.096")},
+	}
+	cached := cachedPrices(livePrice{InstanceType: "m5.large", OnDemand: 0.09})
+
+	tests := []struct {
+		name      string
+		region    string
+		api       mockPricing
+		cache     *mockPriceCache
+		want      float64
+		wantErr   bool
+		wantSaved bool
+	}{
+		{name: "fetched from the API without cache",
+			region: "us-east-1",
+			api:    mockPricing{gpo: apiPrices},
+			want:   0.096,
+		},
+		{name: "fresh cached prices",
+			region: "us-east-1",
+			api:    mockPricing{gperr: errors.New("unexpected call")},
+			cache: &mockPriceCache{
+				data:     map[string][]byte{"us-east-1-linux.json": cached},
+				modified: time.Now(),
+			},
+			want: 0.09,
+		},
+		{name: "expired cached prices are refreshed",
+			region: "us-east-1",
+			api:    mockPricing{gpo: apiPrices},
+			cache: &mockPriceCache{
+				data:     map[string][]byte{"us-east-1-linux.json": cached},
+				modified: time.Now().Add(-48 * time.Hour),
+			},
+			want:      0.096,
+			wantSaved: true,
+		},
+		{name: "expired cached prices are used when the API fails",
+			region: "us-east-1",
+			api:    mockPricing{gperr: errors.New("unreachable")},
+			cache: &mockPriceCache{
+				data:     map[string][]byte{"us-east-1-linux.json": cached},
+				modified: time.Now().Add(-48 * time.Hour),
+			},
+			want: 0.09,
+		},
+		{name: "API failure without cache",
+			region:  "us-east-1",
+			api:     mockPricing{gperr: errors.New("unreachable")},
+			cache:   &mockPriceCache{data: map[string][]byte{}},
+			wantErr: true,
+		},
+		{name: "API returning no prices",
+			region:  "us-east-1",
+			api:     mockPricing{gpo: &pricing.GetProductsOutput{}},
+			wantErr: true,
+		},
+		{name: "region without pricing location",
+			region:  "foo",
+			api:     mockPricing{gpo: apiPrices},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &onDemandPriceProvider{
+				pricing: tt.api,
+				ttl:     DefaultPriceCacheTTL,
+			}
+			if tt.cache != nil {
+				p.cache = tt.cache
+			}
+
+			got, err := p.prices(tt.region, linuxPricing)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("prices() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if math.Abs(got["m5.large"].OnDemand-tt.want) > 0.000001 {
+				t.Errorf("prices() m5.large = %v, want %v", got["m5.large"].OnDemand, tt.want)
+			}
+
+			if tt.wantSaved && reflect.DeepEqual(tt.cache.data["us-east-1-linux.json"], cached) {
+				t.Errorf("prices() didn't update the cache")
+			}
+		})
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := fileCache{dir: dir + "/prices"}
+
+	if _, _, err := c.load("us-east-1-linux.json"); err == nil {
+		t.Errorf("load() of a missing file didn't fail")
+	}
+
+	if err := c.save("us-east-1-linux.json", []byte("[]")); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	data, modified, err := c.load("us-east-1-linux.json")
+	if err != nil || string(data) != "[]" || time.Since(modified) > time.Minute {
+		t.Errorf("load() = %s, %v, %v", data, modified, err)
+	}
+}
+
+func TestLiveOnDemandPrices(t *testing.T) {
+	cfg := &Config{
+		InstanceData: &ec2instancesinfo.InstanceData{
+			0: {
+				InstanceType: "m1.small",
+				Pricing: map[string]ec2instancesinfo.RegionPrices{
+					"us-east-1": {
+						Linux: ec2instancesinfo.LinuxPricing{
+							OnDemand: 0.044,
+						},
+					},
+				},
+			},
+		},
+		OnDemandPriceMultiplier: 1.0,
+		SpotProductDescription:  DefaultSpotProductDescription,
+	}
+
+	tests := []struct {
+		name string
+		api  mockPricing
+		want map[string]float64
+	}{
+		{name: "live prices override the bundled ones and add new types",
+			api: mockPricing{gpo: &pricing.GetProductsOutput{
+				PriceList: []aws.JSONValue{
+					priceListEntry("m1.small", "0.04"),
+					priceListEntry("m9.large", "0.2"),
+				},
+			}},
+			want: map[string]float64{"m1.small": 0.04, "m9.large": 0.2},
+		},
+		{name: "bundled prices are used when the API fails",
+			api:  mockPricing{gperr: errors.New("unreachable")},
+			want: map[string]float64{"m1.small": 0.044},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := region{
+				name: "us-east-1",
+				conf: cfg,
+				priceProvider: &onDemandPriceProvider{
+					pricing: tt.api,
+					ttl:     DefaultPriceCacheTTL,
+				},
+				services: connections{
+					ec2: mockEC2{
+						dspho: &ec2.DescribeSpotPriceHistoryOutput{
+							SpotPriceHistory: []*ec2.SpotPrice{},
+						},
+					},
+				}}
+			r.determineInstanceTypeInformation(cfg)
+
+			if len(r.instanceTypeInformation) != len(tt.want) {
+				t.Errorf("got %d instance types, want %d",
+					len(r.instanceTypeInformation), len(tt.want))
+			}
+
+			for instanceType, want := range tt.want {
+				got := r.instanceTypeInformation[instanceType].pricing.onDemand
+				if math.Abs(got-want) > 0.000001 {
+					t.Errorf("%s pricing.onDemand = %.5f, want %.5f", instanceType, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOnDemandPriceProviderReusesPrices(t *testing.T) {
+	p := &onDemandPriceProvider{
+		pricing: mockPricing{gpo: &pricing.GetProductsOutput{
+			PriceList: []aws.JSONValue{priceListEntry("m5.large", "0.096")},
+		}},
+		ttl: DefaultPriceCacheTTL,
+	}
+
+	if _, err := p.prices("us-east-1", linuxPricing); err != nil {
+		t.Fatalf("prices() error = %v", err)
+	}
+
+	// later runs use the prices kept in memory while fresh
+	p.pricing = mockPricing{gperr: errors.New("unexpected call")}
+
+	got, err := p.prices("us-east-1", linuxPricing)
+	if err != nil {
+		t.Fatalf("prices() error = %v", err)
+	}
+	if math.Abs(got["m5.large"].OnDemand-0.096) > 0.000001 {
+		t.Errorf("prices() m5.large = %v, want 0.096", got["m5.large"].OnDemand)
+	}
+}
+
+func TestOnDemandPriceProviderExpiresPricesByClock(t *testing.T) {
+	clock := &replayClock{now: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)}
+	p := &onDemandPriceProvider{
+		pricing: mockPricing{gpo: &pricing.GetProductsOutput{
+			PriceList: []aws.JSONValue{priceListEntry("m5.large", "0.096")},
+		}},
+		ttl:   time.Hour,
+		clock: clock,
+	}
+
+	if _, err := p.prices("us-east-1", linuxPricing); err != nil {
+		t.Fatalf("prices() error = %v", err)
+	}
+
+	// the prices expire once the clock moves past their TTL
+	clock.Sleep(2 * time.Hour)
+	p.pricing = mockPricing{gpo: &pricing.GetProductsOutput{
+		PriceList: []aws.JSONValue{priceListEntry("m5.large", "0.1")},
+	}}
+
+	got, err := p.prices("us-east-1", linuxPricing)
+	if err != nil {
+		t.Fatalf("prices() error = %v", err)
+	}
+	if math.Abs(got["m5.large"].OnDemand-0.1) > 0.000001 {
+		t.Errorf("prices() m5.large = %v, want 0.1", got["m5.large"].OnDemand)
+	}
+}
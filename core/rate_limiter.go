@@ -0,0 +1,135 @@
+package autospotting
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// rateLimiter is a token bucket shared by all the API clients, which limits
+// the rate of the API calls in order to avoid being throttled.
+type rateLimiter struct {
+	sync.Mutex
+
+	// tokens added per second, and the maximum number of tokens
+	rate  float64
+	burst float64
+
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter allowing the given number of requests per
+// second, or nil if the rate isn't limited.
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+
+	burst := math.Max(1, rate)
+
+	return &rateLimiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a request can be made, or the context is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		l.Lock()
+
+		now := time.Now()
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.Unlock()
+			return nil
+		}
+
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handler returns an AWS SDK request handler waiting for the limiter before
+// each attempt of sending a request, including the retries.
+func (l *rateLimiter) handler() request.NamedHandler {
+	return request.NamedHandler{
+		Name: "autospotting.RateLimiter",
+		Fn: func(r *request.Request) {
+			if err := l.wait(r.Context()); err != nil {
+				r.Error = err
+			}
+		},
+	}
+}
+
+// throttlingLogger logs the requests which are going to be retried with
+// exponential backoff after being throttled.
+var throttlingLogger = request.NamedHandler{
+	Name: "autospotting.ThrottlingLogger",
+	Fn: func(r *request.Request) {
+		if r.Error != nil && r.IsErrorThrottle() && r.RetryCount < r.MaxRetries() {
+			logger.Println("Throttled", r.ClientInfo.ServiceName,
+				r.Operation.Name, "request, retry", r.RetryCount+1, "of",
+				r.MaxRetries())
+		}
+	},
+}
+
+// workerPool limits the number of goroutines doing work concurrently.
+type workerPool chan struct{}
+
+// newWorkerPool creates a pool of the given size, or an unlimited one if the
+// size isn't positive.
+func newWorkerPool(size int) workerPool {
+	if size <= 0 {
+		return nil
+	}
+	return make(workerPool, size)
+}
+
+func (p workerPool) acquire() {
+	if p != nil {
+		p <- struct{}{}
+	}
+}
+
+// acquireWithin waits for a worker until the timeout or the context is done,
+// telling if it was acquired.
+func (p workerPool) acquireWithin(ctx context.Context, timeout time.Duration) bool {
+	if p == nil {
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case p <- struct{}{}:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return false
+}
+
+func (p workerPool) release() {
+	if p != nil {
+		<-p
+	}
+}
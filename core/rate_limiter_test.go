@@ -0,0 +1,116 @@
+package autospotting
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Errorf("newRateLimiter(0) should not limit the rate")
+	}
+
+	l := newRateLimiter(20)
+
+	start := time.Now()
+	for i := 0; i < 22; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatalf("wait() error = %v", err)
+		}
+	}
+
+	// the burst of 20 is available immediately, the other 2 requests take
+	// about 50ms each
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("22 requests took %v, expected at least 100ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	l = newRateLimiter(0.001)
+	l.wait(context.Background())
+	if err := l.wait(ctx); err == nil {
+		t.Errorf("wait() didn't stop when the context was cancelled")
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var running, maxRunning int
+
+	pool := newWorkerPool(3)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pool.acquire()
+			defer pool.release()
+
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning > 3 {
+		t.Errorf("%d workers were running concurrently, expected at most 3", maxRunning)
+	}
+}
+
+func TestWorkerPoolAcquireWithin(t *testing.T) {
+	pool := newWorkerPool(1)
+
+	if !pool.acquireWithin(context.Background(), time.Millisecond) {
+		t.Fatalf("acquireWithin() of an idle pool failed")
+	}
+	if pool.acquireWithin(context.Background(), 5*time.Millisecond) {
+		t.Errorf("acquireWithin() of a busy pool succeeded")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if pool.acquireWithin(ctx, time.Minute) {
+		t.Errorf("acquireWithin() with a cancelled context succeeded")
+	}
+
+	pool.release()
+	if !pool.acquireWithin(context.Background(), time.Millisecond) {
+		t.Errorf("acquireWithin() after the release failed")
+	}
+
+	var unlimited workerPool
+	if !unlimited.acquireWithin(ctx, time.Millisecond) {
+		t.Errorf("acquireWithin() of an unlimited pool failed")
+	}
+}
+
+func TestConnectionsRateLimiting(t *testing.T) {
+	plain := &connections{}
+	plain.connect("us-east-1")
+
+	limited := &connections{limiter: newRateLimiter(5), maxRetries: 7}
+	limited.connect("us-east-1")
+
+	if limited.session.Handlers.Sign.Len() != plain.session.Handlers.Sign.Len()+1 {
+		t.Errorf("the rate limiter wasn't added to the request handlers")
+	}
+
+	if *limited.session.Config.MaxRetries != 7 {
+		t.Errorf("MaxRetries = %d, want 7", *limited.session.Config.MaxRetries)
+	}
+}
@@ -1,8 +1,12 @@
 package autospotting
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"log"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -23,15 +27,37 @@ type Tag struct {
 type region struct {
 	name string
 
+	// the context of the current run, carrying its deadline
+	ctx context.Context
+
 	conf *Config
 	// The key in this map is the instance type.
 	instanceTypeInformation map[string]instanceTypeInformation
 
+	// Instance type information priced for the spot product descriptions used
+	// by groups running other operating systems than the default one, keyed
+	// by product description.
+	instanceTypeInformationByProduct map[string]map[string]instanceTypeInformation
+	pricingLock                      sync.Mutex
+
+	// IDs of the on-demand instances expected to be covered by reserved
+	// instances, which should be kept running
+	reservedInstanceIDs map[string]bool
+
+	// Optional source of current on-demand prices, overriding the bundled ones
+	priceProvider *onDemandPriceProvider
+
+	// the result of the run in progress, if any
+	report *runReport
+
 	instances instances
 
 	enabledASGs []autoScalingGroup
 	services    connections
 
+	// when set, only these groups are scanned instead of all of them
+	groupNames []*string
+
 	tagsToFilterASGsBy []Tag
 
 	wg sync.WaitGroup
@@ -69,38 +95,109 @@ func (r *region) enabled() bool {
 	return false
 }
 
+// engine returns an engine processing the region using its connections.
+func (r *region) engine() *Engine {
+	r.services.connect(r.name)
+
+	return &Engine{
+		cfg:         r.conf,
+		region:      r.name,
+		account:     r.services.account,
+		logs:        r.services.logs,
+		report:      r.report,
+		ec2:         r.services.ec2,
+		autoScaling: r.services.autoScaling,
+	}
+}
+
+// logs returns the loggers of the region, nil meaning the ones of the
+// process.
+func (r *region) logs() *loggers {
+	if r == nil {
+		return nil
+	}
+	return r.services.logs
+}
+
+func (r *region) logger() *log.Logger {
+	return r.logs().logger()
+}
+
+func (r *region) debugLogger() *log.Logger {
+	return r.logs().debugLogger()
+}
+
 func (r *region) processRegion() {
 
-	logger.Println("Creating connections to the required AWS services in", r.name)
+	// only process further the region if there are any enabled autoscaling groups
+	// within it
+	if r.scan() {
+		r.logger().Println("Processing enabled AutoScaling groups in", r.name)
+		r.processEnabledAutoScalingGroups()
+	}
+}
+
+// scan finds the enabled AutoScaling groups and, if there are any, loads the
+// instance type information and the instances of the region.
+func (r *region) scan() bool {
+
+	r.logger().Println("Creating connections to the required AWS services in", r.name)
 	r.services.connect(r.name)
 	// only process the regions where we have AutoScaling groups set to be handled
 
 	// setup the filters for asg matching
 	r.setupAsgFilters()
 
-	logger.Println("Scanning for enabled AutoScaling groups in ", r.name)
+	r.logger().Println("Scanning for enabled AutoScaling groups in ", r.name)
 	r.scanForEnabledAutoScalingGroups()
 
-	// only process further the region if there are any enabled autoscaling groups
-	// within it
-	if r.hasEnabledAutoScalingGroups() {
+	if !r.hasEnabledAutoScalingGroups() {
+		r.logger().Println(r.name, "has no enabled AutoScaling groups")
+		return false
+	}
 
-		logger.Println("Scanning full instance information in", r.name)
-		r.determineInstanceTypeInformation(r.conf)
+	r.logger().Println("Scanning full instance information in", r.name)
+	r.determineInstanceTypeInformation(r.conf)
 
-		debug.Println(spew.Sdump(r.instanceTypeInformation))
+	r.debugLogger().Println(spew.Sdump(r.instanceTypeInformation))
 
-		logger.Println("Scanning instances in", r.name)
-		err := r.scanInstances()
-		if err != nil {
-			logger.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+	r.logger().Println("Scanning instances in", r.name)
+	err := r.scanInstances()
+	if err != nil {
+		r.logger().Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+		r.report.addError(r.services.account, r.name, err)
+	}
+
+	if r.conf.ReservedInstancesAware {
+		r.logger().Println("Scanning reserved instances in", r.name)
+		if err := r.scanReservedInstances(); err != nil {
+			r.logger().Printf("Failed to scan reserved instances in %s error: %s\n", r.name, err)
 		}
+	}
+	return true
+}
 
-		logger.Println("Processing enabled AutoScaling groups in", r.name)
-		r.processEnabledAutoScalingGroups()
-	} else {
-		logger.Println(r.name, "has no enabled AutoScaling groups")
+// scanGroup loads the AutoScaling group with the given name, regardless of its
+// tags, along with the instance type information and the instances of the
+// region.
+func (r *region) scanGroup(name string) (*autoScalingGroup, error) {
+	r.services.connect(r.name)
+
+	r.groupNames = []*string{aws.String(name)}
+	r.enabledASGs = nil
+	r.scanForEnabledAutoScalingGroups()
+
+	if !r.hasEnabledAutoScalingGroups() {
+		return nil, fmt.Errorf("the group %s was not found in %s", name, r.name)
 	}
+
+	r.determineInstanceTypeInformation(r.conf)
+
+	if err := r.scanInstances(); err != nil {
+		return nil, err
+	}
+
+	return &r.enabledASGs[0], nil
 }
 
 func (r *region) setupAsgFilters() {
@@ -157,9 +254,9 @@ func (r *region) scanInstances() error {
 		input,
 		func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
 			pageNum++
-			logger.Println("Processing page", pageNum, "of DescribeInstancesPages for", r.name)
+			r.logger().Println("Processing page", pageNum, "of DescribeInstancesPages for", r.name)
 
-			debug.Println(page)
+			r.debugLogger().Println(page)
 			if len(page.Reservations) > 0 &&
 				page.Reservations[0].Instances != nil {
 
@@ -177,7 +274,7 @@ func (r *region) scanInstances() error {
 		return err
 	}
 
-	debug.Println(r.instances.dump())
+	r.debugLogger().Println(r.instances.dump())
 
 	return nil
 }
@@ -191,8 +288,78 @@ func (r *region) addInstance(inst *ec2.Instance) {
 }
 
 func (r *region) determineInstanceTypeInformation(cfg *Config) {
+	r.instanceTypeInformation = r.buildInstanceTypeInformation(cfg, cfg.SpotProductDescription)
+	r.debugLogger().Println(spew.Sdump(r.instanceTypeInformation))
+}
+
+// getInstanceTypeInformation returns the instance type information priced for
+// the given spot product description, which is only built once per region for
+// each product description other than the globally configured one.
+func (r *region) getInstanceTypeInformation(product string) map[string]instanceTypeInformation {
+	if product == r.conf.SpotProductDescription {
+		return r.instanceTypeInformation
+	}
+
+	r.pricingLock.Lock()
+	defer r.pricingLock.Unlock()
+
+	if r.instanceTypeInformationByProduct == nil {
+		r.instanceTypeInformationByProduct = make(map[string]map[string]instanceTypeInformation)
+	}
+
+	info, ok := r.instanceTypeInformationByProduct[product]
+	if !ok {
+		r.logger().Println("Scanning instance information for", product, "in", r.name)
+		info = r.buildInstanceTypeInformation(r.conf, product)
+		r.instanceTypeInformationByProduct[product] = info
+	}
+	return info
+}
+
+// onDemandPrice returns the on-demand price of an instance type for the
+// operating system of the given spot product description, and whether it is
+// known. The instance types available in the region may lack the prices of
+// some operating systems, which aren't supported by them.
+func (r *region) onDemandPrice(cfg *Config, instanceType string,
+	linuxPrice float64, product string) (float64, bool) {
+
+	os := pricingOS(product)
+
+	if os == linuxPricing {
+		return linuxPrice, true
+	}
+
+	if cfg.OSPrices == nil {
+		r.debugLogger().Println("Missing", os, "prices, falling back to the Linux price for",
+			instanceType)
+		return linuxPrice, true
+	}
+
+	price, ok := cfg.OSPrices.onDemand(instanceType, r.name, os)
+	return price, ok && price > 0
+}
+
+// liveOnDemandPrices returns the current on-demand prices for the given spot
+// product description, or nil if they are unavailable and the bundled prices
+// should be used instead.
+func (r *region) liveOnDemandPrices(product string) map[string]livePrice {
+	if r.priceProvider == nil {
+		return nil
+	}
+
+	prices, err := r.priceProvider.prices(r.name, pricingOS(product))
+	if err != nil {
+		r.logger().Println(r.name, "Falling back to the bundled on-demand prices:", err)
+		return nil
+	}
+	return prices
+}
 
-	r.instanceTypeInformation = make(map[string]instanceTypeInformation)
+func (r *region) buildInstanceTypeInformation(cfg *Config, product string) map[string]instanceTypeInformation {
+
+	typeInfo := make(map[string]instanceTypeInformation)
+
+	live := r.liveOnDemandPrices(product)
 
 	var info instanceTypeInformation
 
@@ -200,10 +367,24 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 
 		var price prices
 
-		debug.Println(it)
+		r.debugLogger().Println(it)
 
 		// populate on-demand information
-		price.onDemand = it.Pricing[r.name].Linux.OnDemand * cfg.OnDemandPriceMultiplier
+		linuxPrice := it.Pricing[r.name].Linux.OnDemand
+		onDemand, known := r.onDemandPrice(cfg, it.InstanceType, linuxPrice, product)
+		if lp, ok := live[it.InstanceType]; ok {
+			onDemand, known = lp.OnDemand, true
+		}
+
+		// never compare the spot prices with a missing on-demand price
+		if !known {
+			if linuxPrice > 0 {
+				r.logger().Println(r.name, "Missing the", pricingOS(product),
+					"on-demand price of", it.InstanceType, "skipping it")
+			}
+			continue
+		}
+		price.onDemand = onDemand * r.onDemandPriceMultiplier(cfg, it.InstanceType)
 		price.spot = make(spotPriceMap)
 		price.ebsSurcharge = it.Pricing[r.name].EBSSurcharge
 
@@ -221,6 +402,9 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 				pricing:             price,
 				virtualizationTypes: it.LinuxVirtualizationTypes,
 				hasEBSOptimization:  it.EBSOptimized,
+				category:            it.Family,
+				currentGeneration:   it.Generation == "current",
+				burstable:           isBurstableInstanceType(it.InstanceType),
 			}
 
 			if it.Storage != nil {
@@ -229,34 +413,67 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 				info.instanceStoreDeviceCount = it.Storage.Devices
 				info.instanceStoreIsSSD = it.Storage.SSD
 			}
-			debug.Println(info)
-			r.instanceTypeInformation[it.InstanceType] = info
+			r.debugLogger().Println(info)
+			typeInfo[it.InstanceType] = info
+		}
+	}
+
+	// instance types released after the bundled data was generated are only
+	// known from the Pricing API, which provides less details about them
+	for instanceType, lp := range live {
+		if _, ok := typeInfo[instanceType]; ok {
+			continue
+		}
+		r.logger().Println(r.name, "Instance type", instanceType,
+			"is missing from the bundled data, using the Pricing API details")
+
+		typeInfo[instanceType] = instanceTypeInformation{
+			instanceType: instanceType,
+			vCPU:         lp.VCPU,
+			memory:       lp.Memory,
+			GPU:          lp.GPU,
+			pricing: prices{
+				onDemand: lp.OnDemand * r.onDemandPriceMultiplier(cfg, instanceType),
+				spot:     make(spotPriceMap),
+			},
+			virtualizationTypes: []string{"HVM"},
+			category:            lp.Category,
+			currentGeneration:   lp.CurrentGeneration,
+			burstable:           isBurstableInstanceType(instanceType),
 		}
 	}
 	// this is safe to do once outside of the loop because the call will only
 	// return entries about the available instance types, so no invalid instance
 	// types would be returned
 
-	if err := r.requestSpotPrices(); err != nil {
-		logger.Println(err.Error())
+	if err := r.requestSpotPrices(typeInfo, product); err != nil {
+		r.logger().Println(err.Error())
 	}
 
-	debug.Println(spew.Sdump(r.instanceTypeInformation))
+	return typeInfo
 }
 
-func (r *region) requestSpotPrices() error {
+func (r *region) requestSpotPrices(typeInfo map[string]instanceTypeInformation, product string) error {
 
-	s := spotPrices{conn: r.services}
+	s := spotPrices{conn: r.services, cache: r.conf.spotPriceCache, clock: r.conf.clock()}
 
-	// Retrieve all current spot prices from the current region.
-	// TODO: add support for other OSes
-	err := s.fetch(r.conf.SpotProductDescription, 0, nil, nil)
+	// only the instance types we know about can be used as spot candidates
+	var instanceTypes []*string
+	for instanceType := range typeInfo {
+		instanceTypes = append(instanceTypes, aws.String(instanceType))
+	}
+	sort.Slice(instanceTypes, func(i, j int) bool {
+		return *instanceTypes[i] < *instanceTypes[j]
+	})
+
+	// Retrieve the current spot prices of these instance types.
+	err := s.fetch(product, 0, nil, instanceTypes)
 
 	if err != nil {
 		return errors.New("Couldn't fetch spot prices in " + r.name)
 	}
 
-	// logger.Println("Spot Price list in ", r.name, ":\n", s.data)
+	// r.logger().Println("Spot Price list in ", r.name, ":\n", s.data)
 
 	for _, priceInfo := range s.data {
 
@@ -266,18 +483,18 @@ func (r *region) requestSpotPrices() error {
 		// spot market
 		price, err := strconv.ParseFloat(*priceInfo.SpotPrice, 64)
 		if err != nil {
-			logger.Println(r.name, "Instance type ", instType,
+			r.logger().Println(r.name, "Instance type ", instType,
 				"is not available on the spot market")
 			continue
 		}
 
-		if r.instanceTypeInformation[instType].pricing.spot == nil {
-			logger.Println(r.name, "Instance data missing for", instType, "in", az,
+		if typeInfo[instType].pricing.spot == nil {
+			r.logger().Println(r.name, "Instance data missing for", instType, "in", az,
 				"skipping because this region is currently not supported")
 			continue
 		}
 
-		r.instanceTypeInformation[instType].pricing.spot[az] = price
+		typeInfo[instType].pricing.spot[az] = price
 
 	}
 
@@ -288,7 +505,7 @@ func (r *region) requestSpotInstanceTypes() ([]string, error) {
 
 	var instTypes []string
 
-	s := spotPrices{conn: r.services}
+	s := spotPrices{conn: r.services, clock: r.conf.clock()}
 
 	// Retrieve all current spot prices from the current region.
 	// TODO: add support for other OSes
@@ -337,7 +554,7 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group, ta
 	for _, group := range groups {
 		if isASGWithMatchingTags(group, tagsToMatch) {
 			asgName := *group.AutoScalingGroupName
-			logger.Println("Matching tags found for ASG, enabling ASG for processing:", asgName)
+			r.logger().Println("Matching tags found for ASG, enabling ASG for processing:", asgName)
 			asgs = append(asgs, autoScalingGroup{
 				Group:  group,
 				name:   asgName,
@@ -355,10 +572,12 @@ func (r *region) scanForEnabledAutoScalingGroups() {
 
 	pageNum := 0
 	err := svc.DescribeAutoScalingGroupsPages(
-		&autoscaling.DescribeAutoScalingGroupsInput{},
+		&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: r.groupNames,
+		},
 		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
 			pageNum++
-			logger.Println("Processing page", pageNum, "of DescribeAutoScalingGroupsPages for", r.name)
+			r.logger().Println("Processing page", pageNum, "of DescribeAutoScalingGroupsPages for", r.name)
 			matchingAsgs := r.findMatchingASGsInPageOfResults(page.AutoScalingGroups, r.tagsToFilterASGsBy)
 			r.enabledASGs = append(r.enabledASGs, matchingAsgs...)
 			return true
@@ -366,7 +585,8 @@ func (r *region) scanForEnabledAutoScalingGroups() {
 	)
 
 	if err != nil {
-		logger.Println("Failed to describe AutoScalingGroups in", r.name, err.Error())
+		r.logger().Println("Failed to describe AutoScalingGroups in", r.name, err.Error())
+		r.report.addError(r.services.account, r.name, err)
 	}
 
 }
@@ -390,10 +610,19 @@ func (r *region) hasEnabledAutoScalingGroups() bool {
 }
 
 func (r *region) processEnabledAutoScalingGroups() {
+	workers := r.conf.groupPool
+
 	for _, asg := range r.enabledASGs {
 		r.wg.Add(1)
 		go func(a autoScalingGroup) {
-			a.process()
+			workers.acquire()
+			defer workers.release()
+
+			if r.context().Err() != nil {
+				r.logger().Println(r.name, "Deadline reached, not processing", a.name)
+			} else {
+				a.process()
+			}
 			r.wg.Done()
 		}(asg)
 	}
@@ -294,6 +294,97 @@ func TestOnDemandPriceMultiplier(t *testing.T) {
 	}
 }
 
+func TestOperatingSystemOnDemandPrice(t *testing.T) {
+	tests := []struct {
+		name     string
+		product  string
+		osPrices OSPrices
+		want     float64
+		skipped  bool
+	}{
+		{name: "Linux price from the instance data",
+			product: "Linux/UNIX (Amazon VPC)",
+			osPrices: OSPrices{
+				"m1.small": {"us-east-1": {"mswin": 0.075}},
+			},
+			want: 0.044,
+		},
+		{name: "Windows price from the OS prices",
+			product: "Windows (Amazon VPC)",
+			osPrices: OSPrices{
+				"m1.small": {"us-east-1": {"mswin": 0.075}},
+			},
+			want: 0.075,
+		},
+		{name: "Windows price missing for the region",
+			product: "Windows",
+			osPrices: OSPrices{
+				"m1.small": {"eu-west-1": {"mswin": 0.08}},
+			},
+			skipped: true,
+		},
+		{name: "RHEL price missing for the instance type",
+			product: "Red Hat Enterprise Linux",
+			osPrices: OSPrices{
+				"m1.small": {"us-east-1": {"mswin": 0.075}},
+			},
+			skipped: true,
+		},
+		{name: "no OS prices loaded",
+			product:  "SUSE Linux",
+			osPrices: nil,
+			want:     0.044,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				InstanceData: &ec2instancesinfo.InstanceData{
+					0: {
+						InstanceType: "m1.small",
+						Pricing: map[string]ec2instancesinfo.RegionPrices{
+							"us-east-1": {
+								Linux: ec2instancesinfo.LinuxPricing{
+									OnDemand: 0.044,
+								},
+							},
+						},
+					},
+				},
+				OSPrices:                tt.osPrices,
+				OnDemandPriceMultiplier: 1.0,
+				SpotProductDescription:  DefaultSpotProductDescription,
+			}
+			r := region{
+				name: "us-east-1",
+				conf: cfg,
+				services: connections{
+					ec2: mockEC2{
+						dspho: &ec2.DescribeSpotPriceHistoryOutput{
+							SpotPriceHistory: []*ec2.SpotPrice{},
+						},
+					},
+				}}
+			r.determineInstanceTypeInformation(cfg)
+
+			info := r.getInstanceTypeInformation(tt.product)
+			if _, ok := info["m1.small"]; ok == tt.skipped {
+				t.Fatalf("m1.small found = %v, want %v", ok, !tt.skipped)
+			}
+			actualPrice := info["m1.small"].pricing.onDemand
+			if math.Abs(actualPrice-tt.want) > 0.000001 {
+				t.Errorf("pricing.onDemand = %.5f, want %.5f", actualPrice, tt.want)
+			}
+
+			// the instance type information is only built once per product
+			if tt.product != DefaultSpotProductDescription &&
+				r.instanceTypeInformationByProduct[tt.product] == nil {
+				t.Errorf("instance type information for %s wasn't cached", tt.product)
+			}
+		})
+	}
+}
+
 func TestContainsString(t *testing.T) {
 	tests := []struct {
 		name string
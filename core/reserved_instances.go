@@ -0,0 +1,282 @@
+package autospotting
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// normalizationFactors are used for applying size-flexible regional reserved
+// instances to instances of any size from the same family, as documented at
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/apply_ri.html
+var normalizationFactors = map[string]float64{
+	"nano":     0.25,
+	"micro":    0.5,
+	"small":    1,
+	"medium":   2,
+	"large":    4,
+	"xlarge":   8,
+	"2xlarge":  16,
+	"4xlarge":  32,
+	"8xlarge":  64,
+	"9xlarge":  72,
+	"10xlarge": 80,
+	"12xlarge": 96,
+	"16xlarge": 128,
+	"18xlarge": 144,
+	"24xlarge": 192,
+	"32xlarge": 256,
+}
+
+func normalizationFactor(instanceType string) float64 {
+	parts := strings.SplitN(instanceType, ".", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+	return normalizationFactors[parts[1]]
+}
+
+// reservedInstanceCapacity stores the capacity of the active reserved
+// instances of a region, as available for covering running instances.
+type reservedInstanceCapacity struct {
+	// zonal reservations, keyed by platform, instance type and availability
+	// zone
+	zonal map[string]int64
+
+	// regional reservations which aren't size-flexible, keyed by platform and
+	// instance type
+	regional map[string]int64
+
+	// normalized units of the size-flexible regional reservations, keyed by
+	// instance family
+	flexible map[string]float64
+}
+
+// riPlatform maps the product description of a reserved instance to the
+// operating system name used in the pricing data. The unsupported products,
+// such as the ones including SQL Server, keep their own name so they match no
+// instance.
+func riPlatform(product *string) string {
+	if product == nil {
+		return linuxPricing
+	}
+	if os, ok := productPricing[strings.TrimSuffix(*product, vpcProductSuffix)]; ok {
+		return os
+	}
+	return *product
+}
+
+// instancePlatform determines the operating system of an instance, as named
+// in the pricing data. The instances of the groups with a known spot product
+// description get its operating system, since the RHEL and SUSE instances
+// can't be told apart from the Linux ones otherwise.
+func instancePlatform(inst *instance, groupProducts map[string]string) string {
+	if inst.Platform != nil && strings.EqualFold(*inst.Platform, "windows") {
+		return windowsPricing
+	}
+	if product, ok := groupProducts[inst.groupName()]; ok {
+		return pricingOS(product)
+	}
+	return linuxPricing
+}
+
+func riTenancy(s *string) string {
+	if s == nil || *s == "" {
+		return "default"
+	}
+	return *s
+}
+
+func newReservedInstanceCapacity(ris []*ec2.ReservedInstances) *reservedInstanceCapacity {
+	c := &reservedInstanceCapacity{
+		zonal:    make(map[string]int64),
+		regional: make(map[string]int64),
+		flexible: make(map[string]float64),
+	}
+
+	for _, ri := range ris {
+		if ri.InstanceType == nil || ri.InstanceCount == nil {
+			continue
+		}
+
+		platform := riPlatform(ri.ProductDescription)
+
+		switch {
+		case ri.Scope != nil && *ri.Scope == ec2.ScopeAvailabilityZone &&
+			ri.AvailabilityZone != nil:
+			c.zonal[platform+"/"+*ri.InstanceType+"/"+*ri.AvailabilityZone] += *ri.InstanceCount
+
+		// only regional Linux reservations with default tenancy are size-flexible
+		case platform == linuxPricing && riTenancy(ri.InstanceTenancy) == "default" &&
+			normalizationFactor(*ri.InstanceType) > 0:
+			family := parseInstanceType(*ri.InstanceType).family()
+			c.flexible[family] += float64(*ri.InstanceCount) *
+				normalizationFactor(*ri.InstanceType)
+
+		default:
+			c.regional[platform+"/"+*ri.InstanceType] += *ri.InstanceCount
+		}
+	}
+	return c
+}
+
+// cover tries to apply the remaining reserved capacity to an on-demand
+// instance, in the same order used by the AWS billing: zonal reservations
+// first, then regional reservations for the same instance type, and finally
+// the size-flexible ones. The platform is the operating system of the
+// instance.
+func (c *reservedInstanceCapacity) cover(inst *instance, platform string) bool {
+	instanceType := *inst.InstanceType
+
+	if inst.Placement != nil && inst.Placement.AvailabilityZone != nil {
+		key := platform + "/" + instanceType + "/" + *inst.Placement.AvailabilityZone
+		if c.zonal[key] > 0 {
+			c.zonal[key]--
+			return true
+		}
+	}
+
+	if key := platform + "/" + instanceType; c.regional[key] > 0 {
+		c.regional[key]--
+		return true
+	}
+
+	if platform != linuxPricing ||
+		(inst.Placement != nil && riTenancy(inst.Placement.Tenancy) != "default") {
+		return false
+	}
+
+	family := parseInstanceType(instanceType).family()
+	if units := normalizationFactor(instanceType); units > 0 &&
+		c.flexible[family] >= units {
+		c.flexible[family] -= units
+		return true
+	}
+	return false
+}
+
+// isInAutoScalingGroup returns true for instances managed by an AutoScaling
+// group, as indicated by the tag set by the AutoScaling service.
+func (i *instance) isInAutoScalingGroup() bool {
+	return i.groupName() != ""
+}
+
+// groupName returns the name of the AutoScaling group managing the instance,
+// if any.
+func (i *instance) groupName() string {
+	for _, tag := range i.Tags {
+		if tag.Key != nil && *tag.Key == "aws:autoscaling:groupName" {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+// isReserved tells if the instance is expected to be covered by a reserved
+// instance, so replacing it with a spot instance would waste the reservation.
+func (i *instance) isReserved() bool {
+	return i.region != nil && i.InstanceId != nil &&
+		i.region.reservedInstanceIDs[*i.InstanceId]
+}
+
+// scanReservedInstances determines which of the running on-demand instances
+// of the region are covered by active reserved instances. Since the billing
+// doesn't tell which instances actually got the discount, the reservations
+// are applied to the instances outside AutoScaling groups first, because
+// those are never replaced, and then to the others in a stable order.
+func (r *region) scanReservedInstances() error {
+
+	resp, err := r.services.ec2.DescribeReservedInstances(
+		&ec2.DescribeReservedInstancesInput{
+			Filters: []*ec2.Filter{
+				{
+					Name:   aws.String("state"),
+					Values: []*string{aws.String("active")},
+				},
+			},
+		})
+
+	if err != nil {
+		return err
+	}
+
+	capacity := newReservedInstanceCapacity(resp.ReservedInstances)
+
+	var onDemand []*instance
+	for i := range r.instances.instances() {
+		if !i.isSpot() && i.InstanceType != nil && i.InstanceId != nil {
+			onDemand = append(onDemand, i)
+		}
+	}
+
+	sort.Slice(onDemand, func(x, y int) bool {
+		if onDemand[x].isInAutoScalingGroup() != onDemand[y].isInAutoScalingGroup() {
+			return !onDemand[x].isInAutoScalingGroup()
+		}
+		return *onDemand[x].InstanceId < *onDemand[y].InstanceId
+	})
+
+	var groupProducts map[string]string
+	if capacity.hasLinuxDistributions() {
+		groupProducts = r.groupProducts()
+	}
+
+	r.reservedInstanceIDs = make(map[string]bool)
+
+	var covered, coveredInGroups int
+	for _, i := range onDemand {
+		if capacity.cover(i, instancePlatform(i, groupProducts)) {
+			r.reservedInstanceIDs[*i.InstanceId] = true
+			covered++
+			if i.isInAutoScalingGroup() {
+				coveredInGroups++
+			}
+		}
+	}
+
+	r.logger().Printf("%s: %d out of %d on-demand instances are covered by %d "+
+		"reserved instance reservations, %d of them in AutoScaling groups\n",
+		r.name, covered, len(onDemand), len(resp.ReservedInstances), coveredInGroups)
+
+	return nil
+}
+
+// hasLinuxDistributions tells if there are RHEL or SUSE reservations, which
+// can only be matched with the instances running these operating systems by
+// using the spot product descriptions of their groups.
+func (c *reservedInstanceCapacity) hasLinuxDistributions() bool {
+	for _, reservations := range []map[string]int64{c.zonal, c.regional} {
+		for key := range reservations {
+			if p := strings.SplitN(key, "/", 2)[0]; p == rhelPricing || p == susePricing {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// groupProducts determines the spot product descriptions of the enabled
+// groups, keyed by group name.
+func (r *region) groupProducts() map[string]string {
+	products := make(map[string]string)
+	for n := range r.enabledASGs {
+		a := &r.enabledASGs[n]
+		products[a.name] = a.loadSpotProductDescription()
+	}
+	return products
+}
+
+// reservedInstanceCount counts the running on-demand instances of the group
+// which are covered by reserved instances.
+func (a *autoScalingGroup) reservedInstanceCount() int64 {
+	var count int64
+	for i := range a.instances.instances() {
+		if i.State != nil && i.State.Name != nil && *i.State.Name == "running" &&
+			!i.isSpot() && i.isReserved() {
+			count++
+		}
+	}
+	return count
+}
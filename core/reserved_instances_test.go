@@ -0,0 +1,273 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func testInstance(id, instanceType, az string, spot, inASG bool) *instance {
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:   aws.String(id),
+			InstanceType: aws.String(instanceType),
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String(az)},
+			State:        &ec2.InstanceState{Name: aws.String("running")},
+		},
+	}
+	if spot {
+		i.InstanceLifecycle = aws.String("spot")
+	}
+	if inASG {
+		i.Tags = []*ec2.Tag{
+			{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String("asg")},
+		}
+	}
+	return i
+}
+
+func TestNormalizationFactor(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		want         float64
+	}{
+		{instanceType: "m5.large", want: 4},
+		{instanceType: "c5.18xlarge", want: 144},
+		{instanceType: "t2.nano", want: 0.25},
+		{instanceType: "m5.metal", want: 0},
+		{instanceType: "invalid", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instanceType, func(t *testing.T) {
+			if got := normalizationFactor(tt.instanceType); got != tt.want {
+				t.Errorf("normalizationFactor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanReservedInstances(t *testing.T) {
+	windows := testInstance("i-1", "m5.large", "us-east-1a", false, true)
+	windows.Platform = aws.String("windows")
+
+	tests := []struct {
+		name      string
+		instances []*instance
+		products  map[string]string
+		ris       []*ec2.ReservedInstances
+		drierr    error
+		want      map[string]bool
+		wantErr   error
+	}{
+		{name: "zonal reservation",
+			instances: []*instance{
+				testInstance("i-1", "m5.large", "us-east-1a", false, true),
+				testInstance("i-2", "m5.large", "us-east-1b", false, true),
+			},
+			ris: []*ec2.ReservedInstances{
+				{
+					InstanceType:       aws.String("m5.large"),
+					InstanceCount:      aws.Int64(1),
+					Scope:              aws.String("Availability Zone"),
+					AvailabilityZone:   aws.String("us-east-1b"),
+					ProductDescription: aws.String("Linux/UNIX"),
+				},
+			},
+			want: map[string]bool{"i-2": true},
+		},
+		{name: "size-flexible regional reservation",
+			instances: []*instance{
+				testInstance("i-1", "m5.large", "us-east-1a", false, true),
+				testInstance("i-2", "m5.large", "us-east-1b", false, true),
+				testInstance("i-3", "m5.2xlarge", "us-east-1b", false, true),
+				testInstance("i-4", "m5.large", "us-east-1b", true, true),
+			},
+			ris: []*ec2.ReservedInstances{
+				{
+					InstanceType:       aws.String("m5.xlarge"),
+					InstanceCount:      aws.Int64(1),
+					Scope:              aws.String("Region"),
+					ProductDescription: aws.String("Linux/UNIX (Amazon VPC)"),
+					InstanceTenancy:    aws.String("default"),
+				},
+			},
+			want: map[string]bool{"i-1": true, "i-2": true},
+		},
+		{name: "instances outside AutoScaling groups are covered first",
+			instances: []*instance{
+				testInstance("i-1", "m5.large", "us-east-1a", false, true),
+				testInstance("i-2", "m5.large", "us-east-1a", false, false),
+			},
+			ris: []*ec2.ReservedInstances{
+				{
+					InstanceType:       aws.String("m5.large"),
+					InstanceCount:      aws.Int64(1),
+					Scope:              aws.String("Region"),
+					ProductDescription: aws.String("Linux/UNIX"),
+				},
+			},
+			want: map[string]bool{"i-2": true},
+		},
+		{name: "Windows reservations don't cover Linux instances",
+			instances: []*instance{
+				testInstance("i-1", "m5.large", "us-east-1a", false, true),
+			},
+			ris: []*ec2.ReservedInstances{
+				{
+					InstanceType:       aws.String("m5.large"),
+					InstanceCount:      aws.Int64(1),
+					Scope:              aws.String("Region"),
+					ProductDescription: aws.String("Windows"),
+				},
+			},
+			want: map[string]bool{},
+		},
+		{name: "RHEL reservations don't cover Linux instances",
+			instances: []*instance{
+				testInstance("i-1", "m5.large", "us-east-1a", false, true),
+				testInstance("i-2", "m5.large", "us-east-1a", false, false),
+			},
+			products: map[string]string{"asg": "Linux/UNIX (Amazon VPC)"},
+			ris: []*ec2.ReservedInstances{
+				{
+					InstanceType:       aws.String("m5.large"),
+					InstanceCount:      aws.Int64(2),
+					Scope:              aws.String("Region"),
+					ProductDescription: aws.String("Red Hat Enterprise Linux"),
+				},
+			},
+			want: map[string]bool{},
+		},
+		{name: "RHEL reservations cover the instances of RHEL groups",
+			instances: []*instance{
+				testInstance("i-1", "m5.large", "us-east-1a", false, true),
+			},
+			products: map[string]string{"asg": "Red Hat Enterprise Linux"},
+			ris: []*ec2.ReservedInstances{
+				{
+					InstanceType:       aws.String("m5.large"),
+					InstanceCount:      aws.Int64(1),
+					Scope:              aws.String("Region"),
+					ProductDescription: aws.String("Red Hat Enterprise Linux (Amazon VPC)"),
+				},
+			},
+			want: map[string]bool{"i-1": true},
+		},
+		{name: "unsupported reservations don't cover Windows instances",
+			instances: []*instance{windows},
+			ris: []*ec2.ReservedInstances{
+				{
+					InstanceType:       aws.String("m5.large"),
+					InstanceCount:      aws.Int64(1),
+					Scope:              aws.String("Region"),
+					ProductDescription: aws.String("Windows with SQL Server Standard"),
+				},
+			},
+			want: map[string]bool{},
+		},
+		{name: "API error",
+			drierr:  errors.New("describe error"),
+			wantErr: errors.New("describe error"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				services: connections{
+					ec2: mockEC2{
+						drio:   &ec2.DescribeReservedInstancesOutput{ReservedInstances: tt.ris},
+						drierr: tt.drierr,
+					},
+				},
+				instances: makeInstances(),
+			}
+			for _, i := range tt.instances {
+				i.region = r
+				r.instances.add(i)
+			}
+			for name, product := range tt.products {
+				r.enabledASGs = append(r.enabledASGs, autoScalingGroup{
+					Group: &autoscaling.Group{
+						Tags: []*autoscaling.TagDescription{{
+							Key:   aws.String(SpotProductDescriptionTag),
+							Value: aws.String(product),
+						}},
+					},
+					name:   name,
+					region: r,
+				})
+			}
+			err := r.scanReservedInstances()
+			CheckErrors(t, err, tt.wantErr)
+			if tt.wantErr == nil && !reflect.DeepEqual(r.reservedInstanceIDs, tt.want) {
+				t.Errorf("reservedInstanceIDs = %v, want %v", r.reservedInstanceIDs, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepReservedInstances(t *testing.T) {
+	tests := []struct {
+		name        string
+		minOnDemand int64
+		reserved    map[string]bool
+		want        int64
+		wantOD      string
+	}{
+		{name: "no reserved instances",
+			minOnDemand: 0,
+			reserved:    map[string]bool{},
+			want:        0,
+			wantOD:      "i-1",
+		},
+		{name: "reserved instances raise the on-demand capacity",
+			minOnDemand: 0,
+			reserved:    map[string]bool{"i-1": true},
+			want:        1,
+			wantOD:      "i-2",
+		},
+		{name: "more on-demand capacity than reserved",
+			minOnDemand: 2,
+			reserved:    map[string]bool{"i-1": true},
+			want:        2,
+			wantOD:      "i-2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{reservedInstanceIDs: tt.reserved}
+			i1 := testInstance("i-1", "m5.large", "us-east-1a", false, true)
+			i2 := testInstance("i-2", "m5.large", "us-east-1b", false, true)
+			i1.region, i2.region = r, r
+
+			a := &autoScalingGroup{
+				name:        "test-asg",
+				region:      r,
+				minOnDemand: tt.minOnDemand,
+				instances: makeInstancesWithCatalog(map[string]*instance{
+					"i-1": i1,
+				}),
+			}
+			a.keepReservedInstances()
+			if a.minOnDemand != tt.want {
+				t.Errorf("minOnDemand = %v, want %v", a.minOnDemand, tt.want)
+			}
+
+			// the reserved instances are never picked for replacement
+			a.instances.add(i2)
+			od := a.getAnyOnDemandInstance()
+			if od == nil {
+				t.Fatalf("getAnyOnDemandInstance() found no instance")
+			}
+			if len(tt.reserved) > 0 && *od.InstanceId != tt.wantOD {
+				t.Errorf("getAnyOnDemandInstance() = %v, want %v",
+					*od.InstanceId, tt.wantOD)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// the action reported once a group has no running spot instances left
+const revertedAction = "reverted to on-demand"
+
+// Revert gradually replaces the spot instances of a group with on-demand
+// instances launched from its launch configuration, one at a time. The group
+// doesn't need to be enabled.
+func Revert(cfg *Config, accountID, regionName, asgName string) error {
+
+	setupLogging(cfg)
+	prepareRun(cfg)
+
+	a, err := findAccount(cfg, accountID)
+	if err != nil {
+		return err
+	}
+
+	r, err := newRegion(context.Background(), cfg, a, regionName, cfg.priceProvider)
+	if err != nil {
+		return err
+	}
+
+	// each step replaces a spot instance, so this only protects against
+	// failing to make any progress
+	maxSteps := -1
+
+	for step := 0; step != maxSteps; step++ {
+		asg, err := r.scanGroup(asgName)
+		if err != nil {
+			return err
+		}
+		asg.loadState()
+
+		if maxSteps < 0 {
+			spot, _ := asg.alreadyRunningInstanceCount(true, "")
+			maxSteps = int(spot) + 1
+		}
+
+		action, err := asg.revertToOnDemand()
+		if err != nil {
+			return err
+		}
+		r.logger().Println(asgName, "Revert step:", action)
+
+		if action == revertedAction {
+			return nil
+		}
+	}
+	return fmt.Errorf("the group %s still has spot instances after %d steps",
+		asgName, maxSteps)
+}
+
+// revertRequested tells if the group's spot instances should be replaced with
+// on-demand instances, as requested by its tags.
+func (a *autoScalingGroup) revertRequested() bool {
+	tagValue := a.getTagValue(RevertToOnDemandTag)
+	if tagValue == nil {
+		return false
+	}
+
+	revert, err := strconv.ParseBool(*tagValue)
+	if err != nil {
+		a.region.logger().Println(a.name, "Ignoring invalid value", *tagValue, "of tag",
+			RevertToOnDemandTag)
+	}
+	return revert
+}
+
+// revertToOnDemand replaces a spot instance of the group with an on-demand
+// instance, launching the on-demand instance first if none was launched by a
+// previous run. It returns the action taken.
+func (a *autoScalingGroup) revertToOnDemand() (string, error) {
+	if err := a.cancelSpotInstanceRequests(); err != nil {
+		return "cancelling spot instance requests", err
+	}
+
+	onDemand := a.findLaunchedOnDemandInstance()
+	spot := a.getAnySpotInstance()
+
+	if onDemand == nil {
+		if spot == nil {
+			a.region.logger().Println(a.region.name, a.name, "has no running spot instances left")
+			return revertedAction, nil
+		}
+
+		if !a.region.hasTimeFor(spotRequestDuration) {
+			a.region.logger().Println(a.region.name, a.name, "Not enough time left for",
+				"launching an on-demand instance, deferring it to the next run")
+			return "deferred", nil
+		}
+
+		var err error
+		onDemand, err = a.launchOnDemandInstance(spot)
+		if err != nil {
+			return "launching on-demand instance", err
+		}
+	}
+
+	if spot == nil {
+		// the spot instances are gone since the previous run, for example
+		// after the group was scaled in, so the on-demand one isn't needed
+		a.region.logger().Println(a.name, "found no spot instances to replace with",
+			*onDemand.InstanceId, "terminating it")
+		return "terminating on-demand instance", onDemand.terminate()
+	}
+
+	if aws.StringValue(onDemand.State.Name) != ec2.InstanceStateNameRunning {
+		if err := a.waitForInstance(onDemand); err != nil {
+			return "waiting for on-demand instance", err
+		}
+	}
+
+	if !a.region.hasTimeFor(replacementDuration) {
+		a.region.logger().Println(a.region.name, a.name, "Not enough time left for attaching",
+			"on-demand instance", *onDemand.InstanceId, "deferring it to the next run")
+		return "deferred", nil
+	}
+
+	// prefer replacing a spot instance from the same AZ
+	if inst := a.getInstance(onDemand.Placement.AvailabilityZone, false, false); inst != nil {
+		spot = inst
+	}
+
+	a.region.logger().Println(a.name, "replacing spot instance", *spot.InstanceId,
+		"with on-demand instance", *onDemand.InstanceId)
+
+	return "replacing spot instance", a.swapInstances(onDemand.InstanceId, spot)
+}
+
+// cancelSpotInstanceRequests cancels the open and active spot instance requests
+// made for the group before reverting it, and terminates their instances which
+// aren't attached to the group, since they would never be attached anymore.
+func (a *autoScalingGroup) cancelSpotInstanceRequests() error {
+	var requests []*spotInstanceRequest
+	var ids []*string
+
+	for _, req := range a.spotInstanceRequests {
+		switch aws.StringValue(req.State) {
+		case ec2.SpotInstanceStateOpen, ec2.SpotInstanceStateActive:
+			requests = append(requests, req)
+			ids = append(ids, req.SpotInstanceRequestId)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	a.region.logger().Println(a.name, "Cancelling", len(ids), "spot instance requests")
+
+	svc := a.region.services.ec2
+	if _, err := svc.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: ids,
+	}); err != nil {
+		a.region.logger().Println(a.name, "Failed to cancel spot instance requests", err.Error())
+		return err
+	}
+
+	// the open requests may have been fulfilled meanwhile
+	var unattached []*string
+	for _, req := range requests {
+		if err := req.reload(); err != nil {
+			a.region.logger().Println(a.name, "Failed to reload spot instance request",
+				*req.SpotInstanceRequestId, err.Error())
+		}
+
+		if req.InstanceId != nil && a.instances.get(*req.InstanceId) == nil {
+			unattached = append(unattached, req.InstanceId)
+		}
+	}
+
+	if len(unattached) == 0 {
+		return nil
+	}
+
+	a.region.logger().Println(a.name, "Terminating the unattached spot instances",
+		aws.StringValueSlice(unattached))
+
+	_, err := svc.TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: unattached,
+	})
+	return err
+}
+
+// findLaunchedOnDemandInstance returns the on-demand instance launched for
+// the group by a previous revert step, which wasn't attached yet.
+func (a *autoScalingGroup) findLaunchedOnDemandInstance() *instance {
+	for i := range a.region.instances.instances() {
+		if i.isSpot() || a.instances.get(*i.InstanceId) != nil {
+			continue
+		}
+
+		for _, tag := range i.Tags {
+			if aws.StringValue(tag.Key) == "launched-for-asg" &&
+				aws.StringValue(tag.Value) == a.name {
+				return i
+			}
+		}
+	}
+	return nil
+}
+
+// launchOnDemandInstance launches an on-demand instance from the group's
+// launch configuration, in the subnet of the spot instance it will replace.
+func (a *autoScalingGroup) launchOnDemandInstance(spot *instance) (*instance, error) {
+	lc := a.getLaunchConfiguration()
+	if lc == nil {
+		return nil, errors.New("couldn't find the launch configuration of the group")
+	}
+
+	instanceType, ok := a.getInstanceTypeInformation()[aws.StringValue(lc.InstanceType)]
+	if !ok {
+		instanceType.instanceType = aws.StringValue(lc.InstanceType)
+	}
+
+	az := *spot.Placement.AvailabilityZone
+
+	input, err := lc.convertLaunchConfigurationToRunInstancesInput(
+		spot, instanceType, &a.region.services, az)
+	if err != nil {
+		return nil, err
+	}
+
+	// tag it at launch, so the next run finds it if it's not attached by now
+	tags := append([]*ec2.Tag{{
+		Key:   aws.String("launched-for-asg"),
+		Value: aws.String(a.name),
+	}}, a.propagatedInstanceTags()...)
+
+	input.TagSpecifications = []*ec2.TagSpecification{{
+		ResourceType: aws.String(ec2.ResourceTypeInstance),
+		Tags:         tags,
+	}}
+
+	a.region.logger().Println(a.name, "Launching on-demand", instanceType.instanceType,
+		"instance in", az)
+
+	resp, err := a.region.services.ec2.RunInstances(input)
+	if err != nil {
+		a.region.logger().Println(a.name, "Failed to launch on-demand instance", err.Error())
+		return nil, err
+	}
+
+	inst := resp.Instances[0]
+	a.region.logger().Println(a.name, "Launched on-demand instance", *inst.InstanceId)
+
+	a.region.addInstance(inst)
+	return a.region.instances.get(*inst.InstanceId), nil
+}
+
+// waitForInstance waits for a new instance to be running before attaching it.
+func (a *autoScalingGroup) waitForInstance(i *instance) error {
+	a.region.logger().Println(a.name, "Waiting for instance", *i.InstanceId, "to be running")
+
+	// stop waiting early enough for attaching the instance before the
+	// deadline, the next run resumes waiting for it
+	ctx, cancel := a.region.waitContext(replacementDuration)
+	defer cancel()
+
+	err := a.region.services.ec2.WaitUntilInstanceRunningWithContext(ctx,
+		&ec2.DescribeInstancesInput{InstanceIds: []*string{i.InstanceId}},
+		waiterClock(a.region.conf.clock()))
+	if err != nil {
+		a.region.logger().Println(a.name, "Error waiting for instance:", err.Error())
+	}
+	return err
+}
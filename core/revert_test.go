@@ -0,0 +1,214 @@
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestRevertRequested(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     []*autoscaling.TagDescription
+		expected bool
+	}{
+		{name: "no tag",
+			expected: false,
+		},
+		{name: "revert requested",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(RevertToOnDemandTag), Value: aws.String("true")},
+			},
+			expected: true,
+		},
+		{name: "revert disabled",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(RevertToOnDemandTag), Value: aws.String("false")},
+			},
+			expected: false,
+		},
+		{name: "invalid value",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(RevertToOnDemandTag), Value: aws.String("yes please")},
+			},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:  "asg",
+				Group: &autoscaling.Group{Tags: tt.tags},
+			}
+			if got := a.revertRequested(); got != tt.expected {
+				t.Errorf("revertRequested() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRevertToOnDemand(t *testing.T) {
+	running := &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)}
+	pending := &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNamePending)}
+
+	spotInstance := func(id, az string) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId:        aws.String(id),
+			InstanceType:      aws.String("m5.large"),
+			State:             running,
+			Placement:         &ec2.Placement{AvailabilityZone: aws.String(az)},
+			InstanceLifecycle: aws.String("spot"),
+			SubnetId:          aws.String("subnet-" + az),
+		}
+	}
+
+	launched := &ec2.Instance{
+		InstanceId: aws.String("i-launched"),
+		State:      running,
+		Placement:  &ec2.Placement{AvailabilityZone: aws.String("eu-west-1b")},
+		Tags: []*ec2.Tag{
+			{Key: aws.String("launched-for-asg"), Value: aws.String("asg")},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		groupInstances []*ec2.Instance
+		otherInstances []*ec2.Instance
+		ec2            mockEC2
+		wantAction     string
+		wantErr        bool
+		wantLaunches   int
+	}{
+		{name: "no spot instances left",
+			groupInstances: []*ec2.Instance{{
+				InstanceId:   aws.String("i-od"),
+				InstanceType: aws.String("m5.large"),
+				State:        running,
+				Placement:    &ec2.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+			}},
+			wantAction: revertedAction,
+		},
+		{name: "launch and attach an on-demand instance",
+			groupInstances: []*ec2.Instance{spotInstance("i-spot", "eu-west-1a")},
+			ec2: mockEC2{
+				rio: &ec2.Reservation{Instances: []*ec2.Instance{{
+					InstanceId:   aws.String("i-new"),
+					InstanceType: aws.String("m5.large"),
+					State:        pending,
+					Placement:    &ec2.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+				}}},
+			},
+			wantAction:   "replacing spot instance",
+			wantLaunches: 1,
+		},
+		{name: "failure to launch the on-demand instance",
+			groupInstances: []*ec2.Instance{spotInstance("i-spot", "eu-west-1a")},
+			ec2:            mockEC2{rierr: errors.New("insufficient capacity")},
+			wantAction:     "launching on-demand instance",
+			wantErr:        true,
+			wantLaunches:   1,
+		},
+		{name: "on-demand instance still starting",
+			groupInstances: []*ec2.Instance{spotInstance("i-spot", "eu-west-1a")},
+			ec2: mockEC2{
+				rio: &ec2.Reservation{Instances: []*ec2.Instance{{
+					InstanceId:   aws.String("i-new"),
+					InstanceType: aws.String("m5.large"),
+					State:        pending,
+					Placement:    &ec2.Placement{AvailabilityZone: aws.String("eu-west-1a")},
+				}}},
+				wuirerr: errors.New("exceeded wait attempts"),
+			},
+			wantAction:   "waiting for on-demand instance",
+			wantErr:      true,
+			wantLaunches: 1,
+		},
+		{name: "attach the on-demand instance launched by a previous run",
+			groupInstances: []*ec2.Instance{
+				spotInstance("i-spot-a", "eu-west-1a"),
+				spotInstance("i-spot-b", "eu-west-1b"),
+			},
+			otherInstances: []*ec2.Instance{launched},
+			wantAction:     "replacing spot instance",
+		},
+		{name: "terminate the on-demand instance no longer needed",
+			otherInstances: []*ec2.Instance{launched},
+			wantAction:     "terminating on-demand instance",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var launches []*ec2.RunInstancesInput
+			svc := tt.ec2
+			svc.rii = &launches
+
+			r := &region{
+				name: "eu-west-1",
+				conf: &Config{},
+				services: connections{
+					ec2:         svc,
+					autoScaling: mockASG{},
+				},
+				instances: makeInstances(),
+				instanceTypeInformation: map[string]instanceTypeInformation{
+					"m5.large": {instanceType: "m5.large"},
+				},
+			}
+
+			a := &autoScalingGroup{
+				name:   "asg",
+				region: r,
+				Group: &autoscaling.Group{
+					MinSize:                 aws.Int64(1),
+					MaxSize:                 aws.Int64(3),
+					DesiredCapacity:         aws.Int64(2),
+					LaunchConfigurationName: aws.String("lc"),
+				},
+				launchConfiguration: &launchConfiguration{
+					LaunchConfiguration: &autoscaling.LaunchConfiguration{
+						ImageId:      aws.String("ami-1"),
+						InstanceType: aws.String("m5.large"),
+					},
+				},
+				instances: makeInstances(),
+			}
+
+			for _, inst := range tt.groupInstances {
+				r.addInstance(inst)
+				a.instances.add(r.instances.get(*inst.InstanceId))
+			}
+			for _, inst := range tt.otherInstances {
+				r.instances.add(&instance{Instance: inst, region: r})
+			}
+
+			action, err := a.revertToOnDemand()
+
+			if action != tt.wantAction {
+				t.Errorf("revertToOnDemand() action = %q, want %q", action, tt.wantAction)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("revertToOnDemand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(launches) != tt.wantLaunches {
+				t.Fatalf("revertToOnDemand() launched %d instances, want %d",
+					len(launches), tt.wantLaunches)
+			}
+
+			for _, in := range launches {
+				if *in.InstanceType != "m5.large" ||
+					*in.Placement.AvailabilityZone != "eu-west-1a" ||
+					*in.NetworkInterfaces[0].SubnetId != "subnet-eu-west-1a" {
+					t.Errorf("revertToOnDemand() launched %v", in)
+				}
+				tags := in.TagSpecifications[0].Tags
+				if *tags[0].Key != "launched-for-asg" || *tags[0].Value != "asg" {
+					t.Errorf("revertToOnDemand() tagged the instance with %v", tags)
+				}
+			}
+		})
+	}
+}
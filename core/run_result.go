@@ -0,0 +1,181 @@
+package autospotting
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunResult is the outcome of a run, for each of the processed regions and
+// AutoScaling groups.
+type RunResult struct {
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+	Regions  []RegionResult `json:"regions"`
+
+	// failures not specific to a region, such as listing the accounts
+	Errors []string `json:"errors,omitempty"`
+}
+
+// RegionResult is the outcome of processing a region of an account.
+type RegionResult struct {
+	Account string        `json:"account,omitempty"`
+	Region  string        `json:"region"`
+	Groups  []GroupStatus `json:"groups"`
+	Errors  []string      `json:"errors,omitempty"`
+}
+
+// RunError aggregates all the failures of a run.
+type RunError struct {
+	Errors []string
+}
+
+func (e *RunError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0]
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(e.Errors),
+		strings.Join(e.Errors, "; "))
+}
+
+// Err returns the failures of the run and of its groups as a *RunError, or
+// nil if there were none.
+func (r *RunResult) Err() error {
+	var errs []string
+
+	errs = append(errs, r.Errors...)
+	for _, region := range r.Regions {
+		prefix := region.Region
+		if region.Account != "" {
+			prefix = region.Account + " " + prefix
+		}
+
+		for _, msg := range region.Errors {
+			errs = append(errs, prefix+": "+msg)
+		}
+		for _, g := range region.Groups {
+			if g.Error != "" {
+				errs = append(errs, prefix+" "+g.Name+": "+g.Error)
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &RunError{Errors: errs}
+}
+
+// RunErr returns the failures of the whole run, such as listing the accounts,
+// as a *RunError, or nil if there were none. The failures of the regions and
+// of the groups aren't included.
+func (r *RunResult) RunErr() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return &RunError{Errors: r.Errors}
+}
+
+// runReport collects the result of the run in progress.
+type runReport struct {
+	sync.Mutex
+
+	started time.Time
+	regions map[string]*RegionResult
+	errors  []string
+}
+
+func newRunReport(started time.Time) *runReport {
+	return &runReport{
+		started: started,
+		regions: make(map[string]*RegionResult),
+	}
+}
+
+// region returns the result of a region, adding it if needed. The lock must
+// be held.
+func (rr *runReport) region(account, name string) *RegionResult {
+	key := account + "/" + name
+
+	if _, ok := rr.regions[key]; !ok {
+		rr.regions[key] = &RegionResult{Account: account, Region: name,
+			Groups: []GroupStatus{}}
+	}
+	return rr.regions[key]
+}
+
+func (rr *runReport) addRegion(account, name string) {
+	if rr == nil {
+		return
+	}
+	rr.Lock()
+	defer rr.Unlock()
+
+	rr.region(account, name)
+}
+
+func (rr *runReport) addGroup(g GroupStatus) {
+	if rr == nil {
+		return
+	}
+	rr.Lock()
+	defer rr.Unlock()
+
+	r := rr.region(g.Account, g.Region)
+	r.Groups = append(r.Groups, g)
+}
+
+// addError records a failure of a region, or of the whole run or account when
+// the region is empty.
+func (rr *runReport) addError(account, region string, err error) {
+	if rr == nil || err == nil {
+		return
+	}
+	rr.Lock()
+	defer rr.Unlock()
+
+	if region != "" {
+		r := rr.region(account, region)
+		r.Errors = append(r.Errors, err.Error())
+		return
+	}
+
+	msg := err.Error()
+	if account != "" {
+		msg = account + ": " + msg
+	}
+	rr.errors = append(rr.errors, msg)
+}
+
+// result returns the collected result, with the regions sorted by account
+// and name and their groups sorted by name.
+func (rr *runReport) result(finished time.Time) *RunResult {
+	rr.Lock()
+	defer rr.Unlock()
+
+	res := &RunResult{
+		Started:  rr.started,
+		Finished: finished,
+		Regions:  []RegionResult{},
+		Errors:   rr.errors,
+	}
+
+	for _, r := range rr.regions {
+		groups := r.Groups
+		sort.Slice(groups, func(i, j int) bool {
+			return groups[i].Name < groups[j].Name
+		})
+		res.Regions = append(res.Regions, *r)
+	}
+
+	sort.Slice(res.Regions, func(i, j int) bool {
+		a, b := res.Regions[i], res.Regions[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		return a.Region < b.Region
+	})
+	return res
+}
@@ -0,0 +1,107 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRunReportResult(t *testing.T) {
+	started := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	rr := newRunReport(started)
+	rr.addRegion("", "us-east-1")
+	rr.addGroup(GroupStatus{Region: "eu-west-1", Name: "b", Action: "none"})
+	rr.addGroup(GroupStatus{Region: "eu-west-1", Name: "a",
+		Action: "launching spot instance", Error: "no capacity"})
+	rr.addError("", "ap-south-1", errors.New("couldn't create a session"))
+	rr.addError("123456789012", "", errors.New("access denied"))
+	rr.addError("", "eu-west-1", nil)
+
+	res := rr.result(started.Add(time.Minute))
+
+	var regions, groups []string
+	for _, r := range res.Regions {
+		regions = append(regions, r.Region)
+		for _, g := range r.Groups {
+			groups = append(groups, g.Name)
+		}
+	}
+
+	if !reflect.DeepEqual(regions, []string{"ap-south-1", "eu-west-1", "us-east-1"}) ||
+		!reflect.DeepEqual(groups, []string{"a", "b"}) {
+		t.Errorf("result() regions = %v, groups = %v", regions, groups)
+	}
+
+	if !res.Started.Equal(started) || !res.Finished.Equal(started.Add(time.Minute)) {
+		t.Errorf("result() started at %v and finished at %v", res.Started, res.Finished)
+	}
+
+	err, ok := res.Err().(*RunError)
+	if !ok {
+		t.Fatalf("Err() = %v, want a *RunError", res.Err())
+	}
+
+	want := []string{
+		"123456789012: access denied",
+		"ap-south-1: couldn't create a session",
+		"eu-west-1 a: no capacity",
+	}
+	if !reflect.DeepEqual(err.Errors, want) {
+		t.Errorf("Err() errors = %q, want %q", err.Errors, want)
+	}
+	if err.Error() != "3 errors occurred: "+want[0]+"; "+want[1]+"; "+want[2] {
+		t.Errorf("Error() = %q", err.Error())
+	}
+}
+
+func TestRunResultErr(t *testing.T) {
+	tests := []struct {
+		name   string
+		result RunResult
+		want   string
+	}{
+		{name: "no errors",
+			result: RunResult{Regions: []RegionResult{{Region: "eu-west-1",
+				Groups: []GroupStatus{{Name: "asg", Action: "none"}}}}},
+		},
+		{name: "single error in an account",
+			result: RunResult{Regions: []RegionResult{{Account: "123456789012",
+				Region: "eu-west-1", Errors: []string{"throttled"}}}},
+			want: "123456789012 eu-west-1: throttled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.result.Err()
+			if (err == nil) != (tt.want == "") || (err != nil && err.Error() != tt.want) {
+				t.Errorf("Err() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunResultRunErr(t *testing.T) {
+	res := RunResult{Regions: []RegionResult{{Region: "eu-west-1",
+		Errors: []string{"throttled"},
+		Groups: []GroupStatus{{Name: "asg", Error: "no capacity"}}}}}
+
+	if err := res.RunErr(); err != nil {
+		t.Errorf("RunErr() of region and group failures = %v, want nil", err)
+	}
+
+	res.Errors = []string{"access denied"}
+	if err := res.RunErr(); err == nil || err.Error() != "access denied" {
+		t.Errorf("RunErr() = %v, want access denied", err)
+	}
+}
+
+func TestRunReportNil(t *testing.T) {
+	var rr *runReport
+
+	// the runs of the other commands don't collect their results
+	rr.addRegion("", "eu-west-1")
+	rr.addGroup(GroupStatus{Region: "eu-west-1", Name: "asg"})
+	rr.addError("", "eu-west-1", errors.New("failed"))
+}
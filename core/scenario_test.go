@@ -0,0 +1,300 @@
+package autospotting
+
+import (
+	"testing"
+)
+
+const scenarioInstanceData = `[
+	{
+		"instance_type": "m4.large", "vCPU": 2, "memory": 8,
+		"linux_virtualization_types": ["HVM"], "ebs_optimized": true,
+		"family": "General purpose", "generation": "current",
+		"pricing": {"eu-west-1": {"linux": {"ondemand": "0.111"}, "ebs": "0.0"}}
+	},
+	{
+		"instance_type": "m5.large", "vCPU": 2, "memory": 8,
+		"linux_virtualization_types": ["HVM"], "ebs_optimized": true,
+		"family": "General purpose", "generation": "current",
+		"pricing": {"eu-west-1": {"linux": {"ondemand": "0.107"}, "ebs": "0.0"}}
+	},
+	{
+		"instance_type": "m5.xlarge", "vCPU": 4, "memory": 16,
+		"linux_virtualization_types": ["HVM"], "ebs_optimized": true,
+		"family": "General purpose", "generation": "current",
+		"pricing": {"eu-west-1": {"linux": {"ondemand": "0.214"}, "ebs": "0.0"}}
+	}
+]`
+
+// scenarioStep changes the environment, then runs the given number of times.
+type scenarioStep struct {
+	change func(f *fakeAWS)
+	runs   int
+}
+
+// runScenario processes the region the given number of times, each run
+// starting from scratch like the Lambda function invocations.
+func runScenario(cfg *Config, f *fakeAWS, runs int) {
+	for n := 0; n < runs; n++ {
+		r := &region{name: f.region, conf: cfg, services: f.connections()}
+		r.processRegion()
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	data, err := parseInstanceData([]byte(scenarioInstanceData))
+	if err != nil {
+		t.Fatalf("parseInstanceData() error = %v", err)
+	}
+
+	azs := []string{"eu-west-1a", "eu-west-1b"}
+	enabled := map[string]string{"spot-enabled": "true"}
+
+	// the spot prices of all the instance types in all the AZs
+	setSpotPrices := func(f *fakeAWS, prices map[string]float64) {
+		for instanceType, price := range prices {
+			for _, az := range azs {
+				f.setSpotPrice(instanceType, az, price)
+			}
+		}
+	}
+
+	cheap := map[string]float64{"m4.large": 0.03, "m5.large": 0.04, "m5.xlarge": 0.07}
+
+	tests := []struct {
+		name  string
+		setup func(f *fakeAWS)
+		steps []scenarioStep
+
+		wantOnDemand int
+		wantSpot     int
+
+		// the instance type of the spot or on-demand instances
+		wantSpotType     string
+		wantOnDemandType string
+
+		wantDesired int64
+		wantMax     int64
+	}{
+		{name: "static group keeping an on-demand instance",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, cheap)
+				f.addGroup("web", "m5.large", 3, 3, 3, azs, map[string]string{
+					"spot-enabled":     "true",
+					OnDemandNumberLong: "1",
+				})
+			},
+			steps:            []scenarioStep{{runs: 8}},
+			wantOnDemand:     1,
+			wantSpot:         2,
+			wantSpotType:     "m4.large",
+			wantOnDemandType: "m5.large",
+			wantDesired:      3,
+			wantMax:          3,
+		},
+		{name: "priority strategy preferring a more expensive type",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, cheap)
+				f.addGroup("web", "m5.large", 2, 2, 4, azs, map[string]string{
+					"spot-enabled":            "true",
+					SelectionStrategyTag:      PriorityStrategy,
+					PreferredInstanceTypesTag: "m5.*",
+				})
+			},
+			steps:        []scenarioStep{{runs: 6}},
+			wantSpot:     2,
+			wantSpotType: "m5.large",
+			wantDesired:  2,
+			wantMax:      4,
+		},
+		{name: "frequently interrupted instance type excluded",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, cheap)
+				f.addGroup("web", "m5.large", 2, 2, 4, azs, map[string]string{
+					"spot-enabled":         "true",
+					MaxInterruptionBandTag: "10-15%",
+				})
+			},
+			steps:        []scenarioStep{{runs: 6}},
+			wantSpot:     2,
+			wantSpotType: "m5.large",
+			wantDesired:  2,
+			wantMax:      4,
+		},
+		{name: "group running at its minimum capacity",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, cheap)
+				f.addGroup("web", "m5.large", 2, 2, 4, azs, enabled)
+			},
+			steps:        []scenarioStep{{runs: 6}},
+			wantSpot:     2,
+			wantSpotType: "m4.large",
+			wantDesired:  2,
+			wantMax:      4,
+		},
+		{name: "group not matching the tag filters",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, cheap)
+				f.addGroup("web", "m5.large", 1, 2, 4, azs, nil)
+			},
+			steps:            []scenarioStep{{runs: 4}},
+			wantOnDemand:     2,
+			wantOnDemandType: "m5.large",
+			wantDesired:      2,
+			wantMax:          4,
+		},
+		{name: "spot prices above the on-demand price",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, map[string]float64{"m4.large": 0.2, "m5.large": 0.2, "m5.xlarge": 0.4})
+				f.addGroup("web", "m5.large", 1, 2, 4, azs, enabled)
+			},
+			steps:            []scenarioStep{{runs: 4}},
+			wantOnDemand:     2,
+			wantOnDemandType: "m5.large",
+			wantDesired:      2,
+			wantMax:          4,
+		},
+		{name: "no spot capacity",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, cheap)
+				for instanceType := range cheap {
+					f.noSpotCapacity[instanceType] = true
+				}
+				f.addGroup("web", "m5.large", 1, 2, 4, azs, enabled)
+			},
+			steps:            []scenarioStep{{runs: 4}},
+			wantOnDemand:     2,
+			wantOnDemandType: "m5.large",
+			wantDesired:      2,
+			wantMax:          4,
+		},
+		{name: "interrupted spot instance replaced again",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, cheap)
+				f.addGroup("web", "m5.large", 1, 2, 4, azs, enabled)
+			},
+			steps: []scenarioStep{
+				{runs: 6},
+				{change: func(f *fakeAWS) {
+					_, spot := f.groupInstances("web")
+					f.interrupt(*spot[0].InstanceId)
+				}, runs: 6},
+			},
+			wantSpot:     2,
+			wantSpotType: "m4.large",
+			wantDesired:  2,
+			wantMax:      4,
+		},
+		{name: "cheaper instance type after a price change",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, map[string]float64{"m4.large": 0.2, "m5.large": 0.2, "m5.xlarge": 0.05})
+				f.addGroup("web", "m5.large", 1, 2, 4, azs, enabled)
+			},
+			steps:        []scenarioStep{{runs: 6}},
+			wantSpot:     2,
+			wantSpotType: "m5.xlarge",
+			wantDesired:  2,
+			wantMax:      4,
+		},
+		{name: "reverted to on-demand",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, cheap)
+				f.addGroup("web", "m5.large", 2, 3, 3, azs, enabled)
+			},
+			steps: []scenarioStep{
+				{runs: 8},
+				{change: func(f *fakeAWS) {
+					f.setGroupTag("web", RevertToOnDemandTag, "true")
+				}, runs: 4},
+			},
+			wantOnDemand:     3,
+			wantOnDemandType: "m5.large",
+			wantDesired:      3,
+			wantMax:          3,
+		},
+		{name: "reverted while a spot instance is being launched",
+			setup: func(f *fakeAWS) {
+				setSpotPrices(f, cheap)
+				f.addGroup("web", "m5.large", 2, 2, 2, azs, enabled)
+			},
+			steps: []scenarioStep{
+				// launches a spot instance, attached by the next run
+				{runs: 1},
+				{change: func(f *fakeAWS) {
+					f.setGroupTag("web", RevertToOnDemandTag, "true")
+				}, runs: 2},
+			},
+			wantOnDemand:     2,
+			wantOnDemandType: "m5.large",
+			wantDesired:      2,
+			wantMax:          2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				InstanceData:              data,
+				SpotProductDescription:    DefaultSpotProductDescription,
+				OnDemandPriceMultiplier:   1,
+				BiddingPolicy:             DefaultBiddingPolicy,
+				SpotPriceBufferPercentage: DefaultSpotPriceBufferPercentage,
+				groupPool:                 newWorkerPool(1),
+
+				// only used by the groups limiting the interruption band
+				InterruptionData: &InterruptionData{
+					SpotAdvisor: map[string]map[string]map[string]InterruptionStats{
+						"eu-west-1": {"Linux": {
+							"m4.large": {Range: 3},
+							"m5.large": {Range: 0},
+						}},
+					},
+				},
+			}
+
+			f := newFakeAWS("eu-west-1")
+			tt.setup(f)
+
+			for _, step := range tt.steps {
+				if step.change != nil {
+					step.change(f)
+				}
+				runScenario(cfg, f, step.runs)
+			}
+
+			onDemand, spot := f.groupInstances("web")
+
+			if len(onDemand) != tt.wantOnDemand || len(spot) != tt.wantSpot {
+				t.Errorf("the group has %d on-demand and %d spot instances, want %d and %d",
+					len(onDemand), len(spot), tt.wantOnDemand, tt.wantSpot)
+			}
+
+			for _, i := range spot {
+				if *i.InstanceType != tt.wantSpotType {
+					t.Errorf("spot instance %s is %s, want %s",
+						*i.InstanceId, *i.InstanceType, tt.wantSpotType)
+				}
+				if !hasTag(i.Tags, "spot-enabled", "true") {
+					t.Errorf("spot instance %s is missing the group's tags: %v",
+						*i.InstanceId, i.Tags)
+				}
+			}
+			for _, i := range onDemand {
+				if *i.InstanceType != tt.wantOnDemandType {
+					t.Errorf("on-demand instance %s is %s, want %s",
+						*i.InstanceId, *i.InstanceType, tt.wantOnDemandType)
+				}
+			}
+
+			g := f.groups["web"]
+			if *g.DesiredCapacity != tt.wantDesired || *g.MaxSize != tt.wantMax {
+				t.Errorf("the group's desired capacity and MaxSize are %d and %d, want %d and %d",
+					*g.DesiredCapacity, *g.MaxSize, tt.wantDesired, tt.wantMax)
+			}
+
+			// all the instances left running are part of the group
+			if running := f.runningInstances(); len(running) != len(onDemand)+len(spot) {
+				t.Errorf("running instances = %v, want only the %d of the group",
+					running, len(onDemand)+len(spot))
+			}
+		})
+	}
+}
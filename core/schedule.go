@@ -0,0 +1,245 @@
+package autospotting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSchedule is how often the daemon mode runs by default, matching the
+// default frequency of the Lambda function.
+const DefaultSchedule = "5m"
+
+// Schedule determines when the next run should start.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// intervalSchedule runs at a fixed interval.
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(s))
+}
+
+// cronSchedule runs at the times matching a cron expression with the usual
+// minute, hour, day of month, month and day of week fields.
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+
+	// when both days are restricted, matching either of them is enough
+	domRestricted, dowRestricted bool
+}
+
+// cronSearchLimit bounds the search for the next run of expressions which
+// never match, such as the 31st of February.
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// ParseSchedule parses either an interval such as "5m" or a cron expression
+// such as "*/10 8-18 * * 1-5".
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, errors.New("the schedule interval must be positive")
+		}
+		return intervalSchedule(d), nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expected an interval or a "+
+			"cron expression with 5 fields", spec)
+	}
+
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := make([]map[int]bool, 5)
+
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s", spec, err.Error())
+		}
+		sets[i] = set
+	}
+
+	// both 0 and 7 mean Sunday
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+
+	return &cronSchedule{
+		minute:        sets[0],
+		hour:          sets[1],
+		dom:           sets[2],
+		month:         sets[3],
+		dow:           sets[4],
+		domRestricted: fields[2] != "*",
+		dowRestricted: fields[4] != "*",
+	}, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps,
+// such as "*/15", "1-5" or "0,30".
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("invalid step in %s", part)
+			}
+			step, part = s, part[:i]
+		}
+
+		from, to := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			f, err1 := strconv.Atoi(bounds[0])
+			t, err2 := strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid range %s", part)
+			}
+			from, to = f, t
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %s", part)
+			}
+			from, to = v, v
+			if step > 1 {
+				to = max
+			}
+		}
+
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("%s is out of the %d-%d range", part, min, max)
+		}
+
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// Next returns the first matching minute after the given time, or the zero
+// time if the expression never matches.
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		switch {
+		case !s.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !s.hour[t.Hour()]:
+			// truncating in the location of the time, which may be offset
+			// from UTC by a fraction of an hour
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// RunDaemon keeps processing all the regions according to the configured
+// schedule, until the context is cancelled. A cancellation during a run stops
+// new instance replacements from being started, but lets the ones in progress
+// finish before returning. The status and control API is served meanwhile if
+// its address is configured.
+func RunDaemon(ctx context.Context, cfg *Config) error {
+
+	setupLogging(cfg)
+
+	schedule, err := ParseSchedule(cfg.Schedule)
+	if err != nil {
+		return err
+	}
+
+	if cfg.status == nil {
+		cfg.status = newStatusRecorder()
+	}
+	cfg.runLock = newWorkerPool(1)
+
+	if cfg.StatusAddress != "" {
+		listener, err := net.Listen("tcp", cfg.StatusAddress)
+		if err != nil {
+			return err
+		}
+
+		serverCtx, stopServer := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			serveStatus(serverCtx, cfg, listener)
+			close(stopped)
+		}()
+
+		defer func() {
+			stopServer()
+			<-stopped
+		}()
+	}
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	// the interval schedules also run right after starting
+	_, immediate := schedule.(intervalSchedule)
+
+	for {
+		next := schedule.Next(time.Now())
+		if immediate {
+			next, immediate = time.Now(), false
+		}
+
+		if next.IsZero() {
+			return errors.New("the schedule " + cfg.Schedule + " never runs")
+		}
+
+		if cfg.ScheduleJitter > 0 {
+			next = next.Add(time.Duration(random.Int63n(int64(cfg.ScheduleJitter))))
+		}
+
+		logger.Println("Next run scheduled at", next.Format(time.RFC3339))
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(next)):
+		}
+
+		if ctx.Err() != nil {
+			logger.Println("Stopping the daemon")
+			return nil
+		}
+
+		cfg.runLock.acquire()
+		if _, err := RunContext(ctx, cfg); err != nil {
+			logger.Println("The run failed:", err.Error())
+		}
+		cfg.runLock.release()
+	}
+}
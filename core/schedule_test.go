@@ -0,0 +1,138 @@
+package autospotting
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr bool
+	}{
+		{name: "interval", spec: "5m"},
+		{name: "cron expression", spec: "*/10 8-18 * * 1-5"},
+		{name: "cron lists and steps", spec: "0,30 */2 1,15 1-12/3 7"},
+		{name: "negative interval", spec: "-5m", wantErr: true},
+		{name: "too few fields", spec: "* * * *", wantErr: true},
+		{name: "value out of range", spec: "60 * * * *", wantErr: true},
+		{name: "inverted range", spec: "* 18-8 * * *", wantErr: true},
+		{name: "invalid step", spec: "*/0 * * * *", wantErr: true},
+		{name: "invalid value", spec: "* * * jan *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseSchedule(tt.spec); (err != nil) != tt.wantErr {
+				t.Errorf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2018, 3, 14, 17, 55, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		spec string
+		want time.Time
+	}{
+		{name: "interval",
+			spec: "10m",
+			want: now.Add(10 * time.Minute),
+		},
+		{name: "every ten minutes during working hours",
+			spec: "*/10 8-18 * * 1-5",
+			want: time.Date(2018, 3, 14, 18, 0, 0, 0, time.UTC),
+		},
+		{name: "next working day",
+			spec: "0 8 * * 1-5",
+			want: time.Date(2018, 3, 15, 8, 0, 0, 0, time.UTC),
+		},
+		{name: "sunday as 7",
+			spec: "0 0 * * 7",
+			want: time.Date(2018, 3, 18, 0, 0, 0, 0, time.UTC),
+		},
+		{name: "first day of the next month",
+			spec: "30 2 1 * *",
+			want: time.Date(2018, 4, 1, 2, 30, 0, 0, time.UTC),
+		},
+		{name: "either day of month or day of week",
+			spec: "0 0 20 * 5",
+			want: time.Date(2018, 3, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{name: "never matching",
+			spec: "0 0 31 2 *",
+			want: time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule() error = %v", err)
+			}
+			if got := s.Next(now); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNextHalfHourOffset(t *testing.T) {
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+
+	tests := []struct {
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{spec: "0 11 * * *",
+			after: time.Date(2018, 3, 14, 9, 15, 0, 0, kolkata),
+			want:  time.Date(2018, 3, 14, 11, 0, 0, 0, kolkata),
+		},
+		{spec: "*/10 8-18 * * 1-5",
+			after: time.Date(2018, 3, 14, 7, 5, 0, 0, kolkata),
+			want:  time.Date(2018, 3, 14, 8, 0, 0, 0, kolkata),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule() error = %v", err)
+			}
+			if got := s.Next(tt.after); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDaemon(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		schedule string
+		wantErr  bool
+	}{
+		{name: "stops when cancelled", schedule: "0 * * * *"},
+		{name: "invalid schedule", schedule: "foo", wantErr: true},
+		{name: "never running schedule", schedule: "0 0 31 2 *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Schedule: tt.schedule, LogFile: ioutil.Discard}
+
+			if err := RunDaemon(ctx, cfg); (err != nil) != tt.wantErr {
+				t.Errorf("RunDaemon() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package autospotting
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+)
+
+// The instance selection strategies, which choose the spot instance type
+// among the compatible candidates. They can be set globally and overridden on
+// a per-group basis using the SelectionStrategyTag.
+const (
+	// CheapestStrategy chooses the candidate having the lowest price
+	CheapestStrategy = "cheapest"
+
+	// CheapestPerVCPUStrategy chooses the candidate having the lowest price
+	// per vCPU
+	CheapestPerVCPUStrategy = "cheapest-per-vcpu"
+
+	// CheapestPerGiBStrategy chooses the candidate having the lowest price per
+	// GiB of memory
+	CheapestPerGiBStrategy = "cheapest-per-gib"
+
+	// LowestInterruptionRiskStrategy chooses the candidate in the lowest
+	// interruption frequency band of the region, the types missing from the
+	// interruption data coming last
+	LowestInterruptionRiskStrategy = "lowest-interruption-risk"
+
+	// PriorityStrategy chooses the candidates in the order given by the
+	// PreferredInstanceTypesTag of the group, the types not listed there
+	// coming last
+	PriorityStrategy = "priority"
+
+	// DefaultSelectionStrategy is the strategy used unless configured
+	// otherwise
+	DefaultSelectionStrategy = CheapestStrategy
+)
+
+// spotCandidate is a compatible spot instance type, with the price it would
+// have in the availability zone of the replaced instance, and its interruption
+// frequency band, if known.
+type spotCandidate struct {
+	instanceTypeInformation
+	price float64
+
+	band    int
+	hasBand bool
+
+	// the price used for ranking the candidates, penalized according to the
+	// interruption frequency band
+	rankingPrice float64
+}
+
+// newSpotCandidate evaluates a spot candidate replacing the instance.
+func (i *instance) newSpotCandidate(info instanceTypeInformation, price float64) spotCandidate {
+	c := spotCandidate{instanceTypeInformation: info, price: price, rankingPrice: price}
+
+	c.band, c.hasBand = i.interruptionBand(info.instanceType)
+	if c.hasBand {
+		penalty := i.region.conf.InterruptionPenaltyPercentage
+		c.rankingPrice = price * (1 + float64(c.band)*penalty/100)
+	}
+	return c
+}
+
+// selectionStrategy chooses the spot instance type among the compatible
+// candidates.
+type selectionStrategy interface {
+	// prefers tells if the first candidate should be chosen over the second.
+	prefers(a, b spotCandidate) bool
+}
+
+// selectionStrategies maps the strategy names to functions creating them for
+// a group.
+var selectionStrategies = map[string]func(a *autoScalingGroup) selectionStrategy{
+	CheapestStrategy: func(a *autoScalingGroup) selectionStrategy {
+		return cheapestStrategy{}
+	},
+	CheapestPerVCPUStrategy: func(a *autoScalingGroup) selectionStrategy {
+		return perUnitStrategy{units: func(c spotCandidate) float64 {
+			return float64(c.vCPU)
+		}}
+	},
+	CheapestPerGiBStrategy: func(a *autoScalingGroup) selectionStrategy {
+		return perUnitStrategy{units: func(c spotCandidate) float64 {
+			return float64(c.memory)
+		}}
+	},
+	LowestInterruptionRiskStrategy: func(a *autoScalingGroup) selectionStrategy {
+		return interruptionRiskStrategy{}
+	},
+	PriorityStrategy: func(a *autoScalingGroup) selectionStrategy {
+		var preferred []string
+		if tag := a.getTagValue(PreferredInstanceTypesTag); tag != nil {
+			preferred = splitList(*tag)
+		}
+		return priorityStrategy{preferred: preferred}
+	},
+}
+
+// cheaper is the order used by all the strategies for the candidates they
+// consider equivalent, the instance type name making it deterministic.
+func cheaper(a, b spotCandidate) bool {
+	if a.rankingPrice != b.rankingPrice {
+		return a.rankingPrice < b.rankingPrice
+	}
+	return a.instanceType < b.instanceType
+}
+
+type cheapestStrategy struct{}
+
+func (cheapestStrategy) prefers(a, b spotCandidate) bool {
+	return cheaper(a, b)
+}
+
+// perUnitStrategy compares the prices divided by a number of units of
+// capacity, the candidates without any units coming last.
+type perUnitStrategy struct {
+	units func(c spotCandidate) float64
+}
+
+func (s perUnitStrategy) unitPrice(c spotCandidate) float64 {
+	if units := s.units(c); units > 0 {
+		return c.rankingPrice / units
+	}
+	return math.Inf(1)
+}
+
+func (s perUnitStrategy) prefers(a, b spotCandidate) bool {
+	if pa, pb := s.unitPrice(a), s.unitPrice(b); pa != pb {
+		return pa < pb
+	}
+	return cheaper(a, b)
+}
+
+// interruptionRiskStrategy compares the interruption frequency bands of the
+// instance types.
+type interruptionRiskStrategy struct{}
+
+func (interruptionRiskStrategy) risk(c spotCandidate) int {
+	if c.hasBand {
+		return c.band
+	}
+	return math.MaxInt32
+}
+
+func (s interruptionRiskStrategy) prefers(a, b spotCandidate) bool {
+	if ra, rb := s.risk(a), s.risk(b); ra != rb {
+		return ra < rb
+	}
+	return cheaper(a, b)
+}
+
+// priorityStrategy compares the position of the first pattern matching each
+// instance type in the list of preferred ones.
+type priorityStrategy struct {
+	preferred []string
+}
+
+func (s priorityStrategy) position(c spotCandidate) int {
+	for n, pattern := range s.preferred {
+		if match, _ := filepath.Match(pattern, c.instanceType); match {
+			return n
+		}
+	}
+	return len(s.preferred)
+}
+
+func (s priorityStrategy) prefers(a, b spotCandidate) bool {
+	if pa, pb := s.position(a), s.position(b); pa != pb {
+		return pa < pb
+	}
+	return cheaper(a, b)
+}
+
+var (
+	errUnknownSelectionStrategy = errors.New("unknown selection strategy")
+	errNoInterruptionData       = errors.New("the interruption data given " +
+		"using interruption_data is required by this selection strategy")
+)
+
+// isValidSelectionStrategy tells if a selection strategy name is known.
+func isValidSelectionStrategy(name string) bool {
+	_, ok := selectionStrategies[name]
+	return ok
+}
+
+// checkSelectionStrategy tells why a selection strategy can't be used, the
+// lowest-interruption-risk one requiring the interruption data to be loaded.
+func (c *Config) checkSelectionStrategy(name string) error {
+	if !isValidSelectionStrategy(name) {
+		return errUnknownSelectionStrategy
+	}
+	if name == LowestInterruptionRiskStrategy && c.InterruptionData == nil {
+		return errNoInterruptionData
+	}
+	return nil
+}
+
+// getSelectionStrategyName returns the name of the strategy choosing the spot
+// instance types of the group, given by its tag or else by the global
+// configuration, the strategies which can't be used being ignored.
+func (a *autoScalingGroup) getSelectionStrategyName() string {
+	if tag := a.getTagValue(SelectionStrategyTag); tag != nil {
+		err := a.region.conf.checkSelectionStrategy(*tag)
+		if err == nil {
+			return *tag
+		}
+		a.region.logger().Println("Ignoring the selection strategy", *tag,
+			"set using the tag", SelectionStrategyTag+":", err.Error())
+	}
+
+	name := a.region.conf.SelectionStrategy
+	if err := a.region.conf.checkSelectionStrategy(name); err == nil {
+		return name
+	} else if name != "" {
+		a.region.debugLogger().Println("Ignoring the selection strategy", name+":", err.Error())
+	}
+	return DefaultSelectionStrategy
+}
+
+// getSelectionStrategy returns the strategy choosing the spot instance types
+// of the group.
+func (a *autoScalingGroup) getSelectionStrategy() selectionStrategy {
+	return selectionStrategies[a.getSelectionStrategyName()](a)
+}
@@ -0,0 +1,146 @@
+package autospotting
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestSelectionStrategies(t *testing.T) {
+	candidate := func(name string, vCPU int, memory float32, price float64, band int) spotCandidate {
+		return spotCandidate{
+			instanceTypeInformation: instanceTypeInformation{
+				instanceType: name, vCPU: vCPU, memory: memory},
+			price:        price,
+			rankingPrice: price,
+			band:         band,
+			hasBand:      band >= 0,
+		}
+	}
+
+	// r5.large is missing from the interruption data
+	candidates := []spotCandidate{
+		candidate("m5.large", 2, 8, 0.04, 0),
+		candidate("r5.large", 2, 16, 0.05, -1),
+		candidate("m4.large", 2, 8, 0.03, 3),
+		candidate("c5.xlarge", 4, 8, 0.05, 0),
+	}
+
+	tests := []struct {
+		strategy string
+		tags     []*autoscaling.TagDescription
+		want     []string
+	}{
+		{strategy: CheapestStrategy,
+			want: []string{"m4.large", "m5.large", "c5.xlarge", "r5.large"},
+		},
+		{strategy: CheapestPerVCPUStrategy,
+			want: []string{"c5.xlarge", "m4.large", "m5.large", "r5.large"},
+		},
+		{strategy: CheapestPerGiBStrategy,
+			want: []string{"r5.large", "m4.large", "m5.large", "c5.xlarge"},
+		},
+		{strategy: LowestInterruptionRiskStrategy,
+			want: []string{"m5.large", "c5.xlarge", "m4.large", "r5.large"},
+		},
+		{strategy: PriorityStrategy,
+			tags: []*autoscaling.TagDescription{{
+				Key:   aws.String(PreferredInstanceTypesTag),
+				Value: aws.String("r5.*, m5.large"),
+			}},
+			want: []string{"r5.large", "m5.large", "m4.large", "c5.xlarge"},
+		},
+		{strategy: PriorityStrategy,
+			want: []string{"m4.large", "m5.large", "c5.xlarge", "r5.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{Tags: tt.tags},
+				region: &region{conf: &Config{}},
+			}
+			s := selectionStrategies[tt.strategy](a)
+
+			sorted := append([]spotCandidate{}, candidates...)
+			sort.Slice(sorted, func(i, j int) bool {
+				return s.prefers(sorted[i], sorted[j])
+			})
+
+			var got []string
+			for _, c := range sorted {
+				got = append(got, c.instanceType)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s strategy order = %v, want %v", tt.strategy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoScalingGroupGetSelectionStrategyName(t *testing.T) {
+	tests := []struct {
+		name   string
+		global string
+		tag    *string
+		data   *InterruptionData
+		want   string
+	}{
+		{name: "default",
+			want: DefaultSelectionStrategy,
+		},
+		{name: "global strategy",
+			global: CheapestPerVCPUStrategy,
+			want:   CheapestPerVCPUStrategy,
+		},
+		{name: "tag overriding the global strategy",
+			global: CheapestPerVCPUStrategy,
+			tag:    aws.String(PriorityStrategy),
+			want:   PriorityStrategy,
+		},
+		{name: "unknown tag value ignored",
+			global: CheapestPerGiBStrategy,
+			tag:    aws.String("cheapest-per-cpu"),
+			want:   CheapestPerGiBStrategy,
+		},
+		{name: "unknown global strategy ignored",
+			global: "fastest",
+			want:   DefaultSelectionStrategy,
+		},
+		{name: "tag requiring the missing interruption data ignored",
+			global: CheapestPerGiBStrategy,
+			tag:    aws.String(LowestInterruptionRiskStrategy),
+			want:   CheapestPerGiBStrategy,
+		},
+		{name: "global strategy requiring the missing interruption data ignored",
+			global: LowestInterruptionRiskStrategy,
+			want:   DefaultSelectionStrategy,
+		},
+		{name: "strategy using the interruption data",
+			global: LowestInterruptionRiskStrategy,
+			data:   &InterruptionData{},
+			want:   LowestInterruptionRiskStrategy,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{SelectionStrategy: tt.global, InterruptionData: tt.data}
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				region: &region{conf: conf},
+			}
+			if tt.tag != nil {
+				a.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(SelectionStrategyTag), Value: tt.tag},
+				}
+			}
+
+			if got := a.getSelectionStrategyName(); got != tt.want {
+				t.Errorf("getSelectionStrategyName() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -21,7 +21,7 @@ type spotInstanceRequest struct {
 
 // This function returns an Instance ID
 func (s *spotInstanceRequest) waitForAndTagSpotInstance() error {
-	logger.Println(s.asg.name, "Waiting for spot instance for spot instance request",
+	s.region.logger().Println(s.asg.name, "Waiting for spot instance for spot instance request",
 		*s.SpotInstanceRequestId)
 
 	ec2Client := s.region.services.ec2
@@ -30,31 +30,37 @@ func (s *spotInstanceRequest) waitForAndTagSpotInstance() error {
 		SpotInstanceRequestIds: []*string{s.SpotInstanceRequestId},
 	}
 
-	err := ec2Client.WaitUntilSpotInstanceRequestFulfilled(&params)
+	// stop waiting early enough for tagging the instance before the deadline,
+	// the next run resumes waiting for it
+	ctx, cancel := s.region.waitContext(spotTaggingDuration)
+	defer cancel()
+
+	err := ec2Client.WaitUntilSpotInstanceRequestFulfilledWithContext(ctx, &params,
+		waiterClock(s.region.conf.clock()))
 	if err != nil {
-		logger.Println(s.asg.name, "Error waiting for instance:", err.Error())
+		s.region.logger().Println(s.asg.name, "Error waiting for instance:", err.Error())
 		return err
 	}
 
-	logger.Println(s.asg.name, "Done waiting for an instance.")
+	s.region.logger().Println(s.asg.name, "Done waiting for an instance.")
 
 	// Now we try to get the InstanceID of the instance we got
 	requestDetails, err := ec2Client.DescribeSpotInstanceRequests(&params)
 	if err != nil {
-		logger.Println(s.asg.name, "Failed to describe spot instance requests")
+		s.region.logger().Println(s.asg.name, "Failed to describe spot instance requests")
 		return err
 	}
 
 	// due to the waiter we can now safely assume all this data is available
 	spotInstanceID := requestDetails.SpotInstanceRequests[0].InstanceId
 
-	logger.Println(s.asg.name, "Found new spot instance", *spotInstanceID)
-	logger.Println("Tagging it to match the other instances from the group")
+	s.region.logger().Println(s.asg.name, "Found new spot instance", *spotInstanceID)
+	s.region.logger().Println("Tagging it to match the other instances from the group")
 
 	// we need to re-scan in order to have the information a
 	err = s.region.scanInstances()
 	if err != nil {
-		logger.Printf("Failed to scan instances: %s for %s\n", err, s.asg.name)
+		s.region.logger().Printf("Failed to scan instances: %s for %s\n", err, s.asg.name)
 	}
 
 	tags := s.asg.propagatedInstanceTags()
@@ -63,8 +69,12 @@ func (s *spotInstanceRequest) waitForAndTagSpotInstance() error {
 
 	if i != nil {
 		i.tag(tags, defaultTimeout)
+		if err := s.asg.carryOverCPUCredits(i); err != nil {
+			s.region.logger().Println(s.asg.name, "Couldn't carry over the CPU credits to",
+				*spotInstanceID, err.Error())
+		}
 	} else {
-		logger.Println(s.asg.name, "new spot instance", *spotInstanceID, "has disappeared")
+		s.region.logger().Println(s.asg.name, "new spot instance", *spotInstanceID, "has disappeared")
 	}
 	return nil
 }
@@ -88,7 +98,7 @@ func (s *spotInstanceRequest) tag(asgName string) error {
 		// created by spot requests which failed to be tagged.
 		if err != nil {
 			if count > 10 {
-				logger.Println(asgName,
+				s.region.logger().Println(asgName,
 					"Failed to create tags for the spot instance request after 10 retries",
 					"cancelling the spot instance request, error: ", err.Error())
 				s.reload()
@@ -104,16 +114,16 @@ func (s *spotInstanceRequest) tag(asgName string) error {
 				return err
 
 			}
-			logger.Println(asgName,
+			s.region.logger().Println(asgName,
 				"Failed to create tags for the spot instance request",
 				*s.SpotInstanceRequestId, "retrying in 5 seconds...")
 			count = count + 1
-			time.Sleep(5 * time.Second * s.region.conf.SleepMultiplier)
+			s.region.conf.clock().Sleep(5 * time.Second * s.region.conf.SleepMultiplier)
 
 		}
 	}
 
-	logger.Println(asgName, "successfully tagged spot instance request",
+	s.region.logger().Println(asgName, "successfully tagged spot instance request",
 		*s.SpotInstanceRequestId)
 
 	return nil
@@ -2,7 +2,9 @@ package autospotting
 
 import (
 	"errors"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -13,6 +15,124 @@ type spotPrices struct {
 	data     []*ec2.SpotPrice
 	conn     connections
 	duration time.Duration
+
+	// optional cache of the current prices, kept between runs
+	cache *spotPriceCache
+
+	// the clock of the run, defaulting to the system clock
+	clock Clock
+}
+
+// spotPriceHistory stores the latest known spot prices of a region and
+// product, keyed by instance type and availability zone.
+type spotPriceHistory struct {
+	lastFetch time.Time
+
+	// the instance types the history was fetched for, nil meaning all
+	instanceTypes map[string]bool
+
+	latest map[string]*ec2.SpotPrice
+}
+
+// spotPriceCache keeps the current spot prices between runs, so only the
+// price changes since the previous run need to be fetched.
+type spotPriceCache struct {
+	sync.Mutex
+	history map[string]*spotPriceHistory
+
+	// serialize the fetches of each history, without blocking the others
+	locks map[string]*sync.Mutex
+}
+
+func newSpotPriceCache() *spotPriceCache {
+	return &spotPriceCache{
+		history: make(map[string]*spotPriceHistory),
+		locks:   make(map[string]*sync.Mutex),
+	}
+}
+
+// lock locks the history of the given key, returning the function unlocking
+// it.
+func (c *spotPriceCache) lock(key string) func() {
+	c.Lock()
+	l, ok := c.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[key] = l
+	}
+	c.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func (c *spotPriceCache) get(key string) (*spotPriceHistory, bool) {
+	c.Lock()
+	defer c.Unlock()
+	h, ok := c.history[key]
+	return h, ok
+}
+
+func (c *spotPriceCache) set(key string, h *spotPriceHistory) {
+	c.Lock()
+	defer c.Unlock()
+	c.history[key] = h
+}
+
+// covers tells if the history contains all the given instance types.
+func (h *spotPriceHistory) covers(instanceTypes []*string) bool {
+	if h.instanceTypes == nil {
+		return true
+	}
+	if len(instanceTypes) == 0 {
+		return false
+	}
+	for _, t := range instanceTypes {
+		if !h.instanceTypes[*t] {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *spotPriceHistory) merge(prices []*ec2.SpotPrice) {
+	for _, p := range prices {
+		if p.InstanceType == nil || p.AvailabilityZone == nil {
+			continue
+		}
+		key := *p.InstanceType + "/" + *p.AvailabilityZone
+		if old, ok := h.latest[key]; ok && old.Timestamp != nil &&
+			p.Timestamp != nil && old.Timestamp.After(*p.Timestamp) {
+			continue
+		}
+		h.latest[key] = p
+	}
+}
+
+// prices returns the latest prices of the given instance types, or of all
+// instance types if none are given.
+func (h *spotPriceHistory) prices(instanceTypes []*string) []*ec2.SpotPrice {
+	var wanted map[string]bool
+	if len(instanceTypes) > 0 {
+		wanted = make(map[string]bool)
+		for _, t := range instanceTypes {
+			wanted[*t] = true
+		}
+	}
+
+	var keys []string
+	for key, p := range h.latest {
+		if wanted == nil || wanted[*p.InstanceType] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	prices := make([]*ec2.SpotPrice, 0, len(keys))
+	for _, key := range keys {
+		prices = append(prices, h.latest[key])
+	}
+	return prices
 }
 
 // fetch queries all spot prices in the current region
@@ -21,29 +141,102 @@ func (s *spotPrices) fetch(product string,
 	availabilityZone *string,
 	instanceTypes []*string) error {
 
-	logger.Println(s.conn.region, "Requesting spot prices")
+	if duration == 0 && availabilityZone == nil && s.cache != nil {
+		return s.fetchCurrent(product, instanceTypes)
+	}
+
+	now := orRealClock(s.clock).Now()
+	data, err := s.describe(product, now.Add(-1*duration), now,
+		availabilityZone, instanceTypes)
+	if err != nil {
+		return err
+	}
+
+	s.data = data
+
+	return nil
+}
+
+// fetchCurrent determines the current spot prices, only requesting the price
+// changes since the previous run when they were already cached.
+func (s *spotPrices) fetchCurrent(product string, instanceTypes []*string) error {
+
+	// the availability zone names are mapped differently in each account
+	key := s.conn.account + "/" + s.conn.region + "/" + product
+	now := orRealClock(s.clock).Now()
+
+	// only the fetches of the same history wait for each other
+	unlock := s.cache.lock(key)
+	defer unlock()
+
+	h, ok := s.cache.get(key)
+	if !ok || !h.covers(instanceTypes) {
+		h = &spotPriceHistory{latest: make(map[string]*ec2.SpotPrice)}
+		if len(instanceTypes) > 0 {
+			h.instanceTypes = make(map[string]bool)
+			for _, t := range instanceTypes {
+				h.instanceTypes[*t] = true
+			}
+		}
+	} else {
+		s.conn.logger().Println(s.conn.region, "Requesting the spot price changes since",
+			h.lastFetch)
+	}
+
+	start := h.lastFetch
+	if start.IsZero() {
+		start = now
+	}
+
+	data, err := s.describe(product, start, now, nil, instanceTypes)
+	if err != nil {
+		return err
+	}
+
+	h.merge(data)
+	h.lastFetch = now
+	s.cache.set(key, h)
+
+	s.data = h.prices(instanceTypes)
+
+	return nil
+}
+
+// describe pages through the spot price history matching the given filters.
+func (s *spotPrices) describe(product string, start, end time.Time,
+	availabilityZone *string, instanceTypes []*string) ([]*ec2.SpotPrice, error) {
+
+	s.conn.logger().Println(s.conn.region, "Requesting spot prices")
 
 	ec2Conn := s.conn.ec2
 	params := &ec2.DescribeSpotPriceHistoryInput{
 		ProductDescriptions: []*string{
 			aws.String(product),
 		},
-		StartTime:        aws.Time(time.Now().Add(-1 * duration)),
-		EndTime:          aws.Time(time.Now()),
+		StartTime:        aws.Time(start),
+		EndTime:          aws.Time(end),
 		AvailabilityZone: availabilityZone,
 		InstanceTypes:    instanceTypes,
 	}
 
-	resp, err := ec2Conn.DescribeSpotPriceHistory(params)
+	var data []*ec2.SpotPrice
+	pageNum := 0
+
+	err := ec2Conn.DescribeSpotPriceHistoryPages(params,
+		func(page *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
+			pageNum++
+			s.conn.debugLogger().Println(s.conn.region, "Processing page", pageNum,
+				"of DescribeSpotPriceHistoryPages")
+			data = append(data, page.SpotPriceHistory...)
+			return true
+		})
 
 	if err != nil {
-		logger.Println(s.conn.region, "Failed requesting spot prices:", err.Error())
-		return err
+		s.conn.logger().Println(s.conn.region, "Failed requesting spot prices:", err.Error())
+		return nil, err
 	}
 
-	s.data = resp.SpotPriceHistory
-
-	return nil
+	return data, nil
 }
 
 func (s *spotPrices) filterData(az string, instanceType string) []*ec2.SpotPrice {
@@ -67,7 +260,7 @@ func (s *spotPrices) average(az string, instanceType string) (float64, error) {
 
 	data := s.filterData(az, instanceType)
 
-	debug.Println(data)
+	s.conn.debugLogger().Println(data)
 
 	if len(data) == 0 {
 		return -1, errors.New("can't determine average, missing spot data")
@@ -92,7 +285,7 @@ func (s *spotPrices) average(az string, instanceType string) (float64, error) {
 
 		sum += int64(prevPrice) * timediff
 
-		debug.Println(prevTimestamp.String(), prevPrice, timediff, sum)
+		s.conn.debugLogger().Println(prevTimestamp.String(), prevPrice, timediff, sum)
 
 		prevPrice, _ = strconv.Atoi(*p.SpotPrice)
 		prevTimestamp = *p.Timestamp
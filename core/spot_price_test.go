@@ -193,3 +193,145 @@ func Test_fetch(t *testing.T) {
 		})
 	}
 }
+
+func Test_fetchPages(t *testing.T) {
+	s := &spotPrices{
+		conn: connections{
+			ec2: mockEC2{
+				dsphpo: []*ec2.DescribeSpotPriceHistoryOutput{
+					{SpotPriceHistory: []*ec2.SpotPrice{{SpotPrice: aws.String("1")}}},
+					{SpotPriceHistory: []*ec2.SpotPrice{{SpotPrice: aws.String("2")}}},
+				},
+			},
+		},
+	}
+
+	if err := s.fetch("Linux/UNIX", 0, nil, nil); err != nil {
+		t.Fatalf("fetch() error = %v", err)
+	}
+
+	if len(s.data) != 2 || *s.data[1].SpotPrice != "2" {
+		t.Errorf("fetch() didn't return the prices from all pages: %v", s.data)
+	}
+}
+
+func Test_fetchCurrent(t *testing.T) {
+	NOW := time.Now()
+
+	price := func(instanceType, az, value string, age time.Duration) *ec2.SpotPrice {
+		return &ec2.SpotPrice{
+			InstanceType:     aws.String(instanceType),
+			AvailabilityZone: aws.String(az),
+			SpotPrice:        aws.String(value),
+			Timestamp:        aws.Time(NOW.Add(-age)),
+		}
+	}
+
+	cache := newSpotPriceCache()
+	var inputs []*ec2.DescribeSpotPriceHistoryInput
+
+	run := func(instanceTypes []*string, prices ...*ec2.SpotPrice) []*ec2.SpotPrice {
+		s := &spotPrices{
+			conn: connections{
+				region: "us-east-1",
+				ec2: mockEC2{
+					dspho: &ec2.DescribeSpotPriceHistoryOutput{
+						SpotPriceHistory: prices,
+					},
+					dsphpi: &inputs,
+				},
+			},
+			cache: cache,
+		}
+		if err := s.fetch("Linux/UNIX", 0, nil, instanceTypes); err != nil {
+			t.Fatalf("fetch() error = %v", err)
+		}
+		return s.data
+	}
+
+	types := []*string{aws.String("m5.large"), aws.String("c5.large")}
+
+	// the first run fetches the current prices
+	data := run(types,
+		price("m5.large", "us-east-1a", "0.05", time.Hour),
+		price("c5.large", "us-east-1a", "0.04", time.Hour))
+
+	if len(data) != 2 {
+		t.Fatalf("first run returned %d prices, want 2", len(data))
+	}
+
+	// the second run only requests the changes since the first one
+	data = run(types[:1],
+		price("m5.large", "us-east-1a", "0.06", time.Minute),
+		price("m5.large", "us-east-1a", "0.03", 2*time.Hour))
+
+	if !inputs[1].StartTime.Equal(*inputs[0].EndTime) {
+		t.Errorf("second run StartTime = %v, want %v", inputs[1].StartTime, inputs[0].EndTime)
+	}
+
+	if len(data) != 1 || *data[0].SpotPrice != "0.06" {
+		t.Errorf("second run returned %v, want the latest m5.large price", data)
+	}
+
+	// unknown instance types need fetching the current prices again
+	data = run([]*string{aws.String("r5.large")},
+		price("r5.large", "us-east-1b", "0.07", time.Hour))
+
+	if !inputs[2].StartTime.Equal(*inputs[2].EndTime) {
+		t.Errorf("third run didn't fetch the current prices")
+	}
+
+	if len(data) != 1 || *data[0].InstanceType != "r5.large" {
+		t.Errorf("third run returned %v", data)
+	}
+}
+
+// blockingSpotPricesEC2 blocks the spot price requests until released.
+type blockingSpotPricesEC2 struct {
+	mockEC2
+	started, release chan struct{}
+}
+
+func (m blockingSpotPricesEC2) DescribeSpotPriceHistoryPages(in *ec2.DescribeSpotPriceHistoryInput,
+	fn func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool) error {
+	close(m.started)
+	<-m.release
+	return m.mockEC2.DescribeSpotPriceHistoryPages(in, fn)
+}
+
+func Test_fetchCurrentConcurrently(t *testing.T) {
+	cache := newSpotPriceCache()
+	output := &ec2.DescribeSpotPriceHistoryOutput{}
+
+	blocked := blockingSpotPricesEC2{
+		mockEC2: mockEC2{dspho: output},
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	done := make(chan error)
+	go func() {
+		s := &spotPrices{conn: connections{region: "us-east-1", ec2: blocked}, cache: cache}
+		done <- s.fetch("Linux/UNIX", 0, nil, nil)
+	}()
+	<-blocked.started
+
+	// the other regions don't wait for the request in progress
+	s := &spotPrices{conn: connections{region: "eu-west-1", ec2: mockEC2{dspho: output}},
+		cache: cache}
+	fetched := make(chan error)
+	go func() { fetched <- s.fetch("Linux/UNIX", 0, nil, nil) }()
+
+	select {
+	case err := <-fetched:
+		if err != nil {
+			t.Errorf("fetch() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("fetch() waited for the spot prices of another region")
+	}
+
+	close(blocked.release)
+	if err := <-done; err != nil {
+		t.Errorf("fetch() error = %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package autospotting
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GroupStatus is the state of an AutoScaling group as seen when it was last
+// processed.
+type GroupStatus struct {
+	Account         string    `json:"account,omitempty"`
+	Region          string    `json:"region"`
+	Name            string    `json:"name"`
+	DesiredCapacity int64     `json:"desired_capacity"`
+	OnDemand        int64     `json:"on_demand"`
+	Spot            int64     `json:"spot"`
+	Reserved        int64     `json:"reserved"`
+	MinOnDemand     int64     `json:"min_on_demand"`
+	Action          string    `json:"action"`
+	Error           string    `json:"error,omitempty"`
+	Processed       time.Time `json:"processed"`
+
+	// estimated hourly cost of the running instances, and how much is saved
+	// compared to running only on-demand instances
+	HourlyCost    float64 `json:"hourly_cost"`
+	HourlySavings float64 `json:"hourly_savings"`
+}
+
+// Status summarizes the runs done by the current process.
+type Status struct {
+	Running      bool          `json:"running"`
+	Runs         int64         `json:"runs"`
+	LastStarted  time.Time     `json:"last_started,omitempty"`
+	LastFinished time.Time     `json:"last_finished,omitempty"`
+	Groups       []GroupStatus `json:"groups"`
+}
+
+// statusRecorder collects the status of the runs and of the groups processed
+// by them.
+type statusRecorder struct {
+	sync.Mutex
+
+	running      bool
+	runs         int64
+	lastStarted  time.Time
+	lastFinished time.Time
+	groups       map[string]GroupStatus
+
+	// errors seen while processing groups, keyed by region
+	errors map[string]int64
+}
+
+func newStatusRecorder() *statusRecorder {
+	return &statusRecorder{
+		groups: make(map[string]GroupStatus),
+		errors: make(map[string]int64),
+	}
+}
+
+func (s *statusRecorder) runStarted() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	s.running = true
+	s.lastStarted = time.Now()
+}
+
+func (s *statusRecorder) runFinished() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	s.running = false
+	s.runs++
+	s.lastFinished = time.Now()
+}
+
+func (s *statusRecorder) recordGroup(g GroupStatus) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	s.groups[g.Account+"/"+g.Region+"/"+g.Name] = g
+	if g.Error != "" {
+		s.errors[g.Region]++
+	}
+}
+
+// status returns a snapshot of the recorded status, with the groups sorted
+// by account, region and name.
+func (s *statusRecorder) status() Status {
+	s.Lock()
+	defer s.Unlock()
+
+	st := Status{
+		Running:      s.running,
+		Runs:         s.runs,
+		LastStarted:  s.lastStarted,
+		LastFinished: s.lastFinished,
+		Groups:       []GroupStatus{},
+	}
+
+	var keys []string
+	for k := range s.groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		st.Groups = append(st.Groups, s.groups[k])
+	}
+	return st
+}
+
+// writeMetrics writes the recorded status in the Prometheus text format.
+func (s *statusRecorder) writeMetrics(w io.Writer) {
+	st := s.status()
+
+	s.Lock()
+	errors := make(map[string]int64, len(s.errors))
+	for k, v := range s.errors {
+		errors[k] = v
+	}
+	s.Unlock()
+
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	metric("autospotting_runs_total", "counter", "Number of completed runs.")
+	fmt.Fprintf(w, "autospotting_runs_total %d\n", st.Runs)
+
+	metric("autospotting_running", "gauge", "Whether a run is in progress.")
+	fmt.Fprintf(w, "autospotting_running %d\n", boolToInt(st.Running))
+
+	metric("autospotting_last_run_timestamp_seconds", "gauge",
+		"Time when the last run finished.")
+	fmt.Fprintf(w, "autospotting_last_run_timestamp_seconds %d\n", unixTime(st.LastFinished))
+
+	if !st.LastFinished.IsZero() && !st.LastFinished.Before(st.LastStarted) {
+		metric("autospotting_last_run_duration_seconds", "gauge",
+			"Duration of the last run.")
+		fmt.Fprintf(w, "autospotting_last_run_duration_seconds %.3f\n",
+			st.LastFinished.Sub(st.LastStarted).Seconds())
+	}
+
+	metric("autospotting_group_instances", "gauge",
+		"Running instances of the processed AutoScaling groups.")
+	for _, g := range st.Groups {
+		fmt.Fprintf(w, "autospotting_group_instances{%s,lifecycle=\"on-demand\"} %d\n",
+			g.labels(), g.OnDemand)
+		fmt.Fprintf(w, "autospotting_group_instances{%s,lifecycle=\"spot\"} %d\n",
+			g.labels(), g.Spot)
+	}
+
+	metric("autospotting_group_reserved_instances", "gauge",
+		"Running on-demand instances of the processed AutoScaling groups kept for being "+
+			"covered by reserved instances.")
+	for _, g := range st.Groups {
+		fmt.Fprintf(w, "autospotting_group_reserved_instances{%s} %d\n",
+			g.labels(), g.Reserved)
+	}
+
+	metric("autospotting_group_desired_capacity", "gauge",
+		"Desired capacity of the processed AutoScaling groups.")
+	for _, g := range st.Groups {
+		fmt.Fprintf(w, "autospotting_group_desired_capacity{%s} %d\n",
+			g.labels(), g.DesiredCapacity)
+	}
+
+	metric("autospotting_group_error", "gauge",
+		"Whether the last processing of the AutoScaling group failed.")
+	for _, g := range st.Groups {
+		fmt.Fprintf(w, "autospotting_group_error{%s} %d\n",
+			g.labels(), boolToInt(g.Error != ""))
+	}
+
+	var regions []string
+	for r := range errors {
+		regions = append(regions, r)
+	}
+	sort.Strings(regions)
+
+	metric("autospotting_errors_total", "counter",
+		"Number of failures while processing AutoScaling groups.")
+	for _, r := range regions {
+		fmt.Fprintf(w, "autospotting_errors_total{region=\"%s\"} %d\n",
+			labelEscaper.Replace(r), errors[r])
+	}
+}
+
+// labelEscaper escapes the Prometheus label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (g GroupStatus) labels() string {
+	return fmt.Sprintf(`account="%s",region="%s",asg="%s"`,
+		labelEscaper.Replace(g.Account), labelEscaper.Replace(g.Region),
+		labelEscaper.Replace(g.Name))
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// reportStatus records the outcome of processing the group, in the status
+// served by the API and in the result of the run, and returns it.
+func (a *autoScalingGroup) reportStatus(action string, err error) GroupStatus {
+	if a.region == nil || a.region.conf == nil {
+		return GroupStatus{Name: a.name, Action: action}
+	}
+
+	g := GroupStatus{
+		Account:     a.region.services.account,
+		Region:      a.region.name,
+		Name:        a.name,
+		MinOnDemand: a.minOnDemand,
+		Action:      action,
+		Processed:   a.region.conf.clock().Now(),
+	}
+
+	if a.Group != nil && a.DesiredCapacity != nil {
+		g.DesiredCapacity = *a.DesiredCapacity
+	}
+
+	if err != nil {
+		g.Error = err.Error()
+	}
+
+	if a.instances != nil {
+		for inst := range a.instances.instances() {
+			if inst.State == nil || inst.State.Name == nil || *inst.State.Name != "running" {
+				continue
+			}
+			if inst.isSpot() {
+				g.Spot++
+			} else {
+				g.OnDemand++
+				if inst.isReserved() {
+					g.Reserved++
+				}
+			}
+			g.HourlyCost += inst.price
+			g.HourlySavings += inst.typeInfo.pricing.onDemand - inst.price
+		}
+	}
+
+	a.region.conf.status.recordGroup(g)
+	a.region.report.addGroup(g)
+	return g
+}
@@ -0,0 +1,204 @@
+package autospotting
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// statusShutdownTimeout limits the time given to the pending API requests
+// when the daemon stops.
+const statusShutdownTimeout = 10 * time.Second
+
+// runLockTimeout limits the time the groups processed through the API wait
+// for the run in progress to finish.
+const runLockTimeout = 5 * time.Second
+
+var (
+	errUnknownAccount     = errors.New("the account is not processed")
+	errRegionNotEnabled   = errors.New("the region is not enabled")
+	errGroupNotEnabled    = errors.New("the group was not found or is not enabled")
+	errMissingRunArgument = errors.New("both the region and asg parameters are required")
+	errRunInProgress      = errors.New("another run is in progress, retry later")
+)
+
+// statusHandler serves the status and control API.
+type statusHandler struct {
+	ctx context.Context
+	cfg *Config
+
+	// processes a single group, replaced by the tests
+	runGroup func(ctx context.Context, cfg *Config, accountID, region, asg string) error
+}
+
+func newStatusHandler(ctx context.Context, cfg *Config) http.Handler {
+	h := &statusHandler{ctx: ctx, cfg: cfg, runGroup: runGroup}
+	return h.routes()
+}
+
+func (h *statusHandler) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/status", h.status)
+	mux.HandleFunc("/metrics", h.metrics)
+	mux.HandleFunc("/run", h.run)
+	return mux
+}
+
+// serveStatus serves the status and control API until the context is done.
+func serveStatus(ctx context.Context, cfg *Config, listener net.Listener) {
+	server := &http.Server{Handler: newStatusHandler(ctx, cfg)}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Println("Serving the status API on", listener.Addr())
+	if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+		logger.Println("The status API stopped:", err.Error())
+	}
+}
+
+func (h *statusHandler) healthz(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+func (h *statusHandler) status(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.cfg.status.status())
+}
+
+func (h *statusHandler) metrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.cfg.status.writeMetrics(w)
+}
+
+// run processes a single group, given by the region and asg parameters and
+// the account parameter when processing multiple accounts.
+func (h *statusHandler) run(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.cfg.StatusToken == "" {
+		http.Error(w, "processing groups through the API requires a status token",
+			http.StatusForbidden)
+		return
+	}
+
+	token := []byte("Bearer " + h.cfg.StatusToken)
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), token) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+		return
+	}
+
+	q := req.URL.Query()
+	accountID, regionName, asg := q.Get("account"), q.Get("region"), q.Get("asg")
+
+	if regionName == "" || asg == "" {
+		http.Error(w, errMissingRunArgument.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Println("Processing", asg, "in", regionName, "as requested through the API")
+
+	// stopped when the client goes away or when the daemon stops
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-h.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	switch err := h.runGroup(ctx, h.cfg, accountID, regionName, asg); err {
+	case nil:
+	case errUnknownAccount, errRegionNotEnabled, errGroupNotEnabled:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errRunInProgress:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	for _, g := range h.cfg.status.status().Groups {
+		if g.Account == accountID && g.Region == regionName && g.Name == asg {
+			json.NewEncoder(w).Encode(g)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
+// runGroup processes a single group, waiting for a short while for the run in
+// progress to finish first.
+func runGroup(ctx context.Context, cfg *Config, accountID, regionName, asg string) error {
+
+	if !cfg.runLock.acquireWithin(ctx, runLockTimeout) {
+		return errRunInProgress
+	}
+	defer cfg.runLock.release()
+
+	prepareRun(cfg)
+
+	a, err := findAccount(cfg, accountID)
+	if err != nil {
+		return err
+	}
+
+	r, err := newRegion(ctx, cfg, a, regionName, cfg.priceProvider)
+	if err != nil {
+		return err
+	}
+
+	if !r.enabled() {
+		return errRegionNotEnabled
+	}
+
+	r.groupNames = []*string{aws.String(asg)}
+	r.processRegion()
+
+	if !r.hasEnabledAutoScalingGroups() {
+		return errGroupNotEnabled
+	}
+	return nil
+}
+
+// findAccount returns the processed account with the given ID, or the current
+// account if no other accounts are processed.
+func findAccount(cfg *Config, id string) (account, error) {
+	all, err := accounts(cfg)
+	if err != nil {
+		return account{}, err
+	}
+
+	if len(all) == 0 && id == "" {
+		return account{}, nil
+	}
+
+	for _, a := range all {
+		if a.id == id {
+			return a, nil
+		}
+	}
+	return account{}, errUnknownAccount
+}
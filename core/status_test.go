@@ -0,0 +1,233 @@
+package autospotting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestReportStatus(t *testing.T) {
+	r := &region{
+		name:     "us-east-1",
+		conf:     &Config{status: newStatusRecorder()},
+		report:   newRunReport(time.Now()),
+		services: connections{account: "123456789012"},
+
+		reservedInstanceIDs: map[string]bool{"i-1": true},
+	}
+
+	running := &ec2.InstanceState{Name: aws.String("running")}
+	a := &autoScalingGroup{
+		Group:       &autoscaling.Group{DesiredCapacity: aws.Int64(3)},
+		name:        "asg",
+		region:      r,
+		minOnDemand: 1,
+		instances: makeInstancesWithCatalog(map[string]*instance{
+			"i-1": {Instance: &ec2.Instance{InstanceId: aws.String("i-1"), State: running},
+				region: r, price: 0.1,
+				typeInfo: instanceTypeInformation{pricing: prices{onDemand: 0.1}}},
+			"i-2": {Instance: &ec2.Instance{State: running,
+				InstanceLifecycle: aws.String("spot")}, price: 0.03,
+				typeInfo: instanceTypeInformation{pricing: prices{onDemand: 0.1}}},
+			"i-3": {Instance: &ec2.Instance{State: running,
+				InstanceLifecycle: aws.String("spot")}, price: 0.03,
+				typeInfo: instanceTypeInformation{pricing: prices{onDemand: 0.1}}},
+			"i-4": {Instance: &ec2.Instance{
+				State: &ec2.InstanceState{Name: aws.String("pending")}}},
+		}),
+	}
+
+	a.reportStatus("launching spot instance", errors.New("no capacity"))
+
+	got := r.conf.status.status().Groups
+	if len(got) != 1 {
+		t.Fatalf("status() groups = %v, want one", got)
+	}
+
+	g := got[0]
+	if g.Account != "123456789012" || g.Region != "us-east-1" || g.Name != "asg" ||
+		g.DesiredCapacity != 3 || g.OnDemand != 1 || g.Spot != 2 || g.Reserved != 1 ||
+		g.MinOnDemand != 1 || g.Action != "launching spot instance" ||
+		g.Error != "no capacity" ||
+		math.Abs(g.HourlyCost-0.16) > 1e-9 || math.Abs(g.HourlySavings-0.14) > 1e-9 {
+		t.Errorf("status() group = %+v", g)
+	}
+
+	result := r.report.result(time.Now())
+	if len(result.Regions) != 1 || !reflect.DeepEqual(result.Regions[0].Groups, got) {
+		t.Errorf("result() = %+v, want the group %+v", result, g)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	s := newStatusRecorder()
+	s.runStarted()
+	s.recordGroup(GroupStatus{Region: "eu-west-1", Name: `my "asg"`,
+		DesiredCapacity: 2, OnDemand: 1, Spot: 1, Reserved: 1, Error: "failed"})
+	s.runFinished()
+
+	var out bytes.Buffer
+	s.writeMetrics(&out)
+
+	for _, want := range []string{
+		"autospotting_runs_total 1\n",
+		"autospotting_running 0\n",
+		`autospotting_group_instances{account="",region="eu-west-1",asg="my \"asg\"",lifecycle="spot"} 1` + "\n",
+		`autospotting_group_reserved_instances{account="",region="eu-west-1",asg="my \"asg\""} 1` + "\n",
+		`autospotting_group_desired_capacity{account="",region="eu-west-1",asg="my \"asg\""} 2` + "\n",
+		`autospotting_group_error{account="",region="eu-west-1",asg="my \"asg\""} 1` + "\n",
+		`autospotting_errors_total{region="eu-west-1"} 1` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("writeMetrics() is missing %q in:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	cfg := &Config{status: newStatusRecorder(), StatusToken: "secret"}
+	cfg.status.recordGroup(GroupStatus{Region: "eu-west-1", Name: "asg", Spot: 1})
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		auth       *string
+		noToken    bool
+		runErr     error
+		wantCode   int
+		wantInBody string
+	}{
+		{name: "health check",
+			method:     "GET",
+			url:        "/healthz",
+			wantCode:   http.StatusOK,
+			wantInBody: "ok",
+		},
+		{name: "status",
+			method:     "GET",
+			url:        "/status",
+			wantCode:   http.StatusOK,
+			wantInBody: `"name":"asg"`,
+		},
+		{name: "metrics",
+			method:     "GET",
+			url:        "/metrics",
+			wantCode:   http.StatusOK,
+			wantInBody: "autospotting_group_instances",
+		},
+		{name: "run a group",
+			method:     "POST",
+			url:        "/run?region=eu-west-1&asg=asg",
+			wantCode:   http.StatusOK,
+			wantInBody: `"spot":1`,
+		},
+		{name: "run without POST",
+			method:   "GET",
+			url:      "/run?region=eu-west-1&asg=asg",
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{name: "run without group",
+			method:   "POST",
+			url:      "/run?region=eu-west-1",
+			wantCode: http.StatusBadRequest,
+		},
+		{name: "run without the token configured",
+			method:   "POST",
+			url:      "/run?region=eu-west-1&asg=asg",
+			noToken:  true,
+			wantCode: http.StatusForbidden,
+		},
+		{name: "run with a wrong token",
+			method:   "POST",
+			url:      "/run?region=eu-west-1&asg=asg",
+			auth:     aws.String("Bearer guess"),
+			wantCode: http.StatusUnauthorized,
+		},
+		{name: "run without a token",
+			method:   "POST",
+			url:      "/run?region=eu-west-1&asg=asg",
+			auth:     aws.String(""),
+			wantCode: http.StatusUnauthorized,
+		},
+		{name: "run while another run is in progress",
+			method:   "POST",
+			url:      "/run?region=eu-west-1&asg=asg",
+			runErr:   errRunInProgress,
+			wantCode: http.StatusConflict,
+		},
+		{name: "run a group which isn't enabled",
+			method:   "POST",
+			url:      "/run?region=eu-west-1&asg=other",
+			runErr:   errGroupNotEnabled,
+			wantCode: http.StatusNotFound,
+		},
+		{name: "run failure",
+			method:   "POST",
+			url:      "/run?region=eu-west-1&asg=asg",
+			runErr:   errors.New("no credentials"),
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cfg
+			if tt.noToken {
+				c = &Config{status: cfg.status}
+			}
+			h := &statusHandler{
+				ctx: context.Background(),
+				cfg: c,
+				runGroup: func(ctx context.Context, cfg *Config, accountID,
+					region, asg string) error {
+					return tt.runErr
+				},
+			}
+
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			if tt.auth != nil {
+				req.Header.Set("Authorization", *tt.auth)
+			}
+
+			rec := httptest.NewRecorder()
+			h.routes().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("%s %s code = %d, want %d", tt.method, tt.url, rec.Code, tt.wantCode)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantInBody) {
+				t.Errorf("%s %s body = %s, want it to contain %s", tt.method, tt.url,
+					rec.Body.String(), tt.wantInBody)
+			}
+		})
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	s := newStatusRecorder()
+	s.recordGroup(GroupStatus{Region: "us-east-1", Name: "b"})
+	s.recordGroup(GroupStatus{Region: "eu-west-1", Name: "a"})
+
+	var got Status
+	raw, _ := json.Marshal(s.status())
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+
+	if len(got.Groups) != 2 || got.Groups[0].Region != "eu-west-1" {
+		t.Errorf("status() groups = %+v, want them sorted by region", got.Groups)
+	}
+}
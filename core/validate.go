@@ -0,0 +1,411 @@
+package autospotting
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Problem is an invalid or conflicting setting, given either as a flag or as
+// a tag of a group.
+type Problem struct {
+	Account string `json:"account,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Group   string `json:"asg,omitempty"`
+
+	// the flag or tag name, and its value
+	Setting string `json:"setting"`
+	Value   string `json:"value"`
+
+	Problem string `json:"problem"`
+
+	// the value actually used instead
+	Used string `json:"used"`
+}
+
+// Problems are all the problems found in the configuration.
+type Problems []Problem
+
+// the tags which can be set on the groups, other autospotting_* tags being
+// reported as unknown
+var groupTags = map[string]bool{
+	OnDemandPercentageTag:          true,
+	OnDemandNumberLong:             true,
+	BiddingPolicyTag:               true,
+	SpotPriceBufferPercentageTag:   true,
+	AllowedInstanceTypesTag:        true,
+	DisallowedInstanceTypesTag:     true,
+	SpotProductDescriptionTag:      true,
+	AllowBurstableInstanceTypesTag: true,
+	RevertToOnDemandTag:            true,
+	SelectionStrategyTag:           true,
+	PreferredInstanceTypesTag:      true,
+	MaxInterruptionBandTag:         true,
+}
+
+// Validate checks the configuration and the tags of the groups matching the
+// tag filters in all the enabled regions, without making any changes.
+func Validate(cfg *Config) (Problems, error) {
+
+	setupLogging(cfg)
+
+	// checked before loading the groups' configuration, which may replace
+	// some invalid values with their defaults
+	problems := validateConfig(cfg)
+
+	prepareRun(cfg)
+
+	var lock sync.Mutex
+
+	err := forEachRegion(context.Background(), cfg, nil, func(r *region) {
+		found := r.validate()
+
+		lock.Lock()
+		problems = append(problems, found...)
+		lock.Unlock()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Group < b.Group
+	})
+
+	return problems, nil
+}
+
+// validateConfig checks the global configuration given by the flags.
+func validateConfig(cfg *Config) Problems {
+	var problems Problems
+
+	add := func(setting, value, problem, used string) {
+		problems = append(problems, Problem{Setting: setting, Value: value,
+			Problem: problem, Used: used})
+	}
+
+	if cfg.BiddingPolicy != DefaultBiddingPolicy && cfg.BiddingPolicy != "aggressive" {
+		add("bidding_policy", cfg.BiddingPolicy,
+			"unknown bidding policy, only normal and aggressive are supported",
+			"aggressive")
+	}
+
+	number, percentage := cfg.MinOnDemandNumber, cfg.MinOnDemandPercentage
+
+	if number < 0 {
+		add("min_on_demand_number", fmt.Sprint(number), "negative number",
+			strconv.Itoa(DefaultMinOnDemandValue))
+	}
+
+	if percentage < 0 || percentage > 100 {
+		add("min_on_demand_percentage", fmt.Sprint(percentage),
+			"percentage out of the 0-100 range", strconv.Itoa(DefaultMinOnDemandValue))
+	} else if percentage != 0 && number > 0 {
+		add("min_on_demand_percentage", fmt.Sprint(percentage),
+			"ignored since min_on_demand_number is also set, unless the number "+
+				"exceeds the instances of a group", fmt.Sprint(number))
+	}
+
+	if cfg.SpotPriceBufferPercentage <= 0 {
+		add("spot_price_buffer_percentage", fmt.Sprint(cfg.SpotPriceBufferPercentage),
+			"percentage must be positive", fmt.Sprint(DefaultSpotPriceBufferPercentage))
+	}
+
+	if !isValidSpotProductDescription(cfg.SpotProductDescription) {
+		add("spot_product_description", cfg.SpotProductDescription,
+			"unknown spot product description",
+			"the one detected from the AMI, if any")
+	}
+
+	switch {
+	case cfg.SelectionStrategy != "" && !isValidSelectionStrategy(cfg.SelectionStrategy):
+		add("selection_strategy", cfg.SelectionStrategy,
+			errUnknownSelectionStrategy.Error(), DefaultSelectionStrategy)
+	case cfg.SelectionStrategy == LowestInterruptionRiskStrategy &&
+		cfg.InterruptionDataSource == "" && cfg.InterruptionData == nil:
+		add("selection_strategy", cfg.SelectionStrategy,
+			errNoInterruptionData.Error(), DefaultSelectionStrategy)
+	}
+
+	if cfg.MaxInterruptionBand != "" {
+		if _, err := parseInterruptionBand(cfg.MaxInterruptionBand); err != nil {
+			add("max_interruption_band", cfg.MaxInterruptionBand,
+				"unknown interruption band", "no limit")
+		}
+	}
+
+	if cfg.InterruptionPenaltyPercentage < 0 {
+		add("interruption_penalty_percentage", fmt.Sprint(cfg.InterruptionPenaltyPercentage),
+			"negative percentage", "favors the frequently interrupted instance types")
+	}
+
+	for _, pattern := range splitList(cfg.Regions) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			add("regions", pattern, "invalid glob pattern", "no region matches it")
+		}
+	}
+
+	problems = append(problems,
+		validatePatterns("allowed_instance_types", cfg.AllowedInstanceTypes)...)
+	problems = append(problems,
+		validatePatterns("disallowed_instance_types", cfg.DisallowedInstanceTypes)...)
+	problems = append(problems,
+		validateInstanceTypeLists("allowed_instance_types", cfg.AllowedInstanceTypes,
+			"disallowed_instance_types", cfg.DisallowedInstanceTypes)...)
+
+	filters := replaceWhitespace(cfg.FilterByTags)
+	if filters != "" {
+		var valid int
+		for _, filter := range strings.Split(filters, ",") {
+			if splitTagAndValue(filter) != nil {
+				valid++
+				continue
+			}
+			add("tag_filters", filter, "not in the tag=value format", "ignored")
+		}
+		if valid == 0 {
+			add("tag_filters", cfg.FilterByTags, "no valid tag filters",
+				"spot-enabled=true")
+		}
+	}
+
+	if _, err := ParseSchedule(cfg.Schedule); err != nil {
+		add("schedule", cfg.Schedule, err.Error(), "the daemon mode fails to start")
+	}
+
+	return problems
+}
+
+// validatePatterns checks the glob patterns of an instance type list.
+func validatePatterns(setting, list string) Problems {
+	var problems Problems
+
+	for _, pattern := range splitList(list) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			problems = append(problems, Problem{Setting: setting, Value: pattern,
+				Problem: "invalid glob pattern", Used: "matches no instance type"})
+		}
+	}
+	return problems
+}
+
+// validateInstanceTypeLists reports the disallowed instance types ignored
+// because instance type patterns are also allowed. The allowed lists made only
+// of policies and categories are applied together with the disallowed list.
+func validateInstanceTypeLists(allowedName, allowed, disallowedName, disallowed string) Problems {
+	if !hasInstanceTypeGlobs(splitList(allowed)) || len(splitList(disallowed)) == 0 {
+		return nil
+	}
+
+	return Problems{{Setting: disallowedName, Value: disallowed,
+		Problem: "ignored since " + allowedName + " is also set", Used: allowed}}
+}
+
+// splitList splits a comma or whitespace separated list.
+func splitList(list string) []string {
+	return strings.FieldsFunc(list, func(c rune) bool {
+		return c == ',' || c == ' '
+	})
+}
+
+// validate checks the tags of the groups matching the tag filters.
+func (r *region) validate() Problems {
+	if !r.scan() {
+		return nil
+	}
+
+	var problems Problems
+	for n := range r.enabledASGs {
+		a := &r.enabledASGs[n]
+		a.scanInstances()
+
+		for _, p := range a.validate() {
+			p.Account, p.Region, p.Group = r.services.account, r.name, a.name
+			problems = append(problems, p)
+		}
+	}
+	return problems
+}
+
+// validate checks the autospotting_* tags of the group, reporting the values
+// they are replaced with.
+func (a *autoScalingGroup) validate() Problems {
+	var problems Problems
+
+	add := func(tag, value, problem, used string) {
+		problems = append(problems, Problem{Setting: tag, Value: value,
+			Problem: problem, Used: used})
+	}
+
+	conf := a.region.conf
+
+	for _, tag := range a.Tags {
+		key := aws.StringValue(tag.Key)
+		if strings.HasPrefix(key, "autospotting_") && !groupTags[key] {
+			add(key, aws.StringValue(tag.Value), "unknown tag", "ignored")
+		}
+	}
+
+	// the number of on-demand instances, the number taking precedence over
+	// the percentage when both are valid
+	a.loadDefaultConfig()
+	a.loadConfOnDemand()
+	minOnDemand := fmt.Sprint(a.minOnDemand)
+
+	number := a.getTagValue(OnDemandNumberLong)
+	numberUsed := false
+	if number != nil {
+		if n, err := strconv.Atoi(*number); err != nil {
+			add(OnDemandNumberLong, *number, "not a number", minOnDemand)
+		} else if n < 0 || int64(n) > aws.Int64Value(a.MaxSize) {
+			add(OnDemandNumberLong, *number, fmt.Sprintf(
+				"out of the 0-%d range given by the group's MaxSize",
+				aws.Int64Value(a.MaxSize)), minOnDemand)
+		} else {
+			numberUsed = true
+		}
+	}
+
+	if percentage := a.getTagValue(OnDemandPercentageTag); percentage != nil {
+		if p, err := strconv.ParseFloat(*percentage, 64); err != nil {
+			add(OnDemandPercentageTag, *percentage, "not a number", minOnDemand)
+		} else if p < 0 || p > 100 {
+			add(OnDemandPercentageTag, *percentage,
+				"percentage out of the 0-100 range", minOnDemand)
+		} else if numberUsed {
+			add(OnDemandPercentageTag, *percentage,
+				"ignored since "+OnDemandNumberLong+" is also set", minOnDemand)
+		}
+	}
+
+	if policy := a.getTagValue(BiddingPolicyTag); policy != nil {
+		switch *policy {
+		case "aggressive":
+		case DefaultBiddingPolicy:
+			if conf.BiddingPolicy != DefaultBiddingPolicy {
+				add(BiddingPolicyTag, *policy, "only the aggressive policy can be "+
+					"set using the tag, overriding the global policy", conf.BiddingPolicy)
+			}
+		default:
+			add(BiddingPolicyTag, *policy,
+				"unknown bidding policy, only aggressive is supported", conf.BiddingPolicy)
+		}
+	}
+
+	if buffer := a.getTagValue(SpotPriceBufferPercentageTag); buffer != nil {
+		if p, err := strconv.ParseFloat(*buffer, 64); err != nil || p <= 0 {
+			add(SpotPriceBufferPercentageTag, *buffer, "not a positive number",
+				fmt.Sprint(conf.SpotPriceBufferPercentage))
+		}
+	}
+
+	if product := a.getTagValue(SpotProductDescriptionTag); product != nil &&
+		!isValidSpotProductDescription(*product) {
+		add(SpotProductDescriptionTag, *product, "unknown spot product description",
+			a.loadSpotProductDescription())
+	}
+
+	if value := a.getTagValue(AllowBurstableInstanceTypesTag); value != nil {
+		if _, err := strconv.ParseBool(*value); err != nil {
+			add(AllowBurstableInstanceTypesTag, *value, "not a boolean",
+				strconv.FormatBool(conf.AllowBurstableInstanceTypes))
+		}
+	}
+
+	if value := a.getTagValue(RevertToOnDemandTag); value != nil {
+		if _, err := strconv.ParseBool(*value); err != nil {
+			add(RevertToOnDemandTag, *value, "not a boolean", "false")
+		}
+	}
+
+	strategy, strategyName := a.getSelectionStrategyName(), "selection_strategy"
+	if value := a.getTagValue(SelectionStrategyTag); value != nil {
+		if err := a.region.conf.checkSelectionStrategy(*value); err != nil {
+			add(SelectionStrategyTag, *value, err.Error(), strategy)
+		} else {
+			strategyName = SelectionStrategyTag
+		}
+	}
+
+	preferred := a.getTagValue(PreferredInstanceTypesTag)
+	switch {
+	case preferred != nil && strategy != PriorityStrategy:
+		add(PreferredInstanceTypesTag, *preferred, "ignored since the selection "+
+			"strategy is "+strategy, strategy)
+	case preferred != nil:
+		problems = append(problems, validatePatterns(PreferredInstanceTypesTag, *preferred)...)
+	case strategy == PriorityStrategy:
+		add(strategyName, strategy, "no preferred instance types given using "+
+			"the tag "+PreferredInstanceTypesTag, "ordered by price")
+	}
+
+	if value := a.getTagValue(MaxInterruptionBandTag); value != nil {
+		if _, err := parseInterruptionBand(*value); err != nil {
+			used := "no limit"
+			if conf.MaxInterruptionBand != "" {
+				used = conf.MaxInterruptionBand
+			}
+			add(MaxInterruptionBandTag, *value, "unknown interruption band", used)
+		}
+	}
+
+	// the tags replace the global lists, unless empty
+	allowed, allowedName := conf.AllowedInstanceTypes, "allowed_instance_types"
+	if tag := a.getTagValue(AllowedInstanceTypesTag); tag != nil && *tag != "" {
+		allowed, allowedName = *tag, AllowedInstanceTypesTag
+		problems = append(problems, validatePatterns(allowedName, allowed)...)
+	}
+
+	disallowed, disallowedName := conf.DisallowedInstanceTypes, "disallowed_instance_types"
+	if tag := a.getTagValue(DisallowedInstanceTypesTag); tag != nil && *tag != "" {
+		disallowed, disallowedName = *tag, DisallowedInstanceTypesTag
+		problems = append(problems, validatePatterns(disallowedName, disallowed)...)
+	}
+
+	// the conflicts between the global lists are already reported
+	if allowedName == AllowedInstanceTypesTag || disallowedName == DisallowedInstanceTypesTag {
+		problems = append(problems, validateInstanceTypeLists(allowedName, allowed,
+			disallowedName, disallowed)...)
+	}
+
+	return problems
+}
+
+// WriteTable writes the problems as an aligned text table.
+func (p Problems) WriteTable(w io.Writer) error {
+	if len(p) == 0 {
+		_, err := fmt.Fprintln(w, "No problems found")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "ACCOUNT\tREGION\tASG\tSETTING\tVALUE\tPROBLEM\tUSED")
+	for _, problem := range p {
+		fields := []string{problem.Account, problem.Region, problem.Group,
+			problem.Setting, problem.Value, problem.Problem, problem.Used}
+		for n, f := range fields {
+			if f == "" {
+				fields[n] = "-"
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(fields, "\t"))
+	}
+
+	return tw.Flush()
+}
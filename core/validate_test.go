@@ -0,0 +1,302 @@
+package autospotting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestValidateConfig(t *testing.T) {
+	valid := Config{
+		BiddingPolicy:             "normal",
+		SpotPriceBufferPercentage: 10,
+		SpotProductDescription:    DefaultSpotProductDescription,
+		Schedule:                  DefaultSchedule,
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		setting []string
+		used    []string
+	}{
+		{name: "valid configuration",
+			modify: func(c *Config) {
+				c.MinOnDemandNumber = 2
+				c.Regions = "eu-*,us-east-1"
+				c.FilterByTags = "spot-enabled=true,team=a"
+				c.AllowedInstanceTypes = "m5.*"
+			},
+		},
+		{name: "unknown selection strategy",
+			modify:  func(c *Config) { c.SelectionStrategy = "cheapest-per-cpu" },
+			setting: []string{"selection_strategy"},
+			used:    []string{DefaultSelectionStrategy},
+		},
+		{name: "selection strategy requiring the interruption data",
+			modify:  func(c *Config) { c.SelectionStrategy = LowestInterruptionRiskStrategy },
+			setting: []string{"selection_strategy"},
+			used:    []string{DefaultSelectionStrategy},
+		},
+		{name: "selection strategy using the interruption data",
+			modify: func(c *Config) {
+				c.SelectionStrategy = LowestInterruptionRiskStrategy
+				c.InterruptionDataSource = "spot-advisor-data.json"
+			},
+		},
+		{name: "unknown interruption band and negative penalty",
+			modify: func(c *Config) {
+				c.MaxInterruptionBand = "<10%"
+				c.InterruptionPenaltyPercentage = -5
+			},
+			setting: []string{"max_interruption_band", "interruption_penalty_percentage"},
+			used:    []string{"no limit", "favors the frequently interrupted instance types"},
+		},
+		{name: "bidding policy typo",
+			modify:  func(c *Config) { c.BiddingPolicy = "agressive" },
+			setting: []string{"bidding_policy"},
+			used:    []string{"aggressive"},
+		},
+		{name: "number and percentage",
+			modify: func(c *Config) {
+				c.MinOnDemandNumber = 1
+				c.MinOnDemandPercentage = 50
+			},
+			setting: []string{"min_on_demand_percentage"},
+			used:    []string{"1"},
+		},
+		{name: "values out of range",
+			modify: func(c *Config) {
+				c.MinOnDemandNumber = -1
+				c.MinOnDemandPercentage = 150
+				c.SpotPriceBufferPercentage = 0
+			},
+			setting: []string{"min_on_demand_number", "min_on_demand_percentage",
+				"spot_price_buffer_percentage"},
+			used: []string{"0", "0", "10"},
+		},
+		{name: "invalid patterns",
+			modify: func(c *Config) {
+				c.Regions = "eu-[west-1"
+				c.DisallowedInstanceTypes = "t2.*,[c4"
+			},
+			setting: []string{"regions", "disallowed_instance_types"},
+			used:    []string{"no region matches it", "matches no instance type"},
+		},
+		{name: "allowed and disallowed instance types",
+			modify: func(c *Config) {
+				c.AllowedInstanceTypes = "m5.*"
+				c.DisallowedInstanceTypes = "m5.large"
+			},
+			setting: []string{"disallowed_instance_types"},
+			used:    []string{"m5.*"},
+		},
+		{name: "allowed policies and categories with disallowed instance types",
+			modify: func(c *Config) {
+				c.AllowedInstanceTypes = "same-family,compute"
+				c.DisallowedInstanceTypes = "c4.large"
+			},
+		},
+		{name: "invalid tag filters",
+			modify:  func(c *Config) { c.FilterByTags = "spot-enabled" },
+			setting: []string{"tag_filters", "tag_filters"},
+			used:    []string{"ignored", "spot-enabled=true"},
+		},
+		{name: "invalid product and schedule",
+			modify: func(c *Config) {
+				c.SpotProductDescription = "BeOS"
+				c.Schedule = "every day"
+			},
+			setting: []string{"spot_product_description", "schedule"},
+			used:    []string{"the one detected from the AMI, if any", "the daemon mode fails to start"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+
+			problems := validateConfig(&cfg)
+
+			if len(problems) != len(tt.setting) {
+				t.Fatalf("validateConfig() = %+v, want problems with %v", problems, tt.setting)
+			}
+			for n, p := range problems {
+				if p.Setting != tt.setting[n] || p.Used != tt.used[n] {
+					t.Errorf("validateConfig() problem %d = %+v, want %s using %s",
+						n, p, tt.setting[n], tt.used[n])
+				}
+			}
+		})
+	}
+}
+
+func TestAutoScalingGroupValidate(t *testing.T) {
+	tag := func(key, value string) *autoscaling.TagDescription {
+		return &autoscaling.TagDescription{Key: aws.String(key), Value: aws.String(value)}
+	}
+
+	tests := []struct {
+		name    string
+		tags    []*autoscaling.TagDescription
+		setting []string
+		used    []string
+	}{
+		{name: "valid tags",
+			tags: []*autoscaling.TagDescription{
+				tag(OnDemandNumberLong, "1"),
+				tag(BiddingPolicyTag, "aggressive"),
+				tag(AllowedInstanceTypesTag, "m5.*,c5.*"),
+				tag(AllowBurstableInstanceTypesTag, "true"),
+				tag("spot-enabled", "true"),
+			},
+		},
+		{name: "number above MaxSize falls back to the percentage",
+			tags: []*autoscaling.TagDescription{
+				tag(OnDemandNumberLong, "5"),
+				tag(OnDemandPercentageTag, "50"),
+			},
+			setting: []string{OnDemandNumberLong},
+			used:    []string{"2"},
+		},
+		{name: "both number and percentage",
+			tags: []*autoscaling.TagDescription{
+				tag(OnDemandNumberLong, "3"),
+				tag(OnDemandPercentageTag, "50"),
+			},
+			setting: []string{OnDemandPercentageTag},
+			used:    []string{"3"},
+		},
+		{name: "invalid values",
+			tags: []*autoscaling.TagDescription{
+				tag(OnDemandPercentageTag, "half"),
+				tag(BiddingPolicyTag, "agressive"),
+				tag(SpotPriceBufferPercentageTag, "-5"),
+				tag(AllowBurstableInstanceTypesTag, "sometimes"),
+				tag(RevertToOnDemandTag, "yes please"),
+			},
+			setting: []string{OnDemandPercentageTag, BiddingPolicyTag,
+				SpotPriceBufferPercentageTag, AllowBurstableInstanceTypesTag,
+				RevertToOnDemandTag},
+			used: []string{"1", "aggressive", "10", "false", "false"},
+		},
+		{name: "unknown tag",
+			tags: []*autoscaling.TagDescription{
+				tag("autospotting_min_ondemand_number", "1"),
+			},
+			setting: []string{"autospotting_min_ondemand_number"},
+			used:    []string{"ignored"},
+		},
+		{name: "priority strategy",
+			tags: []*autoscaling.TagDescription{
+				tag(SelectionStrategyTag, PriorityStrategy),
+				tag(PreferredInstanceTypesTag, "m5.large,[m4"),
+			},
+			setting: []string{PreferredInstanceTypesTag},
+			used:    []string{"matches no instance type"},
+		},
+		{name: "priority strategy without preferred instance types",
+			tags: []*autoscaling.TagDescription{
+				tag(SelectionStrategyTag, PriorityStrategy),
+			},
+			setting: []string{SelectionStrategyTag},
+			used:    []string{"ordered by price"},
+		},
+		{name: "preferred instance types ignored by an unknown strategy",
+			tags: []*autoscaling.TagDescription{
+				tag(SelectionStrategyTag, "prioritized"),
+				tag(PreferredInstanceTypesTag, "m5.large"),
+			},
+			setting: []string{SelectionStrategyTag, PreferredInstanceTypesTag},
+			used:    []string{CheapestStrategy, CheapestStrategy},
+		},
+		{name: "interruption band",
+			tags: []*autoscaling.TagDescription{
+				tag(MaxInterruptionBandTag, "10-15%"),
+			},
+		},
+		{name: "unknown interruption band",
+			tags: []*autoscaling.TagDescription{
+				tag(MaxInterruptionBandTag, "rarely"),
+			},
+			setting: []string{MaxInterruptionBandTag},
+			used:    []string{"no limit"},
+		},
+		{name: "disallowed tag ignored because of the global allowed list",
+			tags: []*autoscaling.TagDescription{
+				tag(DisallowedInstanceTypesTag, "t2.*,[c4"),
+			},
+			setting: []string{DisallowedInstanceTypesTag, DisallowedInstanceTypesTag},
+			used:    []string{"matches no instance type", "m5.*"},
+		},
+		{name: "disallowed tag applied with an allowed policy tag",
+			tags: []*autoscaling.TagDescription{
+				tag(AllowedInstanceTypesTag, "same-family"),
+				tag(DisallowedInstanceTypesTag, "t2.*"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			running := &ec2.InstanceState{Name: aws.String("running")}
+			a := &autoScalingGroup{
+				name: "asg",
+				Group: &autoscaling.Group{
+					MaxSize: aws.Int64(4),
+					Tags:    tt.tags,
+				},
+				region: &region{
+					conf: &Config{
+						BiddingPolicy:             "aggressive",
+						MinOnDemandNumber:         1,
+						SpotPriceBufferPercentage: 10,
+						AllowedInstanceTypes:      "m5.*",
+					},
+				},
+				instances: makeInstancesWithCatalog(map[string]*instance{
+					"i-1": {Instance: &ec2.Instance{State: running}},
+					"i-2": {Instance: &ec2.Instance{State: running}},
+					"i-3": {Instance: &ec2.Instance{State: running}},
+					"i-4": {Instance: &ec2.Instance{State: running}},
+				}),
+			}
+
+			problems := a.validate()
+
+			if len(problems) != len(tt.setting) {
+				t.Fatalf("validate() = %+v, want problems with %v", problems, tt.setting)
+			}
+			for n, p := range problems {
+				if p.Setting != tt.setting[n] || p.Used != tt.used[n] {
+					t.Errorf("validate() problem %d = %+v, want %s using %s",
+						n, p, tt.setting[n], tt.used[n])
+				}
+			}
+		})
+	}
+}
+
+func TestProblemsWriteTable(t *testing.T) {
+	var out bytes.Buffer
+
+	if err := (Problems{}).WriteTable(&out); err != nil || out.String() != "No problems found\n" {
+		t.Errorf("WriteTable() = %q, %v", out.String(), err)
+	}
+
+	out.Reset()
+	Problems{{Region: "eu-west-1", Group: "asg", Setting: BiddingPolicyTag,
+		Value: "agressive", Problem: "unknown bidding policy", Used: "normal"}}.WriteTable(&out)
+
+	for _, want := range []string{
+		"ACCOUNT  REGION     ASG  SETTING",
+		"-        eu-west-1  asg  autospotting_bidding_policy  agressive  unknown bidding policy  normal",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("WriteTable() is missing %q in:\n%s", want, out.String())
+		}
+	}
+}